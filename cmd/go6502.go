@@ -1,10 +1,15 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
 	"os"
 	"runtime"
+	"strconv"
+	"strings"
 
+	"github.com/se-nonide/go6502/internal/commands"
 	"github.com/se-nonide/go6502/internal/renderer"
 )
 
@@ -15,8 +20,73 @@ func init() {
 
 func main() {
 	args := os.Args
-	if len(args) != 2 {
+	if len(args) > 1 {
+		switch args[1] {
+		case "disasm":
+			run(commands.Disasm(args[2:]))
+			return
+		case "test":
+			run(commands.Test(args[2:]))
+			return
+		case "screenshot":
+			run(commands.Screenshot(args[2:]))
+			return
+		case "info":
+			run(commands.Info(args[2:]))
+			return
+		}
+	}
+	var options renderer.Options
+	flags := flag.NewFlagSet(args[0], flag.ExitOnError)
+	flags.BoolVar(&options.Debug, "debug", false, "read debugger commands from stdin")
+	flags.BoolVar(&options.Fast, "fast", false, "use the faster instruction-level CPU core")
+	flags.StringVar(&options.Palette, "palette", "", "palette preset or .pal file")
+	flags.StringVar(&options.Filter, "ntsc", "", "NTSC filter: composite, svideo or rgb")
+	flags.StringVar(&options.Region, "region", "auto", "auto, ntsc, pal or dendy")
+	flags.BoolVar(&options.UnlimitedSprites, "nospritelimit", false, "draw more than 8 sprites per scanline")
+	flags.StringVar(&options.Database, "db", "", "extra game database file, or nes20db.xml")
+	flags.StringVar(&options.SaveDir, "savedir", "", "directory for .sav files (default: next to the game)")
+	flags.StringVar(&options.Trace, "trace", "", "write an instruction trace to a file, or - for stdout")
+	flags.StringVar(&options.Compare, "compare", "", "stop at the first difference from a reference trace log")
+	flags.Var((*address)(&options.StartPC), "pc", "start at this address instead of the reset vector, e.g. $C000")
+	flags.Var((*patchList)(&options.Patches), "patch", "IPS, UPS or BPS patch to apply, repeatable (default: the ones next to the game)")
+	flags.Parse(args[1:])
+	if flags.NArg() != 1 {
 		log.Fatal("Specify the path for a game to play")
 	}
-	renderer.Start(args[1])
+	renderer.Start(flags.Arg(0), options)
+}
+
+// patchList collects repeated -patch flags.
+type patchList []string
+
+func (p *patchList) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patchList) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
+// address parses a -pc flag, with or without a leading $.
+type address uint16
+
+func (a *address) String() string {
+	return fmt.Sprintf("$%04X", uint16(*a))
+}
+
+func (a *address) Set(value string) error {
+	n, err := strconv.ParseUint(strings.TrimPrefix(value, "$"), 16, 16)
+	if err != nil {
+		return fmt.Errorf("bad address %q", value)
+	}
+	*a = address(n)
+	return nil
+}
+
+func run(err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,151 @@
+package commands
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/se-nonide/go6502/pkg/disasm"
+	"github.com/se-nonide/go6502/pkg/loader"
+)
+
+// prgLayout is how a board maps PRG-ROM into the CPU address space: the size
+// of the banks it switches and where each bank is normally seen.
+type prgLayout struct {
+	size   int
+	origin func(bank, banks int) uint16
+}
+
+// switchableLayout puts every bank at base except the last fixed ones, which
+// sit at the top of the address space and hold the interrupt vectors.
+func switchableLayout(size int, base uint16, fixed int) prgLayout {
+	return prgLayout{size, func(bank, banks int) uint16 {
+		if bank >= banks-fixed {
+			return uint16(0x10000 - (banks-bank)*size)
+		}
+		return base
+	}}
+}
+
+// layoutFor returns the PRG layout of a mapper, or the common UxROM-like
+// one of 16KB banks at $8000 with the last fixed at $C000.
+func layoutFor(mapper uint16, prgSize int) prgLayout {
+	switch mapper {
+	case 0, 3:
+		// NROM-128 is mirrored at $8000 and $C000, code usually assumes $C000
+		if prgSize <= 0x4000 {
+			return prgLayout{prgSize, func(int, int) uint16 { return 0xC000 }}
+		}
+		return prgLayout{0x8000, func(int, int) uint16 { return 0x8000 }}
+	case 4:
+		// the two last 8KB banks are fixed at $C000 and $E000 in the
+		// usual PRG mode, the rest switch in at $8000 or $A000
+		return switchableLayout(0x2000, 0x8000, 2)
+	case 7:
+		return prgLayout{0x8000, func(int, int) uint16 { return 0x8000 }}
+	case 40:
+		return prgLayout{0x2000, func(bank, banks int) uint16 {
+			switch bank {
+			case 4:
+				return 0x8000
+			case 5:
+				return 0xA000
+			case 6:
+				return 0x6000
+			case 7:
+				return 0xE000
+			}
+			return 0xC000
+		}}
+	}
+	return switchableLayout(0x4000, 0x8000, 1)
+}
+
+// parseOrigin reads an address given as $C000, 0xC000 or C000.
+func parseOrigin(s string) (uint16, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(s), "$"), "0x")
+	n, err := strconv.ParseUint(s, 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("bad origin %q", s)
+	}
+	return uint16(n), nil
+}
+
+// Disasm implements "go6502 disasm [-bank n] [-org addr] [-symbols file]
+// <rom>". PRG banks are cut and placed as the ROM's mapper switches them,
+// 32KB at $8000 for AxROM or 8KB with the last two at $C000 and $E000 for
+// MMC3; -org lists every bank at one address instead.
+func Disasm(args []string) error {
+	flags := flag.NewFlagSet("disasm", flag.ContinueOnError)
+	bank := flags.Int("bank", -1, "only list this PRG bank")
+	org := flags.String("org", "", "list every bank at this address, e.g. $8000")
+	symbolsPath := flags.String("symbols", "", "file with \"ADDR label\" lines")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: go6502 disasm [-bank n] [-org addr] [-symbols file] <rom>")
+	}
+	cartridge, err := loader.LoadNESFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	layout := layoutFor(cartridge.Mapper, len(cartridge.PRG))
+	if len(cartridge.PRG) < layout.size {
+		return fmt.Errorf("PRG ROM is %d bytes, less than one %dKB bank of mapper %d",
+			len(cartridge.PRG), layout.size/1024, cartridge.Mapper)
+	}
+	if *org != "" {
+		address, err := parseOrigin(*org)
+		if err != nil {
+			return err
+		}
+		layout.origin = func(int, int) uint16 { return address }
+	}
+	banks := len(cartridge.PRG) / layout.size
+	if *bank >= banks {
+		return fmt.Errorf("bank %d out of range, the ROM has %d PRG banks of %dKB", *bank, banks, layout.size/1024)
+	}
+
+	last := cartridge.PRG[len(cartridge.PRG)-6:]
+	vector := func(offset int) uint16 {
+		return uint16(last[offset+1])<<8 | uint16(last[offset])
+	}
+	symbols := disasm.Symbols{
+		vector(0): "nmi",
+		vector(2): "reset",
+		vector(4): "irq",
+	}
+	if *symbolsPath != "" {
+		file, err := os.Open(*symbolsPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		user, err := disasm.ParseSymbols(file)
+		if err != nil {
+			return err
+		}
+		for address, label := range user {
+			symbols[address] = label
+		}
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	for i := 0; i < banks; i++ {
+		if *bank >= 0 && i != *bank {
+			continue
+		}
+		origin := layout.origin(i, banks)
+		code := cartridge.PRG[i*layout.size : (i+1)*layout.size]
+		fmt.Fprintf(out, "; PRG bank %d at $%04X\n", i, origin)
+		if err := disasm.Fprint(out, disasm.Disassemble(code, origin), symbols); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
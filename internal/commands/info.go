@@ -0,0 +1,112 @@
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/se-nonide/go6502/pkg/cartridge"
+	"github.com/se-nonide/go6502/pkg/loader"
+	"github.com/se-nonide/go6502/pkg/romdb"
+)
+
+var mirrorNames = map[byte]string{0: "horizontal", 1: "vertical", 4: "four-screen"}
+
+var timingNames = []string{"NTSC", "PAL", "multi-region", "Dendy"}
+
+var consoleNames = []string{"NES/Famicom", "Vs. System", "PlayChoice-10"}
+
+// Info implements "go6502 info [-db file] <rom>...", printing what each
+// header says and what the game database makes of it.
+func Info(args []string) error {
+	flags := flag.NewFlagSet("info", flag.ContinueOnError)
+	database := flags.String("db", "", "extra game database file, or nes20db.xml")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("usage: go6502 info [-db file] <rom>...")
+	}
+	if *database != "" {
+		if err := romdb.Default.ReadFile(*database); err != nil {
+			return err
+		}
+	}
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	for i, path := range flags.Args() {
+		if i > 0 {
+			fmt.Println()
+		}
+		c, err := loader.LoadNESFile(path)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		printInfo(path, c)
+	}
+	return nil
+}
+
+func printInfo(path string, c *cartridge.Cartridge) {
+	field := func(name, format string, args ...interface{}) {
+		fmt.Printf("  %-10s %s\n", name, fmt.Sprintf(format, args...))
+	}
+	fmt.Println(path)
+	format := "iNES"
+	if c.NES20 {
+		format = "NES 2.0"
+	}
+	field("header", format)
+	field("mapper", "%d, submapper %d", c.Mapper, c.Submapper)
+	field("PRG-ROM", "%s", kilobytes(len(c.PRG)))
+	if c.CHRRAMSize+c.CHRNVRAMSize == 0 {
+		field("CHR-ROM", "%s", kilobytes(len(c.CHR)))
+	} else {
+		field("CHR-RAM", "%s, %s battery-backed", kilobytes(c.CHRRAMSize), kilobytes(c.CHRNVRAMSize))
+	}
+	field("PRG-RAM", "%s, %s battery-backed", kilobytes(c.PRGRAMSize), kilobytes(c.PRGNVRAMSize))
+	field("mirroring", "%s", name(mirrorNames[c.Mirror], int(c.Mirror)))
+	field("battery", "%v", c.Battery == 1)
+	field("region", "%s", timingNames[c.Timing&3])
+	console := fmt.Sprintf("extended type %d", c.Console)
+	if int(c.Console) < len(consoleNames) {
+		console = consoleNames[c.Console]
+	}
+	field("console", "%s", console)
+	field("input", "%d", c.Expansion)
+	crc, sum := romdb.Hash(c)
+	field("CRC32", "%08X", crc)
+	field("SHA-1", "%s", sum)
+
+	entry := romdb.Default.Lookup(c)
+	if entry == nil {
+		field("database", "not found")
+		return
+	}
+	field("database", "%s", entry.Name)
+	changes := entry.Apply(c)
+	if len(changes) == 0 {
+		field("verdict", "header is correct")
+	}
+	for _, change := range changes {
+		field("verdict", "%s", change)
+	}
+}
+
+func kilobytes(size int) string {
+	if size%1024 != 0 {
+		return fmt.Sprintf("%d bytes", size)
+	}
+	return fmt.Sprintf("%d KB", size/1024)
+}
+
+func name(name string, value int) string {
+	if name == "" {
+		return fmt.Sprintf("unknown (%d)", value)
+	}
+	return name
+}
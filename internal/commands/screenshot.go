@@ -0,0 +1,69 @@
+package commands
+
+import (
+	"errors"
+	"flag"
+	"image"
+	"io"
+	"log"
+	"os"
+
+	"github.com/se-nonide/go6502/pkg/device6502"
+	"github.com/se-nonide/go6502/pkg/ntsc"
+	"github.com/se-nonide/go6502/pkg/pallete"
+	"github.com/se-nonide/go6502/pkg/ppuview"
+)
+
+// Screenshot implements "go6502 screenshot [-frames n] [-palette p]
+// [-ntsc filter] [-views prefix] <rom> <png>", running a ROM headlessly and
+// saving the last frame, and optionally the PPU views.
+func Screenshot(args []string) error {
+	flags := flag.NewFlagSet("screenshot", flag.ContinueOnError)
+	frames := flags.Int("frames", 60, "frames to run before the capture")
+	palette := flags.String("palette", "", "palette preset or .pal file")
+	filter := flags.String("ntsc", "", "NTSC filter: composite, svideo or rgb")
+	views := flags.String("views", "", "also save the PPU views to files starting with this prefix")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return errors.New("usage: go6502 screenshot [-frames n] [-palette p] [-ntsc filter] [-views prefix] <rom> <png>")
+	}
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	device, err := device6502.NewDevice(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *palette != "" {
+		table, err := pallete.Load(*palette)
+		if err != nil {
+			return err
+		}
+		device.SetPalette(table)
+	}
+	device.Reset()
+	for i := 0; i < *frames && device.Err() == nil; i++ {
+		device.StepFrame()
+	}
+	if err := device.Err(); err != nil {
+		return err
+	}
+	if *views != "" {
+		if _, err := ppuview.Export(device, *views); err != nil {
+			return err
+		}
+	}
+
+	var picture image.Image = device.Buffer()
+	if *filter != "" {
+		f, err := ntsc.NewPreset(*filter)
+		if err != nil {
+			return err
+		}
+		f.Palette = device.Palette()
+		picture = f.Render(device.FrameBuffer(), device.FramePhase(), nil)
+	}
+	return ppuview.SavePNG(flags.Arg(1), picture)
+}
@@ -0,0 +1,61 @@
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/se-nonide/go6502/pkg/testrom"
+)
+
+// Test implements "go6502 test [-timeout d] [-fast] [-result addr] <rom>...",
+// running each ROM headlessly and printing the result it reports at $6000,
+// or for older ROMs at the given address.
+func Test(args []string) error {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	var options testrom.Options
+	flags.DurationVar(&options.Timeout, "timeout", 30*time.Second, "emulated time allowed per ROM")
+	flags.BoolVar(&options.Fast, "fast", false, "use the faster instruction-level CPU core")
+	verbose := flags.Bool("v", false, "keep the emulator log")
+	resultAddress := flags.String("result", "", "address of the result code of ROMs older than the $6000 protocol, e.g. $F8")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("usage: go6502 test [-timeout d] [-fast] [-result addr] [-v] <rom>...")
+	}
+	if *resultAddress != "" {
+		address, err := strconv.ParseUint(strings.TrimPrefix(*resultAddress, "$"), 16, 16)
+		if err != nil {
+			return fmt.Errorf("bad result address %q", *resultAddress)
+		}
+		options.ResultAddress = uint16(address)
+	}
+	if !*verbose {
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+	failed := 0
+	for _, path := range flags.Args() {
+		result, err := testrom.Run(path, options)
+		if err != nil {
+			fmt.Printf("ERROR %s: %v\n", path, err)
+			failed++
+			continue
+		}
+		fmt.Println(result)
+		if result.Status != testrom.Passed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d ROMs failed", failed, flags.NArg())
+	}
+	return nil
+}
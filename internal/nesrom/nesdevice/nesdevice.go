@@ -0,0 +1,22 @@
+// Package nesdevice starts a device from an image built with nesrom, the
+// way the emulator loads a file but without touching the disk.
+package nesdevice
+
+import (
+	"github.com/se-nonide/go6502/pkg/device6502"
+	"github.com/se-nonide/go6502/pkg/loader"
+)
+
+// New loads an image and returns the device after its power-on reset.
+func New(image []byte) (*device6502.Device, error) {
+	cartridge, err := loader.LoadBytes(image)
+	if err != nil {
+		return nil, err
+	}
+	device, err := device6502.NewDeviceFromCartridge(cartridge)
+	if err != nil {
+		return nil, err
+	}
+	device.Reset()
+	return device, nil
+}
@@ -0,0 +1,42 @@
+// Package nesrom builds small iNES images for tests: a bare header over
+// zeroed ROM, or an NROM board running a program at $C000.
+package nesrom
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Origin is where NROM puts the program, the start of its only PRG bank.
+const Origin = 0xC000
+
+// Header returns a 16-byte iNES header with bytes 4-15 taken from fields.
+func Header(fields ...byte) []byte {
+	header := []byte{'N', 'E', 'S', 0x1A, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	copy(header[4:], fields)
+	return header
+}
+
+// Image returns a header with bytes 4-15 from fields followed by prg and
+// chr bytes of zeroed ROM.
+func Image(fields []byte, prg, chr int) []byte {
+	return append(Header(fields...), make([]byte, prg+chr)...)
+}
+
+// NROM returns a 16KB PRG, 8KB CHR image that resets into program at
+// $C000. chr is copied to the start of the CHR-ROM and flags set header
+// bytes 6-15.
+func NROM(program, chr []byte, flags ...byte) []byte {
+	image := Image(append([]byte{1, 1}, flags...), 0x4000, 0x2000)
+	prg := image[16 : 16+0x4000]
+	copy(prg, program)
+	prg[0x3FFC], prg[0x3FFD] = Origin&0xFF, Origin>>8
+	copy(image[16+0x4000:], chr)
+	return image
+}
+
+// Write saves an image as dir/test.nes and returns the path.
+func Write(dir string, image []byte) (string, error) {
+	path := filepath.Join(dir, "test.nes")
+	return path, os.WriteFile(path, image, 0644)
+}
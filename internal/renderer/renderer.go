@@ -1,13 +1,23 @@
 package renderer
 
 import (
+	"bufio"
+	"image"
+	"io"
 	"log"
+	"os"
 
 	"github.com/go-gl/gl/v2.1/gl"
 	"github.com/go-gl/glfw/v3.3/glfw"
 	"github.com/se-nonide/go6502/internal/gamepad"
 	"github.com/se-nonide/go6502/internal/graphics"
+	"github.com/se-nonide/go6502/pkg/debugger"
 	"github.com/se-nonide/go6502/pkg/device6502"
+	"github.com/se-nonide/go6502/pkg/loader"
+	"github.com/se-nonide/go6502/pkg/ntsc"
+	"github.com/se-nonide/go6502/pkg/pallete"
+	"github.com/se-nonide/go6502/pkg/ppuview"
+	"github.com/se-nonide/go6502/pkg/romdb"
 )
 
 const width = 256
@@ -15,23 +25,253 @@ const height = 240
 const scale = 4
 const FPS = 240
 
+// paletteFile is where the O key exports the active palette.
+const paletteFile = "go6502.pal"
+
+// batteryFlush is how often in seconds changed battery RAM is written out.
+const batteryFlush = 5
+
+// viewPrefix starts the names of the PPU views the V key exports.
+const viewPrefix = "go6502-"
+
+// Options configures the emulator window.
+type Options struct {
+	// Debug attaches a debugger that reads commands from stdin.
+	Debug bool
+	// Fast selects the instruction-level CPU core over the cycle-accurate one.
+	Fast bool
+	// Palette is a preset name or the path of a .pal file.
+	Palette string
+	// Filter names an NTSC filter preset, or is empty for the plain picture.
+	Filter string
+	// Region is ntsc, pal or dendy; empty or auto follows the ROM header.
+	Region string
+	// UnlimitedSprites draws every sprite on a line instead of 8.
+	UnlimitedSprites bool
+	// Patches are IPS, UPS or BPS files applied in order. Without any, the
+	// patches found next to the ROM are applied.
+	Patches []string
+	// Database is a game database file to use on top of the built-in one.
+	Database string
+	// SaveDir holds the .sav files of battery-backed games; empty keeps them
+	// next to the ROMs.
+	SaveDir string
+	// Trace is a file to write the instruction trace to from the start, or
+	// - for standard output.
+	Trace string
+	// Compare is a reference trace log, such as nestest.log, to check the
+	// run against; emulation stops at the first line that differs.
+	Compare string
+	// StartPC, when not zero, replaces the reset vector, as nestest's
+	// automatic mode at $C000 needs.
+	StartPC uint16
+}
+
 type Renderer struct {
+	window   *glfw.Window
+	nes      *device6502.Device
+	texture  uint32
+	debugger *debugger.Debugger
+	palette  *int // index into pallete.Presets, -1 for a .pal file
+	screen   *screen
+	battery  *device6502.BatterySaver
+	view     *liveView
+	traceLog *bufio.Writer // of the -trace file, flushed at exit
+}
+
+// liveView is a second window redrawing ppuview.Overview every frame while
+// it is open.
+type liveView struct {
 	window  *glfw.Window
-	nes     *device6502.Device
 	texture uint32
 }
 
-func NewRenderer(window *glfw.Window, path string) Renderer {
-	nes, err := device6502.NewDevice(path)
+func (v *liveView) open() {
+	window, err := glfw.CreateWindow(768, 480, "Go 6502 - PPU", nil, nil)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	window.SetSizeLimits(768, 480, 768, 480)
+	window.MakeContextCurrent()
+	glfw.SwapInterval(0)
+	gl.Enable(gl.TEXTURE_2D)
+	v.window = window
+	v.texture = graphics.CreateTexture()
+}
+
+func (v *liveView) close() {
+	v.window.Destroy()
+	v.window = nil
+}
+
+// render draws the views into the window's own context and makes the main
+// window's context current again.
+func (v *liveView) render(nes *device6502.Device, main *glfw.Window) {
+	if v.window.ShouldClose() {
+		v.close()
+		return
+	}
+	v.window.MakeContextCurrent()
+	gl.BindTexture(gl.TEXTURE_2D, v.texture)
+	graphics.SetTexture(ppuview.Overview(nes))
+	gl.Begin(gl.QUADS)
+	gl.TexCoord2f(0, 1)
+	gl.Vertex2f(-1, -1)
+	gl.TexCoord2f(1, 1)
+	gl.Vertex2f(1, -1)
+	gl.TexCoord2f(1, 0)
+	gl.Vertex2f(1, 1)
+	gl.TexCoord2f(0, 0)
+	gl.Vertex2f(-1, 1)
+	gl.End()
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+	v.window.SwapBuffers()
+	main.MakeContextCurrent()
+}
+
+// screen caches the filtered picture of the last frame.
+type screen struct {
+	filter *ntsc.Filter
+	name   int // index into ntsc.Presets, -1 without a filter
+	image  *image.RGBA
+	frame  uint64
+}
+
+func (s *screen) setFilter(name int) {
+	s.name = name
+	s.filter = nil
+	if name >= 0 {
+		s.filter, _ = ntsc.NewPreset(ntsc.Presets[name])
+	}
+	s.image = nil
+}
+
+func (s *screen) picture(nes *device6502.Device) *image.RGBA {
+	if s.filter == nil {
+		return nes.Buffer()
+	}
+	if s.image == nil || s.frame != nes.PPU.Frame {
+		s.filter.Palette = nes.Palette()
+		s.image = s.filter.Render(nes.FrameBuffer(), nes.FramePhase(), s.image)
+		s.frame = nes.PPU.Frame
+	}
+	return s.image
+}
+
+func NewRenderer(window *glfw.Window, path string, options Options) Renderer {
+	if options.Database != "" {
+		if err := romdb.Default.ReadFile(options.Database); err != nil {
+			log.Fatal(err)
+		}
+	}
+	patches := options.Patches
+	if len(patches) == 0 {
+		patches = loader.FindPatches(path)
+	}
+	cartridge, err := loader.LoadNESFilePatched(path, patches)
 	if err != nil {
 		log.Fatal(err)
 	}
+	nes, err := device6502.NewDeviceFromCartridge(cartridge)
+	if err != nil {
+		log.Fatal(err)
+	}
+	nes.SetCycleAccurate(!options.Fast)
+	if options.Region != "" && options.Region != "auto" {
+		region, err := device6502.ParseRegion(options.Region)
+		if err != nil {
+			log.Fatal(err)
+		}
+		nes.SetRegion(region)
+	}
+	log.Printf("Region: %v", nes.Region())
+	nes.SetUnlimitedSprites(options.UnlimitedSprites)
+	preset := 0
+	if options.Palette != "" {
+		palette, err := pallete.Load(options.Palette)
+		if err != nil {
+			log.Fatal(err)
+		}
+		nes.SetPalette(palette)
+		preset = presetIndex(options.Palette)
+	}
+	battery := device6502.NewBatterySaver(nes, device6502.SavePath(path, options.SaveDir))
+	if battery != nil {
+		if err := battery.Load(); err != nil {
+			log.Fatal(err)
+		}
+	}
 	nes.Reset()
+	if options.StartPC != 0 {
+		nes.CPU.PC = options.StartPC
+	}
 	texture := graphics.CreateTexture()
-	return Renderer{window: window, nes: nes, texture: texture}
+	renderer := Renderer{window: window, nes: nes, texture: texture, palette: &preset, screen: &screen{}, battery: battery, view: &liveView{}}
+	if options.Trace != "" || options.Compare != "" {
+		renderer.traceLog = startTrace(nes, options.Trace, options.Compare)
+	}
+	renderer.screen.setFilter(-1)
+	if options.Filter != "" {
+		if _, err := ntsc.NewPreset(options.Filter); err != nil {
+			log.Fatal(err)
+		}
+		for i, name := range ntsc.Presets {
+			if name == options.Filter {
+				renderer.screen.setFilter(i)
+			}
+		}
+	}
+	if options.Debug {
+		renderer.debugger = debugger.New(nes, os.Stdout)
+		renderer.debugger.Listen(os.Stdin)
+	}
+	return renderer
+}
+
+// startTrace attaches a tracer writing to the trace file, if one is given,
+// and comparing against the reference log, if one is given. It returns the
+// buffered writer of the trace.
+func startTrace(nes *device6502.Device, trace, compare string) *bufio.Writer {
+	var buffer *bufio.Writer
+	var writer io.Writer
+	switch trace {
+	case "":
+	case "-":
+		buffer = bufio.NewWriter(os.Stdout)
+		writer = buffer
+	default:
+		file, err := os.Create(trace)
+		if err != nil {
+			log.Fatal(err)
+		}
+		buffer = bufio.NewWriter(file)
+		writer = buffer
+	}
+	tracer := device6502.NewTracer(writer)
+	if compare != "" {
+		reference, err := os.Open(compare)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tracer.Compare(reference)
+	}
+	nes.SetTracer(tracer)
+	return buffer
+}
+
+// presetIndex returns the position of a preset in pallete.Presets, or -1
+// when name is a file so that P starts from the first preset.
+func presetIndex(name string) int {
+	for i, preset := range pallete.Presets {
+		if preset == name {
+			return i
+		}
+	}
+	return -1
 }
 
-func Start(path string) {
+func Start(path string, options Options) {
 	err := glfw.Init()
 	if err != nil {
 		log.Fatal(err)
@@ -49,20 +289,30 @@ func Start(path string) {
 	}
 	gl.Enable(gl.TEXTURE_2D)
 	gl.ClearColor(0, 0, 0, 1)
-	renderer := NewRenderer(window, path)
+	renderer := NewRenderer(window, path, options)
 	renderer.Run()
 }
 
 func (r Renderer) Run() {
 	var deltaTime float64 = 0
 	var timestamp float64 = glfw.GetTime()
+	var halted error
+	var flushed float64 = timestamp
 	r.window.SetKeyCallback(r.onKey)
 	for !r.window.ShouldClose() {
 		gl.Clear(gl.COLOR_BUFFER_BIT)
 		current := glfw.GetTime()
 		deltaTime += (current - timestamp)
 		timestamp = current
-		r.nes.StepSeconds(deltaTime)
+		if current-flushed >= batteryFlush {
+			flushed = current
+			r.flushBattery()
+		}
+		r.step(deltaTime)
+		if err := r.nes.Err(); (err == nil) != (halted == nil) {
+			halted = err
+			r.reportHalt(err)
+		}
 		if deltaTime >= (1.0 / FPS) {
 			deltaTime = 0
 			r.Render()
@@ -71,14 +321,57 @@ func (r Renderer) Run() {
 		r.window.SwapBuffers()
 		glfw.PollEvents()
 	}
+	if r.view.window != nil {
+		r.view.close()
+	}
+	if r.traceLog != nil {
+		if err := r.traceLog.Flush(); err != nil {
+			log.Print(err)
+		}
+	}
+	if r.battery != nil {
+		if err := r.battery.Close(); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+func (r Renderer) flushBattery() {
+	if r.battery == nil {
+		return
+	}
+	if err := r.battery.Flush(); err != nil {
+		log.Print(err)
+	}
+}
+
+func (r Renderer) step(seconds float64) {
+	if r.debugger == nil {
+		r.nes.StepSeconds(seconds)
+		return
+	}
+	r.debugger.Poll()
+	r.debugger.StepSeconds(seconds)
 }
 
 func (r Renderer) Render() {
 	updateControllers(r.window, r.nes)
 	gl.BindTexture(gl.TEXTURE_2D, r.texture)
-	graphics.SetTexture(r.nes.Buffer())
+	graphics.SetTexture(r.screen.picture(r.nes))
 	r.drawBuffer(r.window)
 	gl.BindTexture(gl.TEXTURE_2D, 0)
+	if r.view.window != nil {
+		r.view.render(r.nes, r.window)
+	}
+}
+
+func (r Renderer) reportHalt(err error) {
+	if err != nil {
+		log.Print(err)
+		r.window.SetTitle("Go 6502 - " + err.Error())
+	} else {
+		r.window.SetTitle("Go 6502")
+	}
 }
 
 func (r Renderer) onKey(window *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
@@ -87,6 +380,56 @@ func (r Renderer) onKey(window *glfw.Window, key glfw.Key, scancode int, action
 		case glfw.KeyR:
 			log.Print("Reset")
 			r.nes.Reset()
+		case glfw.KeyT:
+			tracer := r.nes.Tracer()
+			if tracer == nil {
+				tracer = device6502.NewTracer(os.Stdout)
+				tracer.SetEnabled(false)
+				r.nes.SetTracer(tracer)
+			}
+			tracer.SetEnabled(!tracer.Enabled())
+			log.Printf("Trace enabled: %v", tracer.Enabled())
+		case glfw.KeyP:
+			*r.palette = (*r.palette + 1) % len(pallete.Presets)
+			name := pallete.Presets[*r.palette]
+			palette, _ := pallete.Preset(name)
+			r.nes.SetPalette(palette)
+			log.Printf("Palette: %s", name)
+		case glfw.KeyL:
+			r.nes.SetUnlimitedSprites(!r.nes.UnlimitedSprites())
+			log.Printf("Sprite limit removed: %v", r.nes.UnlimitedSprites())
+		case glfw.KeyN:
+			name := r.screen.name + 1
+			if name == len(ntsc.Presets) {
+				name = -1
+			}
+			r.screen.setFilter(name)
+			if name < 0 {
+				log.Print("NTSC filter off")
+			} else {
+				log.Printf("NTSC filter: %s", ntsc.Presets[name])
+			}
+		case glfw.KeyO:
+			if err := r.nes.Palette().SaveFile(paletteFile); err != nil {
+				log.Print(err)
+			} else {
+				log.Printf("Palette exported to %s", paletteFile)
+			}
+		case glfw.KeyI:
+			if r.view.window == nil {
+				r.view.open()
+				r.window.MakeContextCurrent()
+			} else {
+				r.view.close()
+			}
+		case glfw.KeyV:
+			files, err := ppuview.Export(r.nes, viewPrefix)
+			for _, file := range files {
+				log.Printf("PPU view exported to %s", file)
+			}
+			if err != nil {
+				log.Print(err)
+			}
 		}
 	}
 }
@@ -4,16 +4,88 @@ import "encoding/gob"
 
 type Cartridge struct {
 	PRG     []byte // PRG-ROM banks
-	CHR     []byte // CHR-ROM banks
-	SRAM    []byte // Save RAM
-	Mapper  byte   // mapper type
+	CHR     []byte // CHR-ROM banks, or CHR-RAM on boards without ROM
+	SRAM    []byte // Save RAM: PRG-RAM followed by PRG-NVRAM
+	Mapper  uint16 // mapper type, up to 4095 with NES 2.0
 	Mirror  byte   // mirroring mode
 	Battery byte   // battery present
+	Timing  byte   // TV system the game was made for
+	VRAM    []byte // nametable RAM on the board, for four-screen mirroring
+
+	Submapper    byte // board variant within the mapper, NES 2.0 only
+	PRGRAMSize   int  // bytes of volatile RAM at $6000
+	PRGNVRAMSize int  // bytes of battery-backed RAM at $6000
+	CHRRAMSize   int  // bytes of volatile CHR-RAM
+	CHRNVRAMSize int  // bytes of battery-backed CHR-RAM
+	Console      byte // ConsoleNES, ConsoleVs, ConsolePlaychoice or an extended type
+	VsPPU        byte // Vs. System PPU model, NES 2.0 only
+	VsHardware   byte // Vs. System hardware and protection, NES 2.0 only
+	Expansion    byte // default expansion device, NES 2.0 only
+	NES20        bool // the header was in NES 2.0 format
+}
+
+// CPU/PPU timings a header can ask for
+const (
+	TimingNTSC  = 0
+	TimingPAL   = 1
+	TimingMulti = 2 // runs on either
+	TimingDendy = 3
+)
+
+// Console types. NES 2.0 headers can name further extended types, 3 to 15,
+// for famiclones and VT0x systems.
+const (
+	ConsoleNES        = 0
+	ConsoleVs         = 1
+	ConsolePlaychoice = 2
+)
+
+func NewCartridge(prg, chr []byte, mapper uint16, mirror, battery byte) *Cartridge {
+	c := &Cartridge{PRG: prg, CHR: chr, Mapper: mapper, Mirror: mirror, Battery: battery}
+	prgRAM, prgNVRAM, chrRAM := 0x2000, 0, 0
+	if battery == 1 {
+		prgRAM, prgNVRAM = 0, 0x2000
+	}
+	if len(chr) == 0 {
+		chrRAM = 0x2000
+	}
+	c.SetRAMSizes(prgRAM, prgNVRAM, chrRAM, 0)
+	return c
+}
+
+// SetRAMSizes records the board's RAM sizes in bytes and allocates SRAM to
+// match. CHR is replaced by CHR-RAM of the new size unless it holds ROM;
+// boards without CHR-ROM get 8KB even when the sizes give none, since every
+// mapper needs pattern memory.
+func (cartridge *Cartridge) SetRAMSizes(prgRAM, prgNVRAM, chrRAM, chrNVRAM int) {
+	chrIsRAM := len(cartridge.CHR) == 0 || cartridge.CHRRAMSize+cartridge.CHRNVRAMSize > 0
+	cartridge.PRGRAMSize, cartridge.PRGNVRAMSize = prgRAM, prgNVRAM
+	cartridge.CHRRAMSize, cartridge.CHRNVRAMSize = chrRAM, chrNVRAM
+	cartridge.SRAM = make([]byte, prgRAM+prgNVRAM)
+	if chrIsRAM {
+		size := chrRAM + chrNVRAM
+		if size == 0 {
+			size = 0x2000
+		}
+		cartridge.CHR = make([]byte, size)
+	}
+}
+
+// ReadSRAM reads from $6000-$7FFF, mirroring RAM smaller than 8KB. Without
+// any RAM the CPU sees open bus, which is the high byte of the address for
+// absolute reads.
+func (cartridge *Cartridge) ReadSRAM(address uint16) byte {
+	if len(cartridge.SRAM) == 0 {
+		return byte(address >> 8)
+	}
+	return cartridge.SRAM[(int(address)-0x6000)%len(cartridge.SRAM)]
 }
 
-func NewCartridge(prg, chr []byte, mapper, mirror, battery byte) *Cartridge {
-	sram := make([]byte, 0x2000)
-	return &Cartridge{prg, chr, sram, mapper, mirror, battery}
+func (cartridge *Cartridge) WriteSRAM(address uint16, value byte) {
+	if len(cartridge.SRAM) == 0 {
+		return
+	}
+	cartridge.SRAM[(int(address)-0x6000)%len(cartridge.SRAM)] = value
 }
 
 func (cartridge *Cartridge) Save(encoder *gob.Encoder) error {
@@ -21,6 +93,7 @@ func (cartridge *Cartridge) Save(encoder *gob.Encoder) error {
 	encoder.Encode(cartridge.CHR)
 	encoder.Encode(cartridge.SRAM)
 	encoder.Encode(cartridge.Mirror)
+	encoder.Encode(cartridge.VRAM)
 	return nil
 }
 
@@ -29,5 +102,6 @@ func (cartridge *Cartridge) Load(decoder *gob.Decoder) error {
 	decoder.Decode(&cartridge.CHR)
 	decoder.Decode(&cartridge.SRAM)
 	decoder.Decode(&cartridge.Mirror)
+	decoder.Decode(&cartridge.VRAM)
 	return nil
 }
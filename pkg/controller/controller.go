@@ -37,6 +37,14 @@ func (c *Controller) Read() byte {
 	return value
 }
 
+// Peek returns the bit the next Read will return without shifting.
+func (c *Controller) Peek() byte {
+	if c.index < 8 && c.buttons[c.index] {
+		return 1
+	}
+	return 0
+}
+
 func (c *Controller) Write(value byte) {
 	c.strobe = value
 	if c.strobe&1 == 1 {
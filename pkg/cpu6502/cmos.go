@@ -0,0 +1,253 @@
+package cpu6502
+
+// WDC 65C02 tables. Opcodes left undefined by the CMOS redesign are NOPs of
+// various lengths instead of the NMOS undocumented instructions.
+
+var cmosInstructions = instructionSet{
+	&cmosNames, &cmosModes, &cmosSizes, &cmosCycles, &cmosPageCycles,
+}
+
+// Addressing mode for each instruction
+var cmosModes = [256]byte{
+	6, 7, 5, 6, 11, 11, 11, 11, 6, 5, 4, 6, 1, 1, 1, 16,
+	10, 9, 14, 6, 11, 12, 12, 11, 6, 3, 4, 6, 1, 2, 2, 16,
+	1, 7, 5, 6, 11, 11, 11, 11, 6, 5, 4, 6, 1, 1, 1, 16,
+	10, 9, 14, 6, 12, 12, 12, 11, 6, 3, 4, 6, 2, 2, 2, 16,
+	6, 7, 5, 6, 11, 11, 11, 11, 6, 5, 4, 6, 1, 1, 1, 16,
+	10, 9, 14, 6, 12, 12, 12, 11, 6, 3, 6, 6, 1, 2, 2, 16,
+	6, 7, 5, 6, 11, 11, 11, 11, 6, 5, 4, 6, 8, 1, 1, 16,
+	10, 9, 14, 6, 12, 12, 12, 11, 6, 3, 6, 6, 15, 2, 2, 16,
+	10, 7, 5, 6, 11, 11, 11, 11, 6, 5, 6, 6, 1, 1, 1, 16,
+	10, 9, 14, 6, 12, 12, 13, 11, 6, 3, 6, 6, 1, 2, 2, 16,
+	5, 7, 5, 6, 11, 11, 11, 11, 6, 5, 6, 6, 1, 1, 1, 16,
+	10, 9, 14, 6, 12, 12, 13, 11, 6, 3, 6, 6, 2, 2, 3, 16,
+	5, 7, 5, 6, 11, 11, 11, 11, 6, 5, 6, 6, 1, 1, 1, 16,
+	10, 9, 14, 6, 12, 12, 12, 11, 6, 3, 6, 6, 1, 2, 2, 16,
+	5, 7, 5, 6, 11, 11, 11, 11, 6, 5, 6, 6, 1, 1, 1, 16,
+	10, 9, 14, 6, 12, 12, 12, 11, 6, 3, 6, 6, 1, 2, 2, 16,
+}
+
+// Size of each instruction in bytes, filled in from the modes
+var cmosSizes [256]byte
+
+func init() {
+	for opcode, mode := range cmosModes {
+		switch mode {
+		case ModeAccumulator, ModeImplied:
+			cmosSizes[opcode] = 1
+		case ModeAbsolute, ModeAbsoluteX, ModeAbsoluteY, ModeIndirect,
+			ModeAbsoluteIndexedIndirect, ModeZeroPageRelative:
+			cmosSizes[opcode] = 3
+		default:
+			cmosSizes[opcode] = 2
+		}
+	}
+	// BRK skips a padding byte
+	cmosSizes[0x00] = 2
+}
+
+// Cycles used by each instruction
+var cmosCycles = [256]byte{
+	7, 6, 2, 1, 5, 3, 5, 5, 3, 2, 2, 1, 6, 4, 6, 5,
+	2, 5, 5, 1, 5, 4, 6, 5, 2, 4, 2, 1, 6, 4, 6, 5,
+	6, 6, 2, 1, 3, 3, 5, 5, 4, 2, 2, 1, 4, 4, 6, 5,
+	2, 5, 5, 1, 4, 4, 6, 5, 2, 4, 2, 1, 4, 4, 6, 5,
+	6, 6, 2, 1, 3, 3, 5, 5, 3, 2, 2, 1, 3, 4, 6, 5,
+	2, 5, 5, 1, 4, 4, 6, 5, 2, 4, 3, 1, 8, 4, 6, 5,
+	6, 6, 2, 1, 3, 3, 5, 5, 4, 2, 2, 1, 6, 4, 6, 5,
+	2, 5, 5, 1, 4, 4, 6, 5, 2, 4, 4, 1, 6, 4, 6, 5,
+	2, 6, 2, 1, 3, 3, 3, 5, 2, 2, 2, 1, 4, 4, 4, 5,
+	2, 6, 5, 1, 4, 4, 4, 5, 2, 5, 2, 1, 4, 5, 5, 5,
+	2, 6, 2, 1, 3, 3, 3, 5, 2, 2, 2, 1, 4, 4, 4, 5,
+	2, 5, 5, 1, 4, 4, 4, 5, 2, 4, 2, 1, 4, 4, 4, 5,
+	2, 6, 2, 1, 3, 3, 5, 5, 2, 2, 2, 3, 4, 4, 6, 5,
+	2, 5, 5, 1, 4, 4, 6, 5, 2, 4, 3, 3, 4, 4, 7, 5,
+	2, 6, 2, 1, 3, 3, 5, 5, 2, 2, 2, 1, 4, 4, 6, 5,
+	2, 5, 5, 1, 4, 4, 6, 5, 2, 4, 4, 1, 4, 4, 7, 5,
+}
+
+// Cycles used by each instruction when a page is crossed
+var cmosPageCycles = [256]byte{
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	1, 1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1, 1, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	1, 1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 1, 1, 1, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	1, 1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1, 1, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	1, 1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1, 1, 0,
+	1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	1, 1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 1, 1, 1, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	1, 1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	1, 1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0,
+}
+
+// Name of each instruction
+var cmosNames = [256]string{
+	"BRK", "ORA", "NOP", "NOP", "TSB", "ORA", "ASL", "RMB0",
+	"PHP", "ORA", "ASL", "NOP", "TSB", "ORA", "ASL", "BBR0",
+	"BPL", "ORA", "ORA", "NOP", "TRB", "ORA", "ASL", "RMB1",
+	"CLC", "ORA", "INC", "NOP", "TRB", "ORA", "ASL", "BBR1",
+	"JSR", "AND", "NOP", "NOP", "BIT", "AND", "ROL", "RMB2",
+	"PLP", "AND", "ROL", "NOP", "BIT", "AND", "ROL", "BBR2",
+	"BMI", "AND", "AND", "NOP", "BIT", "AND", "ROL", "RMB3",
+	"SEC", "AND", "DEC", "NOP", "BIT", "AND", "ROL", "BBR3",
+	"RTI", "EOR", "NOP", "NOP", "NOP", "EOR", "LSR", "RMB4",
+	"PHA", "EOR", "LSR", "NOP", "JMP", "EOR", "LSR", "BBR4",
+	"BVC", "EOR", "EOR", "NOP", "NOP", "EOR", "LSR", "RMB5",
+	"CLI", "EOR", "PHY", "NOP", "NOP", "EOR", "LSR", "BBR5",
+	"RTS", "ADC", "NOP", "NOP", "STZ", "ADC", "ROR", "RMB6",
+	"PLA", "ADC", "ROR", "NOP", "JMP", "ADC", "ROR", "BBR6",
+	"BVS", "ADC", "ADC", "NOP", "STZ", "ADC", "ROR", "RMB7",
+	"SEI", "ADC", "PLY", "NOP", "JMP", "ADC", "ROR", "BBR7",
+	"BRA", "STA", "NOP", "NOP", "STY", "STA", "STX", "SMB0",
+	"DEY", "BIT", "TXA", "NOP", "STY", "STA", "STX", "BBS0",
+	"BCC", "STA", "STA", "NOP", "STY", "STA", "STX", "SMB1",
+	"TYA", "STA", "TXS", "NOP", "STZ", "STA", "STZ", "BBS1",
+	"LDY", "LDA", "LDX", "NOP", "LDY", "LDA", "LDX", "SMB2",
+	"TAY", "LDA", "TAX", "NOP", "LDY", "LDA", "LDX", "BBS2",
+	"BCS", "LDA", "LDA", "NOP", "LDY", "LDA", "LDX", "SMB3",
+	"CLV", "LDA", "TSX", "NOP", "LDY", "LDA", "LDX", "BBS3",
+	"CPY", "CMP", "NOP", "NOP", "CPY", "CMP", "DEC", "SMB4",
+	"INY", "CMP", "DEX", "WAI", "CPY", "CMP", "DEC", "BBS4",
+	"BNE", "CMP", "CMP", "NOP", "NOP", "CMP", "DEC", "SMB5",
+	"CLD", "CMP", "PHX", "STP", "NOP", "CMP", "DEC", "BBS5",
+	"CPX", "SBC", "NOP", "NOP", "CPX", "SBC", "INC", "SMB6",
+	"INX", "SBC", "NOP", "NOP", "CPX", "SBC", "INC", "BBS6",
+	"BEQ", "SBC", "SBC", "NOP", "NOP", "SBC", "INC", "SMB7",
+	"SED", "SBC", "PLX", "NOP", "NOP", "SBC", "INC", "BBS7",
+}
+
+func (c *CPU) createCMOSTable() {
+	c.table = [256]func(*stepInfo){
+		c.brk, c.ora, c.nop, c.nop, c.tsb, c.ora, c.asl, c.rmb(0),
+		c.php, c.ora, c.asl, c.nop, c.tsb, c.ora, c.asl, c.bbr(0),
+		c.bpl, c.ora, c.ora, c.nop, c.trb, c.ora, c.asl, c.rmb(1),
+		c.clc, c.ora, c.inc, c.nop, c.trb, c.ora, c.asl, c.bbr(1),
+		c.jsr, c.and, c.nop, c.nop, c.bit, c.and, c.rol, c.rmb(2),
+		c.plp, c.and, c.rol, c.nop, c.bit, c.and, c.rol, c.bbr(2),
+		c.bmi, c.and, c.and, c.nop, c.bit, c.and, c.rol, c.rmb(3),
+		c.sec, c.and, c.dec, c.nop, c.bit, c.and, c.rol, c.bbr(3),
+		c.rti, c.eor, c.nop, c.nop, c.nop, c.eor, c.lsr, c.rmb(4),
+		c.pha, c.eor, c.lsr, c.nop, c.jmp, c.eor, c.lsr, c.bbr(4),
+		c.bvc, c.eor, c.eor, c.nop, c.nop, c.eor, c.lsr, c.rmb(5),
+		c.cli, c.eor, c.phy, c.nop, c.nop, c.eor, c.lsr, c.bbr(5),
+		c.rts, c.adc, c.nop, c.nop, c.stz, c.adc, c.ror, c.rmb(6),
+		c.pla, c.adc, c.ror, c.nop, c.jmp, c.adc, c.ror, c.bbr(6),
+		c.bvs, c.adc, c.adc, c.nop, c.stz, c.adc, c.ror, c.rmb(7),
+		c.sei, c.adc, c.ply, c.nop, c.jmp, c.adc, c.ror, c.bbr(7),
+		c.bra, c.sta, c.nop, c.nop, c.sty, c.sta, c.stx, c.smb(0),
+		c.dey, c.bit, c.txa, c.nop, c.sty, c.sta, c.stx, c.bbs(0),
+		c.bcc, c.sta, c.sta, c.nop, c.sty, c.sta, c.stx, c.smb(1),
+		c.tya, c.sta, c.txs, c.nop, c.stz, c.sta, c.stz, c.bbs(1),
+		c.ldy, c.lda, c.ldx, c.nop, c.ldy, c.lda, c.ldx, c.smb(2),
+		c.tay, c.lda, c.tax, c.nop, c.ldy, c.lda, c.ldx, c.bbs(2),
+		c.bcs, c.lda, c.lda, c.nop, c.ldy, c.lda, c.ldx, c.smb(3),
+		c.clv, c.lda, c.tsx, c.nop, c.ldy, c.lda, c.ldx, c.bbs(3),
+		c.cpy, c.cmp, c.nop, c.nop, c.cpy, c.cmp, c.dec, c.smb(4),
+		c.iny, c.cmp, c.dex, c.wai, c.cpy, c.cmp, c.dec, c.bbs(4),
+		c.bne, c.cmp, c.cmp, c.nop, c.nop, c.cmp, c.dec, c.smb(5),
+		c.cld, c.cmp, c.phx, c.stp, c.nop, c.cmp, c.dec, c.bbs(5),
+		c.cpx, c.sbc, c.nop, c.nop, c.cpx, c.sbc, c.inc, c.smb(6),
+		c.inx, c.sbc, c.nop, c.nop, c.cpx, c.sbc, c.inc, c.bbs(6),
+		c.beq, c.sbc, c.sbc, c.nop, c.nop, c.sbc, c.inc, c.smb(7),
+		c.sed, c.sbc, c.plx, c.nop, c.nop, c.sbc, c.inc, c.bbs(7),
+	}
+}
+
+func (cpu *CPU) bra(info *stepInfo) {
+	cpu.PC = info.address
+	cpu.addBranchCycles(info)
+}
+
+func (cpu *CPU) phx(info *stepInfo) {
+	cpu.push(cpu.X)
+}
+
+func (cpu *CPU) phy(info *stepInfo) {
+	cpu.push(cpu.Y)
+}
+
+func (cpu *CPU) plx(info *stepInfo) {
+	cpu.dummyRead(0x100 | uint16(cpu.SP))
+	cpu.X = cpu.pull()
+	cpu.setZN(cpu.X)
+}
+
+func (cpu *CPU) ply(info *stepInfo) {
+	cpu.dummyRead(0x100 | uint16(cpu.SP))
+	cpu.Y = cpu.pull()
+	cpu.setZN(cpu.Y)
+}
+
+func (cpu *CPU) stp(info *stepInfo) {
+	cpu.PC--
+	cpu.jammed = true
+}
+
+func (cpu *CPU) stz(info *stepInfo) {
+	cpu.Write(info.address, 0)
+}
+
+func (cpu *CPU) trb(info *stepInfo) {
+	value := cpu.Read(info.address)
+	cpu.dummyRead(info.address)
+	cpu.setZ(value & cpu.A)
+	cpu.Write(info.address, value&^cpu.A)
+}
+
+func (cpu *CPU) tsb(info *stepInfo) {
+	value := cpu.Read(info.address)
+	cpu.dummyRead(info.address)
+	cpu.setZ(value & cpu.A)
+	cpu.Write(info.address, value|cpu.A)
+}
+
+func (cpu *CPU) wai(info *stepInfo) {
+	cpu.waiting = true
+}
+
+// rmb and smb clear and set one bit of a zero page location
+func (cpu *CPU) rmb(bit byte) func(*stepInfo) {
+	return func(info *stepInfo) {
+		value := cpu.Read(info.address)
+		cpu.dummyRead(info.address)
+		cpu.Write(info.address, value&^(1<<bit))
+	}
+}
+
+func (cpu *CPU) smb(bit byte) func(*stepInfo) {
+	return func(info *stepInfo) {
+		value := cpu.Read(info.address)
+		cpu.dummyRead(info.address)
+		cpu.Write(info.address, value|1<<bit)
+	}
+}
+
+// bbr and bbs test one bit of a zero page location and branch on it; the
+// offset is the last byte of the instruction
+func (cpu *CPU) bbr(bit byte) func(*stepInfo) {
+	return func(info *stepInfo) {
+		cpu.branchOnBit(info, cpu.Read(info.address)&(1<<bit) == 0)
+	}
+}
+
+func (cpu *CPU) bbs(bit byte) func(*stepInfo) {
+	return func(info *stepInfo) {
+		cpu.branchOnBit(info, cpu.Read(info.address)&(1<<bit) != 0)
+	}
+}
+
+func (cpu *CPU) branchOnBit(info *stepInfo, taken bool) {
+	offset := cpu.Read(info.pc - 1)
+	cpu.dummyRead(info.address)
+	if taken {
+		target := info.pc + uint16(int8(offset))
+		cpu.PC = target
+		cpu.addBranchCycles(&stepInfo{target, info.pc, info.mode})
+	}
+}
@@ -0,0 +1,134 @@
+package cpu6502
+
+import "testing"
+
+func TestCMOSInstructions(t *testing.T) {
+	tests := []struct {
+		name    string
+		program []byte
+		setup   func(*CPU, *testMemory)
+		check   func(*CPU, *testMemory) bool
+	}{
+		{
+			"STZ", []byte{0x64, 0x10},
+			func(cpu *CPU, mem *testMemory) { mem[0x10] = 0xFF },
+			func(cpu *CPU, mem *testMemory) bool { return mem[0x10] == 0 },
+		},
+		{
+			"BRA", []byte{0x80, 0x10},
+			nil,
+			func(cpu *CPU, mem *testMemory) bool { return cpu.PC == testOrigin+0x12 },
+		},
+		{
+			"TSB", []byte{0x04, 0x10},
+			func(cpu *CPU, mem *testMemory) { cpu.A = 0x0F; mem[0x10] = 0xF0 },
+			func(cpu *CPU, mem *testMemory) bool { return mem[0x10] == 0xFF && cpu.Z == 1 },
+		},
+		{
+			"TRB", []byte{0x14, 0x10},
+			func(cpu *CPU, mem *testMemory) { cpu.A = 0x11; mem[0x10] = 0xF1 },
+			func(cpu *CPU, mem *testMemory) bool { return mem[0x10] == 0xE0 && cpu.Z == 0 },
+		},
+		{
+			"LDA (zp)", []byte{0xB2, 0x10},
+			func(cpu *CPU, mem *testMemory) { mem[0x10] = 0x00; mem[0x11] = 0x03; mem[0x0300] = 0x42 },
+			func(cpu *CPU, mem *testMemory) bool { return cpu.A == 0x42 },
+		},
+		{
+			"JMP (abs) crosses pages", []byte{0x6C, 0xFF, 0x02},
+			func(cpu *CPU, mem *testMemory) { mem[0x02FF] = 0x34; mem[0x0300] = 0x12 },
+			func(cpu *CPU, mem *testMemory) bool { return cpu.PC == 0x1234 },
+		},
+		{
+			"JMP (abs,X)", []byte{0x7C, 0x00, 0x03},
+			func(cpu *CPU, mem *testMemory) { cpu.X = 2; mem[0x0302] = 0x34; mem[0x0303] = 0x12 },
+			func(cpu *CPU, mem *testMemory) bool { return cpu.PC == 0x1234 },
+		},
+		{
+			"INC A", []byte{0x1A},
+			func(cpu *CPU, mem *testMemory) { cpu.A = 0x7F },
+			func(cpu *CPU, mem *testMemory) bool { return cpu.A == 0x80 && cpu.N == 1 },
+		},
+		{
+			"PHX", []byte{0xDA},
+			func(cpu *CPU, mem *testMemory) { cpu.X = 0x42 },
+			func(cpu *CPU, mem *testMemory) bool { return mem[0x01FD] == 0x42 && cpu.SP == 0xFC },
+		},
+		{
+			"BIT #imm", []byte{0x89, 0xC0},
+			func(cpu *CPU, mem *testMemory) { cpu.A = 0x01 },
+			func(cpu *CPU, mem *testMemory) bool { return cpu.Z == 1 && cpu.N == 0 && cpu.V == 0 },
+		},
+		{
+			"RMB3", []byte{0x37, 0x10},
+			func(cpu *CPU, mem *testMemory) { mem[0x10] = 0xFF },
+			func(cpu *CPU, mem *testMemory) bool { return mem[0x10] == 0xF7 },
+		},
+		{
+			"BBS7 taken", []byte{0xFF, 0x10, 0x05},
+			func(cpu *CPU, mem *testMemory) { mem[0x10] = 0x80 },
+			func(cpu *CPU, mem *testMemory) bool { return cpu.PC == testOrigin+3+5 },
+		},
+		{
+			"BBR7 not taken", []byte{0x7F, 0x10, 0x05},
+			func(cpu *CPU, mem *testMemory) { mem[0x10] = 0x80 },
+			func(cpu *CPU, mem *testMemory) bool { return cpu.PC == testOrigin+3 },
+		},
+		{
+			"BRK clears D", []byte{0x00, 0x00},
+			func(cpu *CPU, mem *testMemory) { cpu.D = 1 },
+			func(cpu *CPU, mem *testMemory) bool { return cpu.D == 0 && mem[0x01FB]&0x08 != 0 },
+		},
+		{
+			"STP", []byte{0xDB},
+			nil,
+			func(cpu *CPU, mem *testMemory) bool { return cpu.Jammed() && cpu.PC == testOrigin },
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cpu, mem := newVariantCPU(CMOS, tc.program)
+			if tc.setup != nil {
+				tc.setup(cpu, mem)
+			}
+			cpu.Step()
+			if !tc.check(cpu, mem) {
+				t.Errorf("unexpected state: PC=$%04X A=$%02X X=$%02X P=$%02X SP=$%02X",
+					cpu.PC, cpu.A, cpu.X, cpu.Flags(), cpu.SP)
+			}
+		})
+	}
+}
+
+func TestCMOSWAI(t *testing.T) {
+	cpu, mem := newVariantCPU(CMOS, []byte{0xCB, 0xE8})
+	mem[0xFFFE] = testIRQHandler & 0xFF
+	mem[0xFFFF] = testIRQHandler >> 8
+	cpu.Step()
+	for i := 0; i < 5; i++ {
+		cpu.Step()
+	}
+	if cpu.PC != testOrigin+1 || cpu.X != 0 {
+		t.Fatalf("PC = $%04X X = %d, want WAI to hold at $%04X", cpu.PC, cpu.X, testOrigin+1)
+	}
+	// with I set an IRQ only wakes the CPU
+	cpu.AssertIRQ(testSourceA)
+	cpu.Step()
+	if cpu.X != 1 {
+		t.Errorf("X = %d, want execution to resume after WAI", cpu.X)
+	}
+}
+
+func TestCMOSUndefinedOpcodesAreNOPs(t *testing.T) {
+	for opcode := 0; opcode < 256; opcode++ {
+		info := CMOS.Lookup(byte(opcode))
+		if info.Name != "NOP" {
+			continue
+		}
+		cpu, _ := newVariantCPU(CMOS, []byte{byte(opcode), 0x10, 0x02})
+		cpu.Step()
+		if cpu.PC != testOrigin+uint16(info.Size) || cpu.Jammed() {
+			t.Errorf("$%02X: PC = $%04X, want $%04X", opcode, cpu.PC, testOrigin+uint16(info.Size))
+		}
+	}
+}
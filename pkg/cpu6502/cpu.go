@@ -1,11 +1,39 @@
-package device6502
+// Package cpu6502 emulates the MOS 6502 family: the stock NMOS 6502, the
+// Ricoh 2A03 used by the NES and the WDC 65C02. The CPU reaches memory and
+// devices through a Bus, so it can drive anything from a flat 64K test
+// harness to a whole console.
+package cpu6502
 
-import (
-	"encoding/gob"
-	"fmt"
+import "encoding/gob"
+
+// Bus is everything the CPU can address. Each call is one bus cycle; a
+// cycle-accurate CPU makes one per clock, dummy accesses included, so a bus
+// that advances the rest of the machine on every call keeps it in step.
+type Bus interface {
+	Read(address uint16) byte
+	Write(address uint16, value byte)
+}
+
+// Variant selects the chip being emulated.
+type Variant byte
+
+const (
+	NMOS   Variant = iota // MOS 6502 with decimal mode and the undocumented opcodes
+	RP2A03                // NES CPU: an NMOS 6502 with decimal mode disconnected
+	CMOS                  // WDC 65C02, including the Rockwell bit instructions, WAI and STP
 )
 
-const CPUFrequency = 1789773
+func (v Variant) String() string {
+	switch v {
+	case NMOS:
+		return "6502"
+	case RP2A03:
+		return "2A03"
+	case CMOS:
+		return "65C02"
+	}
+	return "unknown"
+}
 
 // Interruption types
 const (
@@ -18,19 +46,22 @@ const (
 // Addressing modes
 const (
 	_ = iota
-	modeAbsolute
-	modeAbsoluteX
-	modeAbsoluteY
-	modeAccumulator
-	modeImmediate
-	modeImplied
-	modeIndexedIndirect
-	modeIndirect
-	modeIndirectIndexed
-	modeRelative
-	modeZeroPage
-	modeZeroPageX
-	modeZeroPageY
+	ModeAbsolute
+	ModeAbsoluteX
+	ModeAbsoluteY
+	ModeAccumulator
+	ModeImmediate
+	ModeImplied
+	ModeIndexedIndirect
+	ModeIndirect
+	ModeIndirectIndexed
+	ModeRelative
+	ModeZeroPage
+	ModeZeroPageX
+	ModeZeroPageY
+	ModeZeroPageIndirect        // 65C02 (zp)
+	ModeAbsoluteIndexedIndirect // 65C02 JMP (abs,X)
+	ModeZeroPageRelative        // 65C02 BBR and BBS: zp, then a branch offset
 )
 
 // Addressing mode for each instruction
@@ -55,22 +86,22 @@ var instructionModes = [256]byte{
 
 // Size of each instruction in bytes
 var instructionSizes = [256]byte{
-	2, 2, 0, 0, 2, 2, 2, 0, 1, 2, 1, 0, 3, 3, 3, 0,
-	2, 2, 0, 0, 2, 2, 2, 0, 1, 3, 1, 0, 3, 3, 3, 0,
-	3, 2, 0, 0, 2, 2, 2, 0, 1, 2, 1, 0, 3, 3, 3, 0,
-	2, 2, 0, 0, 2, 2, 2, 0, 1, 3, 1, 0, 3, 3, 3, 0,
-	1, 2, 0, 0, 2, 2, 2, 0, 1, 2, 1, 0, 3, 3, 3, 0,
-	2, 2, 0, 0, 2, 2, 2, 0, 1, 3, 1, 0, 3, 3, 3, 0,
-	1, 2, 0, 0, 2, 2, 2, 0, 1, 2, 1, 0, 3, 3, 3, 0,
-	2, 2, 0, 0, 2, 2, 2, 0, 1, 3, 1, 0, 3, 3, 3, 0,
-	2, 2, 0, 0, 2, 2, 2, 0, 1, 0, 1, 0, 3, 3, 3, 0,
-	2, 2, 0, 0, 2, 2, 2, 0, 1, 3, 1, 0, 0, 3, 0, 0,
-	2, 2, 2, 0, 2, 2, 2, 0, 1, 2, 1, 0, 3, 3, 3, 0,
-	2, 2, 0, 0, 2, 2, 2, 0, 1, 3, 1, 0, 3, 3, 3, 0,
-	2, 2, 0, 0, 2, 2, 2, 0, 1, 2, 1, 0, 3, 3, 3, 0,
-	2, 2, 0, 0, 2, 2, 2, 0, 1, 3, 1, 0, 3, 3, 3, 0,
-	2, 2, 0, 0, 2, 2, 2, 0, 1, 2, 1, 0, 3, 3, 3, 0,
-	2, 2, 0, 0, 2, 2, 2, 0, 1, 3, 1, 0, 3, 3, 3, 0,
+	2, 2, 1, 2, 2, 2, 2, 2, 1, 2, 1, 2, 3, 3, 3, 3,
+	2, 2, 1, 2, 2, 2, 2, 2, 1, 3, 1, 3, 3, 3, 3, 3,
+	3, 2, 1, 2, 2, 2, 2, 2, 1, 2, 1, 2, 3, 3, 3, 3,
+	2, 2, 1, 2, 2, 2, 2, 2, 1, 3, 1, 3, 3, 3, 3, 3,
+	1, 2, 1, 2, 2, 2, 2, 2, 1, 2, 1, 2, 3, 3, 3, 3,
+	2, 2, 1, 2, 2, 2, 2, 2, 1, 3, 1, 3, 3, 3, 3, 3,
+	1, 2, 1, 2, 2, 2, 2, 2, 1, 2, 1, 2, 3, 3, 3, 3,
+	2, 2, 1, 2, 2, 2, 2, 2, 1, 3, 1, 3, 3, 3, 3, 3,
+	2, 2, 2, 2, 2, 2, 2, 2, 1, 2, 1, 2, 3, 3, 3, 3,
+	2, 2, 1, 2, 2, 2, 2, 2, 1, 3, 1, 3, 3, 3, 3, 3,
+	2, 2, 2, 2, 2, 2, 2, 2, 1, 2, 1, 2, 3, 3, 3, 3,
+	2, 2, 1, 2, 2, 2, 2, 2, 1, 3, 1, 3, 3, 3, 3, 3,
+	2, 2, 2, 2, 2, 2, 2, 2, 1, 2, 1, 2, 3, 3, 3, 3,
+	2, 2, 1, 2, 2, 2, 2, 2, 1, 3, 1, 3, 3, 3, 3, 3,
+	2, 2, 2, 2, 2, 2, 2, 2, 1, 2, 1, 2, 3, 3, 3, 3,
+	2, 2, 1, 2, 2, 2, 2, 2, 1, 3, 1, 3, 3, 3, 3, 3,
 }
 
 // Cycles used by each instruction
@@ -149,35 +180,120 @@ var instructionNames = [256]string{
 	"SED", "SBC", "NOP", "ISC", "NOP", "SBC", "INC", "ISC",
 }
 
+// instructionSet groups the tables describing the opcodes of one variant.
+type instructionSet struct {
+	names      *[256]string
+	modes      *[256]byte
+	sizes      *[256]byte
+	cycles     *[256]byte
+	pageCycles *[256]byte
+}
+
+var nmosInstructions = instructionSet{
+	&instructionNames, &instructionModes, &instructionSizes,
+	&instructionCycles, &instructionPageCycles,
+}
+
+func (v Variant) instructions() *instructionSet {
+	if v == CMOS {
+		return &cmosInstructions
+	}
+	return &nmosInstructions
+}
+
+// Opcode describes an instruction as encoded in the tables above.
+type Opcode struct {
+	Name       string
+	Mode       byte // one of the Mode constants
+	Size       byte // in bytes, including the opcode
+	Cycles     byte // base cycle count
+	PageCycles byte // extra cycles when a page boundary is crossed
+	Official   bool // false for the undocumented NMOS opcodes
+}
+
+// LookupOpcode returns the NMOS table entry for an opcode, which the 2A03
+// shares.
+func LookupOpcode(opcode byte) Opcode {
+	return NMOS.Lookup(opcode)
+}
+
+// Lookup returns the table entry for an opcode on this variant.
+func (v Variant) Lookup(opcode byte) Opcode {
+	set := v.instructions()
+	official := !isUnofficial(opcode)
+	if v == CMOS {
+		official = set.names[opcode] != "NOP" || opcode == 0xEA
+	}
+	return Opcode{
+		set.names[opcode],
+		set.modes[opcode],
+		set.sizes[opcode],
+		set.cycles[opcode],
+		set.pageCycles[opcode],
+		official,
+	}
+}
+
+func isUnofficial(opcode byte) bool {
+	switch instructionNames[opcode] {
+	case "NOP":
+		return opcode != 0xEA
+	case "SBC":
+		return opcode == 0xEB
+	case "SLO", "RLA", "SRE", "RRA", "SAX", "LAX", "DCP", "ISC", "ANC", "ALR",
+		"ARR", "XAA", "AXS", "AHX", "SHY", "SHX", "TAS", "LAS", "KIL":
+		return true
+	}
+	return false
+}
+
 type CPU struct {
-	Memory           // memory interface
-	Cycles    uint64 // number of cycles
-	PC        uint16 // program counter
-	SP        byte   // stack pointer
-	A         byte   // accumulator
-	X         byte   // x register
-	Y         byte   // y register
-	C         byte   // carry flag
-	Z         byte   // zero flag
-	I         byte   // interrupt disable flag
-	D         byte   // decimal mode flag
-	B         byte   // break command flag
-	U         byte   // unused flag
-	V         byte   // overflow flag
-	N         byte   // negative flag
-	interrupt byte   // interrupt type to perform
-	stall     int    // number of cycles to stall
-	table     [256]func(*stepInfo)
-}
-
-func NewCPU(device *Device) *CPU {
-	cpu := CPU{Memory: NewCPUMemory(device)}
+	Bus               // memory interface
+	Cycles     uint64 // number of cycles
+	PC         uint16 // program counter
+	SP         byte   // stack pointer
+	A          byte   // accumulator
+	X          byte   // x register
+	Y          byte   // y register
+	C          byte   // carry flag
+	Z          byte   // zero flag
+	I          byte   // interrupt disable flag
+	D          byte   // decimal mode flag
+	B          byte   // break command flag
+	U          byte   // unused flag
+	V          byte   // overflow flag
+	N          byte   // negative flag
+	interrupt  byte   // interrupt type to perform
+	irqLines   byte   // IRQSource bits currently asserted
+	nmiLine    bool   // level of the NMI line
+	nmiPending bool   // NMI edge detected but not yet serviced
+	skipPoll   bool   // the current cycle does not poll for interrupts
+	stall      int    // number of cycles to stall
+	jammed     bool   // halted by a KIL or STP opcode until reset
+	waiting    bool   // 65C02 WAI: sleeping until an interrupt is requested
+	accurate   bool   // perform the dummy accesses of the cycle-stepped core
+	variant    Variant
+	set        *instructionSet
+	table      [256]func(*stepInfo)
+}
+
+func New(bus Bus, variant Variant) *CPU {
+	cpu := CPU{Bus: bus, variant: variant}
 	cpu.createTable()
 	cpu.Reset()
 	return &cpu
 }
 
+func (cpu *CPU) Variant() Variant {
+	return cpu.variant
+}
+
 func (c *CPU) createTable() {
+	c.set = c.variant.instructions()
+	if c.variant == CMOS {
+		c.createCMOSTable()
+		return
+	}
 	c.table = [256]func(*stepInfo){
 		c.brk, c.ora, c.kil, c.slo, c.nop, c.ora, c.asl, c.slo,
 		c.php, c.ora, c.asl, c.anc, c.nop, c.ora, c.asl, c.slo,
@@ -231,6 +347,12 @@ func (cpu *CPU) Save(encoder *gob.Encoder) error {
 	encoder.Encode(cpu.N)
 	encoder.Encode(cpu.interrupt)
 	encoder.Encode(cpu.stall)
+	encoder.Encode(cpu.jammed)
+	encoder.Encode(cpu.irqLines)
+	encoder.Encode(cpu.nmiLine)
+	encoder.Encode(cpu.nmiPending)
+	encoder.Encode(cpu.waiting)
+	encoder.Encode(cpu.skipPoll)
 	return nil
 }
 
@@ -251,6 +373,12 @@ func (cpu *CPU) Load(decoder *gob.Decoder) error {
 	decoder.Decode(&cpu.N)
 	decoder.Decode(&cpu.interrupt)
 	decoder.Decode(&cpu.stall)
+	decoder.Decode(&cpu.jammed)
+	decoder.Decode(&cpu.irqLines)
+	decoder.Decode(&cpu.nmiLine)
+	decoder.Decode(&cpu.nmiPending)
+	decoder.Decode(&cpu.waiting)
+	decoder.Decode(&cpu.skipPoll)
 	return nil
 }
 
@@ -258,26 +386,88 @@ func (cpu *CPU) Reset() {
 	cpu.PC = cpu.Read16(0xFFFC)
 	cpu.SP = 0xFD
 	cpu.SetFlags(0x24)
+	cpu.jammed = false
+	cpu.waiting = false
+	cpu.interrupt = interruptNone
+	cpu.irqLines = 0
+	cpu.nmiLine = false
+	cpu.nmiPending = false
+	cpu.skipPoll = false
 }
 
-func (cpu *CPU) PrintInstruction() {
-	opcode := cpu.Read(cpu.PC)
-	bytes := instructionSizes[opcode]
-	name := instructionNames[opcode]
-	w0 := fmt.Sprintf("%02X", cpu.Read(cpu.PC+0))
-	w1 := fmt.Sprintf("%02X", cpu.Read(cpu.PC+1))
-	w2 := fmt.Sprintf("%02X", cpu.Read(cpu.PC+2))
-	if bytes < 2 {
-		w1 = "  "
+// Fetching reports whether the next Step will fetch and execute an
+// instruction rather than stall, service an interrupt or sit jammed
+func (cpu *CPU) Fetching() bool {
+	return cpu.stall == 0 && !cpu.jammed &&
+		cpu.interrupt != interruptNMI && cpu.interrupt != interruptIRQ
+}
+
+// Jammed reports whether a KIL opcode, or STP on the 65C02, has halted the
+// CPU. A jammed CPU fetches no more instructions until it is reset.
+func (cpu *CPU) Jammed() bool {
+	return cpu.jammed
+}
+
+// SetCycleAccurate selects between making every bus access of an
+// instruction, dummy reads and writes included, with interrupts polled on
+// each cycle, and the faster default of only the accesses that matter to
+// the result. The cycle-by-cycle access patterns are those of the NMOS
+// parts.
+func (cpu *CPU) SetCycleAccurate(accurate bool) {
+	cpu.accurate = accurate
+}
+
+func (cpu *CPU) CycleAccurate() bool {
+	return cpu.accurate
+}
+
+// Stall suspends the CPU for a number of cycles, as the DMA units that
+// borrow its bus do.
+func (cpu *CPU) Stall(cycles int) {
+	cpu.stall += cycles
+}
+
+// Read performs one bus read cycle.
+func (cpu *CPU) Read(address uint16) byte {
+	if cpu.accurate {
+		cpu.poll()
+	}
+	return cpu.Bus.Read(address)
+}
+
+// Write performs one bus write cycle.
+func (cpu *CPU) Write(address uint16, value byte) {
+	if cpu.accurate {
+		cpu.poll()
+	}
+	cpu.Bus.Write(address, value)
+}
+
+// dummyRead performs a bus access whose value the CPU discards. Only the
+// cycle-accurate core makes them: they matter for their side effects and
+// for keeping one bus access per cycle.
+func (cpu *CPU) dummyRead(address uint16) {
+	if cpu.accurate {
+		cpu.Read(address)
 	}
-	if bytes < 3 {
-		w2 = "  "
+}
+
+// dummyWrite is the write of the unmodified value that read-modify-write
+// instructions make before writing the result.
+func (cpu *CPU) dummyWrite(address uint16, value byte) {
+	if cpu.accurate {
+		cpu.Write(address, value)
+	}
+}
+
+// indexed modes first read from the address before the carry into the
+// high byte is applied; reads that stay on the page keep that value while
+// page crossings, stores and read-modify-writes repeat the access
+func (cpu *CPU) indexedDummyRead(opcode byte, address uint16, index byte, crossed bool) {
+	if crossed || cpu.set.pageCycles[opcode] == 0 {
+		base := address - uint16(index)
+		cpu.dummyRead(base&0xFF00 | address&0x00FF)
 	}
-	fmt.Printf(
-		"%4X  %s %s %s  %s %28s"+
-			"A:%02X X:%02X Y:%02X P:%02X SP:%02X CYC:%3d\n",
-		cpu.PC, w0, w1, w2, name, "",
-		cpu.A, cpu.X, cpu.Y, cpu.Flags(), cpu.SP, (cpu.Cycles*3)%341)
 }
 
 func pagesDiffer(a, b uint16) bool {
@@ -286,8 +476,15 @@ func pagesDiffer(a, b uint16) bool {
 
 func (cpu *CPU) addBranchCycles(info *stepInfo) {
 	cpu.Cycles++
+	if cpu.accurate && !pagesDiffer(info.pc, info.address) {
+		// a taken branch that stays on its page does not poll on its
+		// last cycle, delaying interrupts by one instruction
+		cpu.skipPoll = true
+	}
+	cpu.dummyRead(info.pc)
 	if pagesDiffer(info.pc, info.address) {
 		cpu.Cycles++
+		cpu.dummyRead(info.pc&0xFF00 | info.address&0x00FF)
 	}
 }
 
@@ -382,16 +579,6 @@ func (cpu *CPU) setZN(value byte) {
 	cpu.setN(value)
 }
 
-func (cpu *CPU) triggerNMI() {
-	cpu.interrupt = interruptNMI
-}
-
-func (cpu *CPU) triggerIRQ() {
-	if cpu.I == 0 {
-		cpu.interrupt = interruptIRQ
-	}
-}
-
 type stepInfo struct {
 	address uint16
 	pc      uint16
@@ -399,67 +586,108 @@ type stepInfo struct {
 }
 
 func (cpu *CPU) Step() int {
+	if !cpu.accurate || cpu.stall > 0 {
+		// the cycle-stepped core polls on every access, otherwise sample
+		// the interrupt lines between steps
+		cpu.poll()
+	}
+
 	if cpu.stall > 0 {
 		cpu.stall--
 		return 1
 	}
 
+	if cpu.jammed {
+		cpu.Cycles++
+		return 1
+	}
+
+	if cpu.waiting {
+		if cpu.irqLines == 0 && !cpu.nmiPending {
+			cpu.Cycles++
+			return 1
+		}
+		// an IRQ wakes WAI even with I set, then execution just resumes
+		cpu.waiting = false
+		cpu.poll()
+	}
+
 	cycles := cpu.Cycles
 
 	switch cpu.interrupt {
-	case interruptNMI:
-		cpu.nmi()
-	case interruptIRQ:
-		cpu.irq()
+	case interruptNMI, interruptIRQ:
+		cpu.serviceInterrupt()
+		return int(cpu.Cycles - cycles)
 	}
-	cpu.interrupt = interruptNone
 
 	opcode := cpu.Read(cpu.PC)
-	mode := instructionModes[opcode]
+	mode := cpu.set.modes[opcode]
 
 	var address uint16
 	var pageCrossed bool
 	switch mode {
-	case modeAbsolute:
+	case ModeAbsolute:
 		address = cpu.Read16(cpu.PC + 1)
-	case modeAbsoluteX:
+	case ModeAbsoluteX:
 		address = cpu.Read16(cpu.PC+1) + uint16(cpu.X)
 		pageCrossed = pagesDiffer(address-uint16(cpu.X), address)
-	case modeAbsoluteY:
+		cpu.indexedDummyRead(opcode, address, cpu.X, pageCrossed)
+	case ModeAbsoluteY:
 		address = cpu.Read16(cpu.PC+1) + uint16(cpu.Y)
 		pageCrossed = pagesDiffer(address-uint16(cpu.Y), address)
-	case modeAccumulator:
+		cpu.indexedDummyRead(opcode, address, cpu.Y, pageCrossed)
+	case ModeAccumulator:
 		address = 0
-	case modeImmediate:
+		cpu.dummyRead(cpu.PC + 1)
+	case ModeImmediate:
 		address = cpu.PC + 1
-	case modeImplied:
+	case ModeImplied:
 		address = 0
-	case modeIndexedIndirect:
-		address = cpu.read16bug(uint16(cpu.Read(cpu.PC+1) + cpu.X))
-	case modeIndirect:
-		address = cpu.read16bug(cpu.Read16(cpu.PC + 1))
-	case modeIndirectIndexed:
+		cpu.dummyRead(cpu.PC + 1)
+	case ModeIndexedIndirect:
+		pointer := cpu.Read(cpu.PC + 1)
+		cpu.dummyRead(uint16(pointer))
+		address = cpu.read16bug(uint16(pointer + cpu.X))
+	case ModeIndirect:
+		pointer := cpu.Read16(cpu.PC + 1)
+		if cpu.variant == CMOS {
+			address = cpu.Read16(pointer)
+		} else {
+			address = cpu.read16bug(pointer)
+		}
+	case ModeIndirectIndexed:
 		address = cpu.read16bug(uint16(cpu.Read(cpu.PC+1))) + uint16(cpu.Y)
 		pageCrossed = pagesDiffer(address-uint16(cpu.Y), address)
-	case modeRelative:
+		cpu.indexedDummyRead(opcode, address, cpu.Y, pageCrossed)
+	case ModeRelative:
 		offset := uint16(cpu.Read(cpu.PC + 1))
 		if offset < 0x80 {
 			address = cpu.PC + 2 + offset
 		} else {
 			address = cpu.PC + 2 + offset - 0x100
 		}
-	case modeZeroPage:
+	case ModeZeroPage:
+		address = uint16(cpu.Read(cpu.PC + 1))
+	case ModeZeroPageX:
+		base := cpu.Read(cpu.PC + 1)
+		cpu.dummyRead(uint16(base))
+		address = uint16(base+cpu.X) & 0xff
+	case ModeZeroPageY:
+		base := cpu.Read(cpu.PC + 1)
+		cpu.dummyRead(uint16(base))
+		address = uint16(base+cpu.Y) & 0xff
+	case ModeZeroPageIndirect:
+		address = cpu.read16bug(uint16(cpu.Read(cpu.PC + 1)))
+	case ModeAbsoluteIndexedIndirect:
+		address = cpu.Read16(cpu.Read16(cpu.PC+1) + uint16(cpu.X))
+	case ModeZeroPageRelative:
 		address = uint16(cpu.Read(cpu.PC + 1))
-	case modeZeroPageX:
-		address = uint16(cpu.Read(cpu.PC+1)+cpu.X) & 0xff
-	case modeZeroPageY:
-		address = uint16(cpu.Read(cpu.PC+1)+cpu.Y) & 0xff
 	}
 
-	cpu.PC += uint16(instructionSizes[opcode])
-	cpu.Cycles += uint64(instructionCycles[opcode])
+	cpu.PC += uint16(cpu.set.sizes[opcode])
+	cpu.Cycles += uint64(cpu.set.cycles[opcode])
 	if pageCrossed {
-		cpu.Cycles += uint64(instructionPageCycles[opcode])
+		cpu.Cycles += uint64(cpu.set.pageCycles[opcode])
 	}
 	info := &stepInfo{address, cpu.PC, mode}
 	cpu.table[opcode](info)
@@ -467,25 +695,37 @@ func (cpu *CPU) Step() int {
 	return int(cpu.Cycles - cycles)
 }
 
-func (cpu *CPU) nmi() {
-	cpu.push16(cpu.PC)
-	cpu.php(nil)
-	cpu.PC = cpu.Read16(0xFFFA)
-	cpu.I = 1
+// serviceInterrupt services a pending NMI or IRQ. Both push the flags with B
+// clear; enterInterrupt picks the vector.
+func (cpu *CPU) serviceInterrupt() {
+	cpu.dummyRead(cpu.PC)
+	cpu.dummyRead(cpu.PC)
+	cpu.enterInterrupt(cpu.Flags()&0xEF | 0x20)
 	cpu.Cycles += 7
 }
 
-func (cpu *CPU) irq() {
-	cpu.push16(cpu.PC)
-	cpu.php(nil)
-	cpu.PC = cpu.Read16(0xFFFE)
-	cpu.I = 1
-	cpu.Cycles += 7
+func (cpu *CPU) adc(info *stepInfo) {
+	cpu.add(cpu.Read(info.address))
+	cpu.addDecimalCycle()
 }
 
-func (cpu *CPU) adc(info *stepInfo) {
+// the 65C02 spends an extra cycle fixing up the flags in decimal mode
+func (cpu *CPU) addDecimalCycle() {
+	if cpu.D == 1 && cpu.variant == CMOS {
+		cpu.Cycles++
+	}
+}
+
+func (cpu *CPU) decimal() bool {
+	return cpu.D == 1 && cpu.variant != RP2A03
+}
+
+func (cpu *CPU) add(b byte) {
+	if cpu.decimal() {
+		cpu.addDecimal(b)
+		return
+	}
 	a := cpu.A
-	b := cpu.Read(info.address)
 	c := cpu.C
 	cpu.A = a + b + c
 	cpu.setZN(cpu.A)
@@ -507,12 +747,13 @@ func (cpu *CPU) and(info *stepInfo) {
 }
 
 func (cpu *CPU) asl(info *stepInfo) {
-	if info.mode == modeAccumulator {
+	if info.mode == ModeAccumulator {
 		cpu.C = (cpu.A >> 7) & 1
 		cpu.A <<= 1
 		cpu.setZN(cpu.A)
 	} else {
 		value := cpu.Read(info.address)
+		cpu.dummyWrite(info.address, value)
 		cpu.C = (value >> 7) & 1
 		value <<= 1
 		cpu.Write(info.address, value)
@@ -543,6 +784,11 @@ func (cpu *CPU) beq(info *stepInfo) {
 
 func (cpu *CPU) bit(info *stepInfo) {
 	value := cpu.Read(info.address)
+	if info.mode == ModeImmediate {
+		// 65C02 BIT #imm only affects Z
+		cpu.setZ(value & cpu.A)
+		return
+	}
 	cpu.V = (value >> 6) & 1
 	cpu.setZ(value & cpu.A)
 	cpu.setN(value)
@@ -570,10 +816,7 @@ func (cpu *CPU) bpl(info *stepInfo) {
 }
 
 func (cpu *CPU) brk(info *stepInfo) {
-	cpu.push16(cpu.PC)
-	cpu.php(info)
-	cpu.sei(info)
-	cpu.PC = cpu.Read16(0xFFFE)
+	cpu.enterInterrupt(cpu.Flags() | 0x10)
 }
 
 func (cpu *CPU) bvc(info *stepInfo) {
@@ -622,7 +865,14 @@ func (cpu *CPU) cpy(info *stepInfo) {
 }
 
 func (cpu *CPU) dec(info *stepInfo) {
-	value := cpu.Read(info.address) - 1
+	if info.mode == ModeAccumulator {
+		cpu.A--
+		cpu.setZN(cpu.A)
+		return
+	}
+	value := cpu.Read(info.address)
+	cpu.dummyWrite(info.address, value)
+	value--
 	cpu.Write(info.address, value)
 	cpu.setZN(value)
 }
@@ -643,7 +893,14 @@ func (cpu *CPU) eor(info *stepInfo) {
 }
 
 func (cpu *CPU) inc(info *stepInfo) {
-	value := cpu.Read(info.address) + 1
+	if info.mode == ModeAccumulator {
+		cpu.A++
+		cpu.setZN(cpu.A)
+		return
+	}
+	value := cpu.Read(info.address)
+	cpu.dummyWrite(info.address, value)
+	value++
 	cpu.Write(info.address, value)
 	cpu.setZN(value)
 }
@@ -663,6 +920,7 @@ func (cpu *CPU) jmp(info *stepInfo) {
 }
 
 func (cpu *CPU) jsr(info *stepInfo) {
+	cpu.dummyRead(0x100 | uint16(cpu.SP))
 	cpu.push16(cpu.PC - 1)
 	cpu.PC = info.address
 }
@@ -683,12 +941,13 @@ func (cpu *CPU) ldy(info *stepInfo) {
 }
 
 func (cpu *CPU) lsr(info *stepInfo) {
-	if info.mode == modeAccumulator {
+	if info.mode == ModeAccumulator {
 		cpu.C = cpu.A & 1
 		cpu.A >>= 1
 		cpu.setZN(cpu.A)
 	} else {
 		value := cpu.Read(info.address)
+		cpu.dummyWrite(info.address, value)
 		cpu.C = value & 1
 		value >>= 1
 		cpu.Write(info.address, value)
@@ -697,6 +956,9 @@ func (cpu *CPU) lsr(info *stepInfo) {
 }
 
 func (cpu *CPU) nop(info *stepInfo) {
+	if info.mode != ModeImplied {
+		cpu.dummyRead(info.address)
+	}
 }
 
 func (cpu *CPU) ora(info *stepInfo) {
@@ -713,16 +975,18 @@ func (cpu *CPU) php(info *stepInfo) {
 }
 
 func (cpu *CPU) pla(info *stepInfo) {
+	cpu.dummyRead(0x100 | uint16(cpu.SP))
 	cpu.A = cpu.pull()
 	cpu.setZN(cpu.A)
 }
 
 func (cpu *CPU) plp(info *stepInfo) {
+	cpu.dummyRead(0x100 | uint16(cpu.SP))
 	cpu.SetFlags(cpu.pull()&0xEF | 0x20)
 }
 
 func (cpu *CPU) rol(info *stepInfo) {
-	if info.mode == modeAccumulator {
+	if info.mode == ModeAccumulator {
 		c := cpu.C
 		cpu.C = (cpu.A >> 7) & 1
 		cpu.A = (cpu.A << 1) | c
@@ -730,6 +994,7 @@ func (cpu *CPU) rol(info *stepInfo) {
 	} else {
 		c := cpu.C
 		value := cpu.Read(info.address)
+		cpu.dummyWrite(info.address, value)
 		cpu.C = (value >> 7) & 1
 		value = (value << 1) | c
 		cpu.Write(info.address, value)
@@ -738,7 +1003,7 @@ func (cpu *CPU) rol(info *stepInfo) {
 }
 
 func (cpu *CPU) ror(info *stepInfo) {
-	if info.mode == modeAccumulator {
+	if info.mode == ModeAccumulator {
 		c := cpu.C
 		cpu.C = cpu.A & 1
 		cpu.A = (cpu.A >> 1) | (c << 7)
@@ -746,6 +1011,7 @@ func (cpu *CPU) ror(info *stepInfo) {
 	} else {
 		c := cpu.C
 		value := cpu.Read(info.address)
+		cpu.dummyWrite(info.address, value)
 		cpu.C = value & 1
 		value = (value >> 1) | (c << 7)
 		cpu.Write(info.address, value)
@@ -754,17 +1020,29 @@ func (cpu *CPU) ror(info *stepInfo) {
 }
 
 func (cpu *CPU) rti(info *stepInfo) {
+	cpu.dummyRead(0x100 | uint16(cpu.SP))
 	cpu.SetFlags(cpu.pull()&0xEF | 0x20)
 	cpu.PC = cpu.pull16()
 }
 
 func (cpu *CPU) rts(info *stepInfo) {
-	cpu.PC = cpu.pull16() + 1
+	cpu.dummyRead(0x100 | uint16(cpu.SP))
+	address := cpu.pull16()
+	cpu.dummyRead(address)
+	cpu.PC = address + 1
 }
 
 func (cpu *CPU) sbc(info *stepInfo) {
+	cpu.subtract(cpu.Read(info.address))
+	cpu.addDecimalCycle()
+}
+
+func (cpu *CPU) subtract(b byte) {
+	if cpu.decimal() {
+		cpu.subtractDecimal(b)
+		return
+	}
 	a := cpu.A
-	b := cpu.Read(info.address)
 	c := cpu.C
 	cpu.A = a - b - (1 - c)
 	cpu.setZN(cpu.A)
@@ -833,59 +1111,148 @@ func (cpu *CPU) tya(info *stepInfo) {
 	cpu.setZN(cpu.A)
 }
 
+// unstable high-byte store shared by AHX, SHX, SHY and TAS: the value is
+// ANDed with the high byte of the base address plus one and, when indexing
+// crosses a page, that value also replaces the high byte of the target
+func (cpu *CPU) storeHigh(info *stepInfo, value byte, index byte) {
+	base := info.address - uint16(index)
+	value &= byte(base>>8) + 1
+	address := info.address
+	if pagesDiffer(base, address) {
+		address = uint16(value)<<8 | address&0xFF
+	}
+	cpu.Write(address, value)
+}
+
 func (cpu *CPU) ahx(info *stepInfo) {
+	cpu.storeHigh(info, cpu.A&cpu.X, cpu.Y)
 }
 
 func (cpu *CPU) alr(info *stepInfo) {
+	cpu.A &= cpu.Read(info.address)
+	cpu.C = cpu.A & 1
+	cpu.A >>= 1
+	cpu.setZN(cpu.A)
 }
 
 func (cpu *CPU) anc(info *stepInfo) {
+	cpu.A &= cpu.Read(info.address)
+	cpu.setZN(cpu.A)
+	cpu.C = cpu.N
 }
 
 func (cpu *CPU) arr(info *stepInfo) {
+	cpu.A &= cpu.Read(info.address)
+	cpu.A = (cpu.A >> 1) | (cpu.C << 7)
+	cpu.setZN(cpu.A)
+	cpu.C = (cpu.A >> 6) & 1
+	cpu.V = cpu.C ^ ((cpu.A >> 5) & 1)
 }
 
 func (cpu *CPU) axs(info *stepInfo) {
+	value := cpu.Read(info.address)
+	x := cpu.A & cpu.X
+	cpu.compare(x, value)
+	cpu.X = x - value
 }
 
 func (cpu *CPU) dcp(info *stepInfo) {
+	value := cpu.Read(info.address)
+	cpu.dummyWrite(info.address, value)
+	value--
+	cpu.Write(info.address, value)
+	cpu.compare(cpu.A, value)
 }
 
 func (cpu *CPU) isc(info *stepInfo) {
+	value := cpu.Read(info.address)
+	cpu.dummyWrite(info.address, value)
+	value++
+	cpu.Write(info.address, value)
+	cpu.subtract(value)
 }
 
 func (cpu *CPU) kil(info *stepInfo) {
+	cpu.PC--
+	cpu.jammed = true
 }
 
 func (cpu *CPU) las(info *stepInfo) {
+	value := cpu.Read(info.address) & cpu.SP
+	cpu.A = value
+	cpu.X = value
+	cpu.SP = value
+	cpu.setZN(value)
 }
 
 func (cpu *CPU) lax(info *stepInfo) {
+	value := cpu.Read(info.address)
+	cpu.A = value
+	cpu.X = value
+	cpu.setZN(value)
 }
 
 func (cpu *CPU) rla(info *stepInfo) {
+	c := cpu.C
+	value := cpu.Read(info.address)
+	cpu.dummyWrite(info.address, value)
+	cpu.C = (value >> 7) & 1
+	value = (value << 1) | c
+	cpu.Write(info.address, value)
+	cpu.A &= value
+	cpu.setZN(cpu.A)
 }
 
 func (cpu *CPU) rra(info *stepInfo) {
+	c := cpu.C
+	value := cpu.Read(info.address)
+	cpu.dummyWrite(info.address, value)
+	cpu.C = value & 1
+	value = (value >> 1) | (c << 7)
+	cpu.Write(info.address, value)
+	cpu.add(value)
 }
 
 func (cpu *CPU) sax(info *stepInfo) {
+	cpu.Write(info.address, cpu.A&cpu.X)
 }
 
 func (cpu *CPU) shx(info *stepInfo) {
+	cpu.storeHigh(info, cpu.X, cpu.Y)
 }
 
 func (cpu *CPU) shy(info *stepInfo) {
+	cpu.storeHigh(info, cpu.Y, cpu.X)
 }
 
 func (cpu *CPU) slo(info *stepInfo) {
+	value := cpu.Read(info.address)
+	cpu.dummyWrite(info.address, value)
+	cpu.C = (value >> 7) & 1
+	value <<= 1
+	cpu.Write(info.address, value)
+	cpu.A |= value
+	cpu.setZN(cpu.A)
 }
 
 func (cpu *CPU) sre(info *stepInfo) {
+	value := cpu.Read(info.address)
+	cpu.dummyWrite(info.address, value)
+	cpu.C = value & 1
+	value >>= 1
+	cpu.Write(info.address, value)
+	cpu.A ^= value
+	cpu.setZN(cpu.A)
 }
 
 func (cpu *CPU) tas(info *stepInfo) {
+	cpu.SP = cpu.A & cpu.X
+	cpu.storeHigh(info, cpu.SP, cpu.Y)
 }
 
+// XAA is unstable on real hardware; the 2A03 behaves as if the "magic"
+// constant ORed into A were $FF, which reduces it to A = X & immediate
 func (cpu *CPU) xaa(info *stepInfo) {
+	cpu.A = cpu.X & cpu.Read(info.address)
+	cpu.setZN(cpu.A)
 }
@@ -0,0 +1,553 @@
+package cpu6502
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+)
+
+type testMemory [0x10000]byte
+
+func (mem *testMemory) Read(address uint16) byte {
+	return mem[address]
+}
+
+func (mem *testMemory) Write(address uint16, value byte) {
+	mem[address] = value
+}
+
+// clockedBus calls tick before every access, like a machine that advances
+// its other chips on each bus cycle
+type clockedBus struct {
+	Bus
+	tick func()
+}
+
+func (bus *clockedBus) Read(address uint16) byte {
+	bus.tick()
+	return bus.Bus.Read(address)
+}
+
+func (bus *clockedBus) Write(address uint16, value byte) {
+	bus.tick()
+	bus.Bus.Write(address, value)
+}
+
+const testOrigin = 0x0600
+
+func newTestCPU(program []byte) (*CPU, *testMemory) {
+	mem := &testMemory{}
+	copy(mem[testOrigin:], program)
+	mem[0xFFFC] = testOrigin & 0xFF
+	mem[0xFFFD] = testOrigin >> 8
+	return New(mem, RP2A03), mem
+}
+
+type cpuState struct {
+	A, X, Y, P, SP byte
+}
+
+var unofficialTests = []struct {
+	name    string
+	program []byte
+	before  cpuState
+	memory  map[uint16]byte
+	after   cpuState
+	written map[uint16]byte
+	cycles  int
+}{
+	{
+		name: "SLO zp", program: []byte{0x07, 0x10},
+		before: cpuState{A: 0x01, P: 0x24}, memory: map[uint16]byte{0x10: 0x81},
+		after: cpuState{A: 0x03, P: 0x25}, written: map[uint16]byte{0x10: 0x02},
+		cycles: 5,
+	},
+	{
+		name: "RLA zp", program: []byte{0x27, 0x10},
+		before: cpuState{A: 0xF0, P: 0x25}, memory: map[uint16]byte{0x10: 0x41},
+		after: cpuState{A: 0x80, P: 0xA4}, written: map[uint16]byte{0x10: 0x83},
+		cycles: 5,
+	},
+	{
+		name: "SRE zp", program: []byte{0x47, 0x10},
+		before: cpuState{A: 0xFF, P: 0x24}, memory: map[uint16]byte{0x10: 0x03},
+		after: cpuState{A: 0xFE, P: 0xA5}, written: map[uint16]byte{0x10: 0x01},
+		cycles: 5,
+	},
+	{
+		name: "RRA zp", program: []byte{0x67, 0x10},
+		before: cpuState{A: 0x10, P: 0x25}, memory: map[uint16]byte{0x10: 0x02},
+		after: cpuState{A: 0x91, P: 0xA4}, written: map[uint16]byte{0x10: 0x81},
+		cycles: 5,
+	},
+	{
+		name: "SAX zp leaves flags alone", program: []byte{0x87, 0x10},
+		before: cpuState{A: 0x0F, X: 0xF0, P: 0x24}, memory: map[uint16]byte{0x10: 0xAA},
+		after: cpuState{A: 0x0F, X: 0xF0, P: 0x24}, written: map[uint16]byte{0x10: 0x00},
+		cycles: 3,
+	},
+	{
+		name: "LAX zp", program: []byte{0xA7, 0x10},
+		before: cpuState{P: 0x24}, memory: map[uint16]byte{0x10: 0x80},
+		after:  cpuState{A: 0x80, X: 0x80, P: 0xA4},
+		cycles: 3,
+	},
+	{
+		name: "LAX (ind),Y page crossed", program: []byte{0xB3, 0x10},
+		before: cpuState{Y: 0x01, A: 0x55, P: 0x24},
+		memory: map[uint16]byte{0x10: 0xFF, 0x11: 0x02, 0x0300: 0x00},
+		after:  cpuState{Y: 0x01, P: 0x26},
+		cycles: 6,
+	},
+	{
+		name: "LAX immediate", program: []byte{0xAB, 0x7F},
+		before: cpuState{P: 0x24},
+		after:  cpuState{A: 0x7F, X: 0x7F, P: 0x24},
+		cycles: 2,
+	},
+	{
+		name: "DCP zp", program: []byte{0xC7, 0x10},
+		before: cpuState{A: 0x05, P: 0x24}, memory: map[uint16]byte{0x10: 0x06},
+		after: cpuState{A: 0x05, P: 0x27}, written: map[uint16]byte{0x10: 0x05},
+		cycles: 5,
+	},
+	{
+		name: "ISC zp", program: []byte{0xE7, 0x10},
+		before: cpuState{A: 0x10, P: 0x25}, memory: map[uint16]byte{0x10: 0x0F},
+		after: cpuState{A: 0x00, P: 0x27}, written: map[uint16]byte{0x10: 0x10},
+		cycles: 5,
+	},
+	{
+		name: "ISC abs,X", program: []byte{0xFF, 0x00, 0x03},
+		before: cpuState{A: 0x10, X: 0x01, P: 0x24}, memory: map[uint16]byte{0x0301: 0x01},
+		after: cpuState{A: 0x0D, X: 0x01, P: 0x25}, written: map[uint16]byte{0x0301: 0x02},
+		cycles: 7,
+	},
+	{
+		name: "ANC immediate", program: []byte{0x0B, 0x80},
+		before: cpuState{A: 0xFF, P: 0x24},
+		after:  cpuState{A: 0x80, P: 0xA5},
+		cycles: 2,
+	},
+	{
+		name: "ALR immediate", program: []byte{0x4B, 0x03},
+		before: cpuState{A: 0xFF, P: 0x24},
+		after:  cpuState{A: 0x01, P: 0x25},
+		cycles: 2,
+	},
+	{
+		name: "ARR immediate carry in", program: []byte{0x6B, 0xFF},
+		before: cpuState{A: 0xFF, P: 0x25},
+		after:  cpuState{A: 0xFF, P: 0xA5},
+		cycles: 2,
+	},
+	{
+		name: "ARR immediate overflow", program: []byte{0x6B, 0xFF},
+		before: cpuState{A: 0x40, P: 0x24},
+		after:  cpuState{A: 0x20, P: 0x64},
+		cycles: 2,
+	},
+	{
+		name: "AXS immediate", program: []byte{0xCB, 0x01},
+		before: cpuState{A: 0x0F, X: 0x05, P: 0x24},
+		after:  cpuState{A: 0x0F, X: 0x04, P: 0x25},
+		cycles: 2,
+	},
+	{
+		name: "AXS immediate borrow", program: []byte{0xCB, 0x01},
+		before: cpuState{A: 0xFF, X: 0x00, P: 0x25},
+		after:  cpuState{A: 0xFF, X: 0xFF, P: 0xA4},
+		cycles: 2,
+	},
+	{
+		name: "LAS abs,Y", program: []byte{0xBB, 0x00, 0x03},
+		before: cpuState{SP: 0xF0, P: 0x24}, memory: map[uint16]byte{0x0300: 0x3F},
+		after:  cpuState{A: 0x30, X: 0x30, SP: 0x30, P: 0x24},
+		cycles: 4,
+	},
+	{
+		name: "AHX abs,Y", program: []byte{0x9F, 0x00, 0x03},
+		before: cpuState{A: 0xFF, X: 0xFF, Y: 0x01, P: 0x24},
+		after:  cpuState{A: 0xFF, X: 0xFF, Y: 0x01, P: 0x24}, written: map[uint16]byte{0x0301: 0x04},
+		cycles: 5,
+	},
+	{
+		name: "AHX (ind),Y", program: []byte{0x93, 0x10},
+		before: cpuState{A: 0xFF, X: 0xFF, Y: 0x01, P: 0x24},
+		memory: map[uint16]byte{0x10: 0x00, 0x11: 0x03},
+		after:  cpuState{A: 0xFF, X: 0xFF, Y: 0x01, P: 0x24}, written: map[uint16]byte{0x0301: 0x04},
+		cycles: 6,
+	},
+	{
+		name: "SHX abs,Y", program: []byte{0x9E, 0x00, 0x03},
+		before: cpuState{X: 0xFF, Y: 0x02, P: 0x24},
+		after:  cpuState{X: 0xFF, Y: 0x02, P: 0x24}, written: map[uint16]byte{0x0302: 0x04},
+		cycles: 5,
+	},
+	{
+		name: "SHX abs,Y page crossed", program: []byte{0x9E, 0xFF, 0x03},
+		before: cpuState{X: 0x02, Y: 0x01, P: 0x24}, memory: map[uint16]byte{0x0000: 0xAA},
+		after: cpuState{X: 0x02, Y: 0x01, P: 0x24}, written: map[uint16]byte{0x0000: 0x00},
+		cycles: 5,
+	},
+	{
+		name: "SHY abs,X", program: []byte{0x9C, 0x00, 0x03},
+		before: cpuState{Y: 0xFF, X: 0x01, P: 0x24},
+		after:  cpuState{Y: 0xFF, X: 0x01, P: 0x24}, written: map[uint16]byte{0x0301: 0x04},
+		cycles: 5,
+	},
+	{
+		name: "TAS abs,Y", program: []byte{0x9B, 0x00, 0x03},
+		before: cpuState{A: 0xF3, X: 0x3F, SP: 0xFD, P: 0x24}, memory: map[uint16]byte{0x0300: 0xAA},
+		after: cpuState{A: 0xF3, X: 0x3F, SP: 0x33, P: 0x24}, written: map[uint16]byte{0x0300: 0x00},
+		cycles: 5,
+	},
+	{
+		name: "XAA immediate", program: []byte{0x8B, 0x0F},
+		before: cpuState{X: 0xF3, P: 0x24},
+		after:  cpuState{A: 0x03, X: 0xF3, P: 0x24},
+		cycles: 2,
+	},
+	{
+		name: "SBC immediate ($EB)", program: []byte{0xEB, 0x01},
+		before: cpuState{A: 0x03, P: 0x25},
+		after:  cpuState{A: 0x02, P: 0x25},
+		cycles: 2,
+	},
+	{
+		name: "NOP immediate", program: []byte{0x80, 0xFF},
+		before: cpuState{P: 0x24},
+		after:  cpuState{P: 0x24},
+		cycles: 2,
+	},
+	{
+		name: "NOP abs,X page crossed", program: []byte{0x1C, 0xFF, 0x02},
+		before: cpuState{X: 0x01, P: 0x24},
+		after:  cpuState{X: 0x01, P: 0x24},
+		cycles: 5,
+	},
+}
+
+func TestUnofficialOpcodes(t *testing.T) {
+	for _, tc := range unofficialTests {
+		t.Run(tc.name, func(t *testing.T) {
+			cpu, mem := newTestCPU(tc.program)
+			cpu.A, cpu.X, cpu.Y = tc.before.A, tc.before.X, tc.before.Y
+			cpu.SetFlags(tc.before.P)
+			if tc.before.SP != 0 {
+				cpu.SP = tc.before.SP
+			}
+			sp := cpu.SP
+			for address, value := range tc.memory {
+				mem[address] = value
+			}
+			cycles := cpu.Step()
+			want := tc.after
+			if want.SP == 0 {
+				want.SP = sp
+			}
+			got := cpuState{cpu.A, cpu.X, cpu.Y, cpu.Flags(), cpu.SP}
+			if got != want {
+				t.Errorf("registers = %+v, want %+v", got, want)
+			}
+			for address, value := range tc.written {
+				if mem[address] != value {
+					t.Errorf("memory[$%04X] = $%02X, want $%02X", address, mem[address], value)
+				}
+			}
+			if cycles != tc.cycles {
+				t.Errorf("cycles = %d, want %d", cycles, tc.cycles)
+			}
+			if pc := uint16(testOrigin + len(tc.program)); cpu.PC != pc {
+				t.Errorf("PC = $%04X, want $%04X", cpu.PC, pc)
+			}
+		})
+	}
+}
+
+func TestInstructionSizes(t *testing.T) {
+	for opcode := 0; opcode < 256; opcode++ {
+		var want byte
+		switch instructionModes[opcode] {
+		case ModeAccumulator, ModeImplied:
+			want = 1
+		case ModeAbsolute, ModeAbsoluteX, ModeAbsoluteY, ModeIndirect:
+			want = 3
+		default:
+			want = 2
+		}
+		// BRK skips a padding byte
+		if opcode == 0x00 {
+			want = 2
+		}
+		if instructionSizes[opcode] != want {
+			t.Errorf("size of $%02X %s = %d, want %d",
+				opcode, instructionNames[opcode], instructionSizes[opcode], want)
+		}
+	}
+}
+
+func TestKILJamsUntilReset(t *testing.T) {
+	cpu, _ := newTestCPU([]byte{0xE8, 0x02, 0xE8})
+	cpu.Step()
+	cpu.Step()
+	if !cpu.Jammed() {
+		t.Fatal("CPU not jammed after KIL")
+	}
+	for i := 0; i < 10; i++ {
+		if cycles := cpu.Step(); cycles != 1 {
+			t.Fatalf("jammed step took %d cycles, want 1", cycles)
+		}
+	}
+	if cpu.PC != testOrigin+1 || cpu.X != 1 {
+		t.Errorf("PC = $%04X X = %d, want $%04X X = 1", cpu.PC, cpu.X, testOrigin+1)
+	}
+	cpu.Reset()
+	if cpu.Jammed() {
+		t.Error("CPU still jammed after reset")
+	}
+}
+
+// The 6502 accesses the bus on every cycle, so the cycle-stepped core must
+// make exactly as many accesses as the instruction takes cycles.
+func TestCycleAccurateBusAccesses(t *testing.T) {
+	for opcode := 0; opcode < 256; opcode++ {
+		if instructionNames[opcode] == "KIL" {
+			continue
+		}
+		for _, index := range []byte{0x00, 0xFF} {
+			for _, flags := range []byte{0x00, 0xFF} {
+				cpu, mem := newTestCPU([]byte{byte(opcode), 0x80, 0x02})
+				mem[0x80] = 0x80
+				mem[0x81] = 0x02
+				accesses := 0
+				cpu.Bus = &clockedBus{mem, func() { accesses++ }}
+				cpu.accurate = true
+				cpu.X, cpu.Y = index, index
+				cpu.SetFlags(flags)
+				if cycles := cpu.Step(); cycles != accesses {
+					t.Errorf("$%02X %s X=Y=$%02X P=$%02X: %d accesses in %d cycles",
+						opcode, instructionNames[opcode], index, flags, accesses, cycles)
+				}
+			}
+		}
+	}
+}
+
+func TestCycleAccurateDummyWrite(t *testing.T) {
+	cpu, mem := newTestCPU([]byte{0xEE, 0x00, 0x03}) // INC $0300
+	mem[0x0300] = 0x41
+	var writes []byte
+	cpu.Bus = &recordingBus{mem, &writes}
+	cpu.accurate = true
+	cpu.Step()
+	if len(writes) != 2 || writes[0] != 0x41 || writes[1] != 0x42 {
+		t.Errorf("writes = % X, want 41 42", writes)
+	}
+}
+
+type recordingBus struct {
+	Bus
+	writes *[]byte
+}
+
+func (bus *recordingBus) Write(address uint16, value byte) {
+	*bus.writes = append(*bus.writes, value)
+	bus.Bus.Write(address, value)
+}
+
+const (
+	testNMIHandler = 0x0700
+	testIRQHandler = 0x0800
+)
+
+const (
+	testSourceA IRQSource = 1 << iota
+	testSourceB
+)
+
+// newInterruptCPU returns a cycle-stepped CPU, which polls for interrupts
+// on every access. events run at the given access, counted from 1 across
+// steps, after that cycle's poll.
+func newInterruptCPU(program []byte, events map[int]func(*CPU)) (*CPU, *testMemory) {
+	cpu, mem := newTestCPU(program)
+	mem[0xFFFA] = testNMIHandler & 0xFF
+	mem[0xFFFB] = testNMIHandler >> 8
+	mem[0xFFFE] = testIRQHandler & 0xFF
+	mem[0xFFFF] = testIRQHandler >> 8
+	accesses := 0
+	cpu.Bus = &clockedBus{mem, func() {
+		accesses++
+		if event, ok := events[accesses]; ok {
+			event(cpu)
+		}
+	}}
+	cpu.accurate = true
+	return cpu, mem
+}
+
+func TestInterruptPolling(t *testing.T) {
+	assert := func(cpu *CPU) { cpu.AssertIRQ(testSourceA) }
+	tests := []struct {
+		name    string
+		program []byte
+		flags   byte
+		events  map[int]func(*CPU)
+		steps   int
+		pc      uint16
+	}{
+		// CLI takes effect after the next instruction
+		{"CLI", []byte{0x58, 0xEA, 0xEA}, 0x24, map[int]func(*CPU){1: assert}, 2, testOrigin + 2},
+		{"CLI then IRQ", []byte{0x58, 0xEA, 0xEA}, 0x24, map[int]func(*CPU){1: assert}, 3, testIRQHandler},
+		// SEI still lets an IRQ pending during it through
+		{"SEI", []byte{0x78, 0xEA}, 0x20, map[int]func(*CPU){1: assert}, 2, testIRQHandler},
+		// PLP behaves like CLI
+		{"PLP", []byte{0x28, 0xEA, 0xEA}, 0x24, map[int]func(*CPU){1: assert}, 2, testOrigin + 2},
+		{"PLP then IRQ", []byte{0x28, 0xEA, 0xEA}, 0x24, map[int]func(*CPU){1: assert}, 3, testIRQHandler},
+		// an IRQ asserted during the last cycle waits another instruction
+		{"late IRQ", []byte{0xEA, 0xEA, 0xEA}, 0x20, map[int]func(*CPU){2: assert}, 2, testOrigin + 2},
+		{"late IRQ taken", []byte{0xEA, 0xEA, 0xEA}, 0x20, map[int]func(*CPU){2: assert}, 3, testIRQHandler},
+		// a taken branch that stays on its page skips its last poll
+		{"branch delays IRQ", []byte{0xD0, 0x00, 0xEA, 0xEA}, 0x20, map[int]func(*CPU){2: assert}, 2, testOrigin + 3},
+		{"untaken branch", []byte{0xF0, 0x00, 0xEA, 0xEA}, 0x20, map[int]func(*CPU){1: assert}, 2, testIRQHandler},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cpu, mem := newInterruptCPU(tc.program, tc.events)
+			cpu.SetFlags(tc.flags)
+			cpu.SP = 0xFC
+			mem[0x01FD] = 0x20 // pulled by PLP: I clear
+			for i := 0; i < tc.steps; i++ {
+				cpu.Step()
+			}
+			if cpu.PC != tc.pc {
+				t.Errorf("PC = $%04X, want $%04X", cpu.PC, tc.pc)
+			}
+		})
+	}
+}
+
+func TestSEIPushesFlagsWithI(t *testing.T) {
+	cpu, mem := newInterruptCPU([]byte{0x78, 0xEA}, map[int]func(*CPU){
+		1: func(cpu *CPU) { cpu.AssertIRQ(testSourceB) },
+	})
+	cpu.SetFlags(0x20)
+	cpu.Step()
+	cpu.Step()
+	if flags := mem[0x0100|uint16(cpu.SP+1)]; flags != 0x24 {
+		t.Errorf("pushed flags = $%02X, want $24", flags)
+	}
+}
+
+func TestNMIHijacksBRK(t *testing.T) {
+	cpu, mem := newInterruptCPU([]byte{0x00, 0x00}, map[int]func(*CPU){
+		3: func(cpu *CPU) { cpu.SetNMI(true) },
+	})
+	cpu.Step()
+	if cpu.PC != testNMIHandler {
+		t.Fatalf("PC = $%04X, want NMI handler $%04X", cpu.PC, testNMIHandler)
+	}
+	if flags := mem[0x0100|uint16(cpu.SP+1)]; flags&0x10 == 0 {
+		t.Errorf("pushed flags = $%02X, want B set", flags)
+	}
+	// the hijacked NMI is not serviced a second time
+	cpu.Step()
+	if cpu.PC == testNMIHandler {
+		t.Error("NMI serviced twice")
+	}
+}
+
+func TestNMIIsEdgeTriggered(t *testing.T) {
+	cpu, mem := newInterruptCPU([]byte{0xEA}, nil)
+	mem[testNMIHandler] = 0xEA
+	mem[testNMIHandler+1] = 0xEA
+	mem[testNMIHandler+2] = 0xEA
+	cpu.SetNMI(true)
+	cpu.Step()
+	cpu.Step()
+	if cpu.PC != testNMIHandler {
+		t.Fatalf("PC = $%04X, want $%04X", cpu.PC, testNMIHandler)
+	}
+	cpu.SetNMI(true)
+	cpu.Step()
+	cpu.Step()
+	if cpu.PC != testNMIHandler+2 {
+		t.Fatalf("PC = $%04X, want $%04X: held line retriggered", cpu.PC, testNMIHandler+2)
+	}
+	cpu.SetNMI(false)
+	cpu.SetNMI(true)
+	cpu.Step()
+	cpu.Step()
+	if cpu.PC != testNMIHandler {
+		t.Errorf("PC = $%04X, want $%04X after a new edge", cpu.PC, testNMIHandler)
+	}
+}
+
+func TestIRQSources(t *testing.T) {
+	cpu, _ := newTestCPU(nil)
+	cpu.AssertIRQ(testSourceB)
+	cpu.AssertIRQ(testSourceA)
+	cpu.AcknowledgeIRQ(testSourceB)
+	if !cpu.IRQAsserted(testSourceA) || cpu.IRQAsserted(testSourceB) {
+		t.Fatal("acknowledging one source released another")
+	}
+	cpu.I = 0
+	cpu.poll()
+	if cpu.interrupt != interruptIRQ {
+		t.Error("IRQ lost after acknowledging a different source")
+	}
+	cpu.AcknowledgeIRQ(testSourceA)
+	cpu.poll()
+	if cpu.interrupt != interruptNone {
+		t.Error("IRQ pending with all lines released")
+	}
+}
+
+// Step returns as soon as it has entered an interrupt handler; the first
+// instruction of the handler runs on the next call.
+func TestStepReturnsAfterInterruptEntry(t *testing.T) {
+	cpu, mem := newInterruptCPU([]byte{0xE8, 0xE8}, nil) // INX
+	mem[testNMIHandler] = 0xE8
+	cpu.SetNMI(true)
+	cpu.Step()
+	if cycles := cpu.Step(); cycles != 7 {
+		t.Errorf("interrupt entry took %d cycles, want 7", cycles)
+	}
+	if cpu.PC != testNMIHandler || cpu.X != 1 {
+		t.Fatalf("PC = $%04X, X = %d after entry, want $%04X and 1", cpu.PC, cpu.X, testNMIHandler)
+	}
+	cpu.Step()
+	if cpu.PC != testNMIHandler+1 || cpu.X != 2 {
+		t.Errorf("PC = $%04X, X = %d, want the handler's first instruction run", cpu.PC, cpu.X)
+	}
+}
+
+func TestResetReleasesNMI(t *testing.T) {
+	cpu, mem := newInterruptCPU([]byte{0xEA, 0xEA, 0xEA}, nil)
+	mem[testNMIHandler] = 0xEA
+	cpu.SetNMI(true)
+	cpu.Reset()
+	// the PPU raises the line again after reset: a new edge
+	cpu.SetNMI(true)
+	cpu.Step()
+	cpu.Step()
+	if cpu.PC != testNMIHandler {
+		t.Errorf("PC = $%04X, want the NMI handler $%04X", cpu.PC, testNMIHandler)
+	}
+}
+
+func TestSaveSkipPoll(t *testing.T) {
+	cpu, _ := newTestCPU(nil)
+	cpu.skipPoll = true
+	var state bytes.Buffer
+	if err := cpu.Save(gob.NewEncoder(&state)); err != nil {
+		t.Fatal(err)
+	}
+	loaded, _ := newTestCPU(nil)
+	if err := loaded.Load(gob.NewDecoder(&state)); err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.skipPoll {
+		t.Error("skipPoll lost across Save and Load")
+	}
+}
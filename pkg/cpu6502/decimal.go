@@ -0,0 +1,78 @@
+package cpu6502
+
+// BCD arithmetic following "Decimal Mode" by Bruce Clark (6502.org),
+// including the flag behaviour on invalid BCD operands.
+
+func (cpu *CPU) addDecimal(b byte) {
+	a := cpu.A
+	c := int(cpu.C)
+	lo := int(a&0x0F) + int(b&0x0F) + c
+	if lo >= 0x0A {
+		lo = ((lo + 0x06) & 0x0F) + 0x10
+	}
+	sum := int(a&0xF0) + int(b&0xF0) + lo
+	signed := int(int8(a&0xF0)) + int(int8(b&0xF0)) + lo
+	if signed < -128 || signed > 127 {
+		cpu.V = 1
+	} else {
+		cpu.V = 0
+	}
+	if sum >= 0xA0 {
+		sum += 0x60
+	}
+	if sum >= 0x100 {
+		cpu.C = 1
+	} else {
+		cpu.C = 0
+	}
+	if cpu.variant == CMOS {
+		cpu.A = byte(sum)
+		cpu.setZN(cpu.A)
+		return
+	}
+	// the NMOS parts take N from the intermediate result and Z from the
+	// binary sum
+	cpu.setN(byte(int(a&0xF0) + int(b&0xF0) + lo))
+	cpu.setZ(a + b + byte(c))
+	cpu.A = byte(sum)
+}
+
+func (cpu *CPU) subtractDecimal(b byte) {
+	a := cpu.A
+	c := int(cpu.C)
+	binary := int(a) - int(b) + c - 1
+	// flags come from the binary subtraction, except N and Z on the 65C02
+	if binary >= 0 {
+		cpu.C = 1
+	} else {
+		cpu.C = 0
+	}
+	if (a^b)&0x80 != 0 && (a^byte(binary))&0x80 != 0 {
+		cpu.V = 1
+	} else {
+		cpu.V = 0
+	}
+	lo := int(a&0x0F) - int(b&0x0F) + c - 1
+	var result int
+	if cpu.variant == CMOS {
+		result = binary
+		if result < 0 {
+			result -= 0x60
+		}
+		if lo < 0 {
+			result -= 0x06
+		}
+		cpu.A = byte(result)
+		cpu.setZN(cpu.A)
+		return
+	}
+	if lo < 0 {
+		lo = ((lo - 0x06) & 0x0F) - 0x10
+	}
+	result = int(a&0xF0) - int(b&0xF0) + lo
+	if result < 0 {
+		result -= 0x60
+	}
+	cpu.setZN(byte(binary))
+	cpu.A = byte(result)
+}
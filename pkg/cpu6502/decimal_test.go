@@ -0,0 +1,42 @@
+package cpu6502
+
+import "testing"
+
+func TestDecimalMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		variant Variant
+		program []byte
+		a, p    byte // before: P sets D and C
+		wantA   byte
+		wantP   byte
+	}{
+		{"ADC", NMOS, []byte{0x69, 0x27}, 0x15, 0x28, 0x42, 0x28},
+		{"ADC carry", NMOS, []byte{0x69, 0x01}, 0x99, 0x28, 0x00, 0xA9},
+		{"ADC carry 65C02", CMOS, []byte{0x69, 0x01}, 0x99, 0x28, 0x00, 0x2B},
+		{"ADC 2A03 is binary", RP2A03, []byte{0x69, 0x27}, 0x15, 0x28, 0x3C, 0x28},
+		{"SBC", NMOS, []byte{0xE9, 0x15}, 0x42, 0x29, 0x27, 0x29},
+		{"SBC borrow", NMOS, []byte{0xE9, 0x01}, 0x00, 0x29, 0x99, 0xA8},
+		{"SBC borrow 65C02", CMOS, []byte{0xE9, 0x01}, 0x00, 0x29, 0x99, 0xA8},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cpu, _ := newVariantCPU(tc.variant, tc.program)
+			cpu.A = tc.a
+			cpu.SetFlags(tc.p)
+			cpu.Step()
+			if cpu.A != tc.wantA || cpu.Flags() != tc.wantP {
+				t.Errorf("A = $%02X P = $%02X, want A = $%02X P = $%02X",
+					cpu.A, cpu.Flags(), tc.wantA, tc.wantP)
+			}
+		})
+	}
+}
+
+func newVariantCPU(variant Variant, program []byte) (*CPU, *testMemory) {
+	mem := &testMemory{}
+	copy(mem[testOrigin:], program)
+	mem[0xFFFC] = testOrigin & 0xFF
+	mem[0xFFFD] = testOrigin >> 8
+	return New(mem, variant), mem
+}
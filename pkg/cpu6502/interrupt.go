@@ -0,0 +1,70 @@
+package cpu6502
+
+// IRQSource identifies one of up to eight devices sharing the CPU's IRQ
+// line, as a single bit. Each source holds the line until it acknowledges
+// it, so simultaneous requests are not lost.
+type IRQSource byte
+
+// AssertIRQ pulls the IRQ line low on behalf of source.
+func (cpu *CPU) AssertIRQ(source IRQSource) {
+	cpu.irqLines |= byte(source)
+}
+
+// AcknowledgeIRQ releases the IRQ line held by source.
+func (cpu *CPU) AcknowledgeIRQ(source IRQSource) {
+	cpu.irqLines &^= byte(source)
+}
+
+// IRQAsserted reports whether source is holding the IRQ line.
+func (cpu *CPU) IRQAsserted(source IRQSource) bool {
+	return cpu.irqLines&byte(source) != 0
+}
+
+// SetNMI drives the NMI line. NMIs are edge triggered: only a transition to
+// the asserted level requests one.
+func (cpu *CPU) SetNMI(asserted bool) {
+	if asserted && !cpu.nmiLine {
+		cpu.nmiPending = true
+	}
+	cpu.nmiLine = asserted
+}
+
+// poll decides whether an interrupt is serviced after the current
+// instruction. It runs at the start of every cycle and the last call of an
+// instruction wins, so the decision reflects the lines and the I flag as
+// they were at the end of the second-to-last cycle. That is where the one
+// instruction delay of CLI, SEI and PLP comes from.
+func (cpu *CPU) poll() {
+	if cpu.skipPoll {
+		cpu.skipPoll = false
+		return
+	}
+	switch {
+	case cpu.nmiPending:
+		cpu.interrupt = interruptNMI
+	case cpu.irqLines != 0 && cpu.I == 0:
+		cpu.interrupt = interruptIRQ
+	default:
+		cpu.interrupt = interruptNone
+	}
+}
+
+// enterInterrupt is the sequence shared by BRK, IRQ and NMI. An NMI
+// detected before the vector is fetched hijacks a BRK or IRQ sequence,
+// which then jumps through the NMI vector with the flags already pushed.
+func (cpu *CPU) enterInterrupt(flags byte) {
+	cpu.push16(cpu.PC)
+	cpu.push(flags)
+	cpu.I = 1
+	if cpu.variant == CMOS {
+		cpu.D = 0
+	}
+	vector := uint16(0xFFFE)
+	if cpu.nmiPending {
+		cpu.nmiPending = false
+		vector = 0xFFFA
+	}
+	cpu.PC = cpu.Read16(vector)
+	// the first instruction of a handler always runs
+	cpu.interrupt = interruptNone
+}
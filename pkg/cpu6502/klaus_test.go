@@ -0,0 +1,181 @@
+package cpu6502
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//go:generate go run testdata/fetch.go
+
+// requireSuites makes missing suite binaries fail the tests instead of
+// skipping them, for CI that has provided them.
+const requireSuites = "GO6502_REQUIRE_ROMS"
+
+// flatBus is 64K of RAM with nothing mapped on top.
+type flatBus [0x10000]byte
+
+func (bus *flatBus) Read(address uint16) byte {
+	return bus[address]
+}
+
+func (bus *flatBus) Write(address uint16, value byte) {
+	bus[address] = value
+}
+
+// Klaus Dormann's suites signal both success and failure by jumping or
+// branching to the instruction itself; the address of that trap tells them
+// apart.
+const (
+	functionalTestCase    = 0x0200 // test_case, the number of the running test
+	functionalTestSuccess = 0x3469 // success trap of the default build
+	decimalTestError      = 0x000B // ERROR: 0 once every combination passed
+	trapLimit             = 100000000
+)
+
+// runUntilTrap steps the CPU until an instruction leaves PC where it was,
+// returning the trap address, or fails after limit instructions.
+func runUntilTrap(cpu *CPU, limit int) (uint16, error) {
+	for i := 0; i < limit; i++ {
+		pc := cpu.PC
+		cpu.Step()
+		if cpu.PC == pc {
+			return pc, nil
+		}
+	}
+	return cpu.PC, errors.New("no trap reached")
+}
+
+// functionalResult judges the trap the functional test stopped at.
+func functionalResult(cpu *CPU, bus *flatBus, pc uint16) error {
+	if pc != functionalTestSuccess {
+		return fmt.Errorf("failure trap at $%04X in test $%02X (A=$%02X X=$%02X Y=$%02X P=$%02X)",
+			pc, bus[functionalTestCase], cpu.A, cpu.X, cpu.Y, cpu.Flags())
+	}
+	return nil
+}
+
+// decimalResult judges the decimal test by its ERROR byte at the trap.
+func decimalResult(cpu *CPU, bus *flatBus, pc uint16) error {
+	if bus[decimalTestError] != 0 {
+		// N1 and N2 are the operands, Y the carry in; DA is the result
+		// and AR the prediction
+		return fmt.Errorf("decimal test failed at $%04X: $%02X, $%02X, carry %d gave $%02X, want $%02X",
+			pc, bus[0x00], bus[0x01], cpu.Y, bus[0x04], bus[0x06])
+	}
+	return nil
+}
+
+// loadSuite reads a binary from testdata into a flat bus. A missing binary
+// skips the test, or fails it when GO6502_REQUIRE_ROMS is set. See
+// testdata/README.md.
+func loadSuite(t *testing.T, name string, origin uint16) *flatBus {
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if os.IsNotExist(err) {
+		if os.Getenv(requireSuites) != "" {
+			t.Fatalf("testdata/%s not found, see testdata/README.md", name)
+		}
+		t.Skipf("testdata/%s not found: run go generate ./pkg/cpu6502 or see testdata/README.md", name)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus := &flatBus{}
+	if len(data) > len(bus)-int(origin) {
+		t.Fatalf("%s does not fit at $%04X", name, origin)
+	}
+	copy(bus[origin:], data)
+	return bus
+}
+
+func TestKlausFunctional(t *testing.T) {
+	bus := loadSuite(t, "6502_functional_test.bin", 0x0000)
+	cpu := New(bus, NMOS)
+	cpu.PC = 0x0400
+	pc, err := runUntilTrap(cpu, trapLimit)
+	if err != nil {
+		t.Fatalf("%v, last PC $%04X in test $%02X", err, pc, bus[functionalTestCase])
+	}
+	if err := functionalResult(cpu, bus, pc); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKlausDecimal(t *testing.T) {
+	bus := loadSuite(t, "6502_decimal_test.bin", 0x0200)
+	cpu := New(bus, NMOS)
+	cpu.PC = 0x0200
+	pc, err := runUntilTrap(cpu, trapLimit)
+	if err != nil {
+		t.Fatalf("%v, last PC $%04X", err, pc)
+	}
+	if err := decimalResult(cpu, bus, pc); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestKlausHarness runs stand-ins for the suites that end the way the real
+// binaries do, so the trap addresses and the ERROR byte are checked even
+// without them.
+func TestKlausHarness(t *testing.T) {
+	// functional: JMP $3469 reaching the success trap, or BNE * failing
+	bus := &flatBus{}
+	copy(bus[0x0400:], []byte{0x4C, 0x69, 0x34})
+	copy(bus[functionalTestSuccess:], []byte{0x4C, 0x69, 0x34})
+	cpu := New(bus, NMOS)
+	cpu.PC = 0x0400
+	pc, err := runUntilTrap(cpu, 10)
+	if err != nil || functionalResult(cpu, bus, pc) != nil {
+		t.Errorf("success trap at $%04X not accepted (%v)", pc, err)
+	}
+	bus[0x0400], bus[0x0401] = 0xD0, 0xFE
+	bus[functionalTestCase] = 0x29
+	cpu.PC = 0x0400
+	cpu.Z = 0
+	pc, _ = runUntilTrap(cpu, 10)
+	if err := functionalResult(cpu, bus, pc); err == nil {
+		t.Error("failure trap at $0400 accepted")
+	}
+
+	// decimal: LDA #error; STA $0B; JMP *
+	for _, errorByte := range []byte{0, 1} {
+		bus := &flatBus{}
+		copy(bus[0x0200:], []byte{0xA9, errorByte, 0x85, 0x0B, 0x4C, 0x04, 0x02})
+		cpu := New(bus, NMOS)
+		cpu.PC = 0x0200
+		pc, err := runUntilTrap(cpu, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if failed := decimalResult(cpu, bus, pc) != nil; failed != (errorByte != 0) {
+			t.Errorf("ERROR = %d judged failed %v", errorByte, failed)
+		}
+	}
+}
+
+func TestRunUntilTrap(t *testing.T) {
+	bus := &flatBus{}
+	// LDX #5; loop: DEX; BNE loop; JMP *
+	copy(bus[0x0400:], []byte{0xA2, 0x05, 0xCA, 0xD0, 0xFD, 0x4C, 0x05, 0x04})
+	cpu := New(bus, NMOS)
+	cpu.PC = 0x0400
+	pc, err := runUntilTrap(cpu, 100)
+	if err != nil || pc != 0x0405 || cpu.X != 0 {
+		t.Errorf("trap at $%04X, X = %d, err %v; want $0405, X = 0", pc, cpu.X, err)
+	}
+	// BNE * never gets anywhere either
+	copy(bus[0x0400:], []byte{0xD0, 0xFE})
+	cpu.PC = 0x0400
+	cpu.Z = 0
+	if pc, err := runUntilTrap(cpu, 100); err != nil || pc != 0x0400 {
+		t.Errorf("trap at $%04X, err %v; want $0400", pc, err)
+	}
+	cpu.Z = 1
+	cpu.PC = 0x0400
+	bus[0x0402] = 0xEA
+	if _, err := runUntilTrap(cpu, 1); err == nil {
+		t.Error("expected no trap within the limit")
+	}
+}
@@ -0,0 +1,49 @@
+//go:build ignore
+// +build ignore
+
+// Fetch downloads the prebuilt functional test binary of Klaus Dormann's
+// suites into testdata. The decimal test has no prebuilt binary and still
+// has to be assembled by hand, see README.md. It runs from the package
+// directory through go generate ./pkg/cpu6502.
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+const functionalURL = "https://raw.githubusercontent.com/Klaus2m5/6502_65C02_functional_tests/master/bin_files/6502_functional_test.bin"
+
+func main() {
+	path := filepath.Join("testdata", "6502_functional_test.bin")
+	if _, err := os.Stat(path); err != nil {
+		if err := fetch(functionalURL, path); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("fetched", path)
+	}
+	decimal := filepath.Join("testdata", "6502_decimal_test.bin")
+	if _, err := os.Stat(decimal); err != nil {
+		fmt.Printf("%s must be assembled from 6502_decimal_test.a65, see testdata/README.md\n", decimal)
+	}
+}
+
+func fetch(url, path string) error {
+	response, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, response.Status)
+	}
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,78 @@
+package cpu6502
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FormatInstruction formats the instruction at pc as the first part of a
+// nestest log line: address, bytes, a * for unofficial opcodes and the
+// disassembly with the effective address and the value found there, padded
+// to where the registers start.
+func FormatInstruction(peek func(uint16) byte, pc uint16, x, y byte) string {
+	info := LookupOpcode(peek(pc))
+	bytes := make([]string, info.Size)
+	for i := range bytes {
+		bytes[i] = fmt.Sprintf("%02X", peek(pc+uint16(i)))
+	}
+	marker := " "
+	if !info.Official {
+		marker = "*"
+	}
+	return fmt.Sprintf("%04X  %-8s %s%-32s", pc, strings.Join(bytes, " "), marker,
+		disassembleTrace(peek, pc, x, y))
+}
+
+// disassembleTrace formats an instruction with its operand and, like
+// Nintendulator, the effective address and the value found there.
+func disassembleTrace(peek func(uint16) byte, pc uint16, x, y byte) string {
+	info := LookupOpcode(peek(pc))
+	name := info.Name
+	lo := peek(pc + 1)
+	hi := peek(pc + 2)
+	word := uint16(hi)<<8 | uint16(lo)
+	read16bug := func(address uint16) uint16 {
+		next := (address & 0xFF00) | uint16(byte(address)+1)
+		return uint16(peek(next))<<8 | uint16(peek(address))
+	}
+	switch info.Mode {
+	case ModeAbsolute:
+		if name == "JMP" || name == "JSR" {
+			return fmt.Sprintf("%s $%04X", name, word)
+		}
+		return fmt.Sprintf("%s $%04X = %02X", name, word, peek(word))
+	case ModeAbsoluteX:
+		address := word + uint16(x)
+		return fmt.Sprintf("%s $%04X,X @ %04X = %02X", name, word, address, peek(address))
+	case ModeAbsoluteY:
+		address := word + uint16(y)
+		return fmt.Sprintf("%s $%04X,Y @ %04X = %02X", name, word, address, peek(address))
+	case ModeAccumulator:
+		return name + " A"
+	case ModeImmediate:
+		return fmt.Sprintf("%s #$%02X", name, lo)
+	case ModeIndexedIndirect:
+		pointer := lo + x
+		address := read16bug(uint16(pointer))
+		return fmt.Sprintf("%s ($%02X,X) @ %02X = %04X = %02X",
+			name, lo, pointer, address, peek(address))
+	case ModeIndirect:
+		return fmt.Sprintf("%s ($%04X) = %04X", name, word, read16bug(word))
+	case ModeIndirectIndexed:
+		base := read16bug(uint16(lo))
+		address := base + uint16(y)
+		return fmt.Sprintf("%s ($%02X),Y = %04X @ %04X = %02X",
+			name, lo, base, address, peek(address))
+	case ModeRelative:
+		return fmt.Sprintf("%s $%04X", name, pc+2+uint16(int8(lo)))
+	case ModeZeroPage:
+		return fmt.Sprintf("%s $%02X = %02X", name, lo, peek(uint16(lo)))
+	case ModeZeroPageX:
+		address := lo + x
+		return fmt.Sprintf("%s $%02X,X @ %02X = %02X", name, lo, address, peek(uint16(address)))
+	case ModeZeroPageY:
+		address := lo + y
+		return fmt.Sprintf("%s $%02X,Y @ %02X = %02X", name, lo, address, peek(uint16(address)))
+	}
+	return name
+}
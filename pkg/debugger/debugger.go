@@ -0,0 +1,590 @@
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/se-nonide/go6502/pkg/cpu6502"
+	"github.com/se-nonide/go6502/pkg/device6502"
+	"github.com/se-nonide/go6502/pkg/disasm"
+	"github.com/se-nonide/go6502/pkg/ppuview"
+)
+
+type breakpoint struct {
+	id        int
+	watch     bool
+	space     device6502.AddressSpace
+	start     uint16
+	end       uint16 // inclusive
+	read      bool
+	write     bool
+	always    bool // condition-only breakpoint, checked on every instruction
+	condition expr
+	text      string
+	enabled   bool
+}
+
+// Debugger wraps a Device with breakpoints, watchpoints and stepping. It is
+// driven either directly through Execute or from a REPL: Listen queues
+// lines read from a reader and Poll runs them on the emulation goroutine,
+// so a frontend can keep rendering between commands.
+type Debugger struct {
+	device      *device6502.Device
+	out         io.Writer
+	breakpoints []*breakpoint
+	nextID      int
+	paused      bool
+	skipBreak   bool        // resuming from a breakpoint on the current PC
+	target      func() bool // run-to condition checked on instruction boundaries
+	targetName  string
+	hit         string // watchpoints hit since the last report
+	lastOpcode  byte
+	lastCommand string
+	commands    chan string
+}
+
+func New(device *device6502.Device, out io.Writer) *Debugger {
+	return &Debugger{device: device, out: out, nextID: 1}
+}
+
+// Listen reads commands line by line from r until it is exhausted.
+func (d *Debugger) Listen(r io.Reader) {
+	d.commands = make(chan string, 16)
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			d.commands <- scanner.Text()
+		}
+	}()
+	d.prompt()
+}
+
+// Poll executes the commands queued by Listen without blocking.
+func (d *Debugger) Poll() {
+	for {
+		select {
+		case line := <-d.commands:
+			d.Execute(line)
+			d.prompt()
+		default:
+			return
+		}
+	}
+}
+
+func (d *Debugger) prompt() {
+	fmt.Fprint(d.out, "(go6502) ")
+}
+
+func (d *Debugger) Paused() bool {
+	return d.paused
+}
+
+// Pause stops emulation at the next instruction boundary.
+func (d *Debugger) Pause(reason string) {
+	d.paused = true
+	d.target = nil
+	d.hit = ""
+	fmt.Fprintf(d.out, "\n%s\n", reason)
+	d.printLocation()
+}
+
+func (d *Debugger) resume() {
+	d.paused = false
+	d.skipBreak = true
+	d.hit = ""
+}
+
+// StepSeconds advances the emulation like Device.StepSeconds, stopping at
+// breakpoints, watchpoints and run-to targets.
+func (d *Debugger) StepSeconds(seconds float64) {
+	if d.paused {
+		return
+	}
+	cycles := int(d.device.Region().CPUFrequency() * seconds)
+	for cycles > 0 {
+		if d.device.CPU.Fetching() {
+			if d.checkBoundary() {
+				return
+			}
+		}
+		cycles -= d.step()
+		if d.hit != "" {
+			d.Pause(d.hit)
+			return
+		}
+		if err := d.device.Err(); err != nil {
+			d.Pause(err.Error())
+			return
+		}
+	}
+}
+
+func (d *Debugger) step() int {
+	if d.device.CPU.Fetching() {
+		d.lastOpcode = d.device.Peek(d.device.CPU.PC)
+	}
+	return d.device.Step()
+}
+
+// checkBoundary reports whether execution must stop before the next
+// instruction.
+func (d *Debugger) checkBoundary() bool {
+	if d.target != nil && d.target() {
+		d.Pause("reached " + d.targetName)
+		return true
+	}
+	if d.skipBreak {
+		d.skipBreak = false
+		return false
+	}
+	pc := d.device.CPU.PC
+	for _, b := range d.breakpoints {
+		if !b.enabled || b.watch {
+			continue
+		}
+		if !b.always && b.start != pc {
+			continue
+		}
+		if b.condition != nil && b.condition(d.device) == 0 {
+			continue
+		}
+		d.Pause(fmt.Sprintf("breakpoint %d: %s", b.id, b.text))
+		return true
+	}
+	return false
+}
+
+// stepInstruction executes one instruction, along with any stall cycles or
+// interrupt entry that follows it, so that the CPU is left at the next
+// instruction boundary.
+func (d *Debugger) stepInstruction() {
+	executed := false
+	for d.device.Err() == nil {
+		fetching := d.device.CPU.Fetching()
+		if fetching && executed {
+			return
+		}
+		d.step()
+		executed = executed || fetching
+	}
+}
+
+func (d *Debugger) onAccess(space device6502.AddressSpace, address uint16, value byte, write bool) {
+	for _, b := range d.breakpoints {
+		if !b.enabled || !b.watch || b.space != space {
+			continue
+		}
+		if address < b.start || address > b.end {
+			continue
+		}
+		if write && !b.write || !write && !b.read {
+			continue
+		}
+		if b.condition != nil && b.condition(d.device) == 0 {
+			continue
+		}
+		access := "read"
+		if write {
+			access = "write"
+		}
+		if d.hit != "" {
+			d.hit += "\n"
+		}
+		d.hit += fmt.Sprintf("watchpoint %d: %s $%02X at %s $%04X",
+			b.id, access, value, spaceName(space), address)
+		return
+	}
+}
+
+func (d *Debugger) updateHook() {
+	for _, b := range d.breakpoints {
+		if b.watch && b.enabled {
+			d.device.SetAccessHook(d.onAccess)
+			return
+		}
+	}
+	d.device.SetAccessHook(nil)
+}
+
+func spaceName(space device6502.AddressSpace) string {
+	if space == device6502.SpacePPU {
+		return "PPU"
+	}
+	return "CPU"
+}
+
+const help = `commands:
+  break <addr> [if <expr>]        break when PC reaches addr
+  break if <expr>                 break when expr is true before any instruction
+  watch [r|w|rw] [cpu|ppu] <addr> [<end>] [if <expr>]
+                                  break on bus accesses in addr..end
+  list | delete <id>|all | enable <id> | disable <id>
+  continue | pause | reset
+  step [n]                        step into
+  next                            step over JSR
+  finish                          run until the current subroutine returns
+  scanline <n>                    run until the PPU reaches scanline n
+  frame [n]                       run until frame n or the next frame
+  regs | print <expr> | mem <addr> [len] | ppumem <addr> [len]
+  disasm [addr] [count]
+  oam                             list the 64 sprites
+  views [prefix]                  save pattern, nametable, OAM and palette PNGs
+expressions use A X Y SP PC P, flags C Z I D V N, scanline dot frame cycles,
+[addr] for CPU memory and ppu[addr] for PPU memory`
+
+// Execute runs one debugger command. An empty line repeats the previous
+// command.
+func (d *Debugger) Execute(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		line = d.lastCommand
+	}
+	d.lastCommand = line
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return
+	}
+	if err := d.execute(fields[0], fields[1:]); err != nil {
+		fmt.Fprintln(d.out, "error:", err)
+	}
+}
+
+func (d *Debugger) execute(command string, args []string) error {
+	switch command {
+	case "help", "h", "?":
+		fmt.Fprintln(d.out, help)
+	case "break", "b":
+		return d.addBreakpoint(args)
+	case "watch", "w":
+		return d.addWatchpoint(args)
+	case "list", "l":
+		for _, b := range d.breakpoints {
+			state := ""
+			if !b.enabled {
+				state = " (disabled)"
+			}
+			fmt.Fprintf(d.out, "%d: %s%s\n", b.id, b.text, state)
+		}
+	case "delete", "d":
+		return d.delete(args)
+	case "enable", "disable":
+		b, err := d.find(args)
+		if err != nil {
+			return err
+		}
+		b.enabled = command == "enable"
+		d.updateHook()
+	case "continue", "c":
+		d.resume()
+	case "pause":
+		if !d.paused {
+			d.Pause("paused")
+		}
+	case "reset":
+		d.device.Reset()
+		d.printLocation()
+	case "step", "s":
+		n := 1
+		if len(args) > 0 {
+			value, err := strconv.Atoi(args[0])
+			if err != nil {
+				return err
+			}
+			n = value
+		}
+		d.paused = true
+		for i := 0; i < n && d.device.Err() == nil; i++ {
+			d.stepInstruction()
+			if d.hit != "" {
+				fmt.Fprintln(d.out, d.hit)
+				d.hit = ""
+				break
+			}
+		}
+		d.printLocation()
+	case "next", "n":
+		cpu := d.device.CPU
+		if cpu6502.LookupOpcode(d.device.Peek(cpu.PC)).Name != "JSR" {
+			return d.execute("step", nil)
+		}
+		pc, sp := cpu.PC+3, cpu.SP
+		d.runTo("return from subroutine", func() bool {
+			return cpu.PC == pc && cpu.SP == sp
+		})
+	case "finish", "out":
+		cpu := d.device.CPU
+		sp := cpu.SP
+		d.runTo("return to caller", func() bool {
+			name := cpu6502.LookupOpcode(d.lastOpcode).Name
+			return (name == "RTS" || name == "RTI") && cpu.SP > sp
+		})
+	case "scanline":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: scanline <n>")
+		}
+		line, err := strconv.Atoi(args[0])
+		if err != nil {
+			return err
+		}
+		left := d.device.PPU.ScanLine != line
+		d.runTo(fmt.Sprintf("scanline %d", line), func() bool {
+			if d.device.PPU.ScanLine != line {
+				left = true
+				return false
+			}
+			return left
+		})
+	case "frame":
+		frame := d.device.PPU.Frame + 1
+		if len(args) > 0 {
+			value, err := strconv.ParseUint(args[0], 10, 64)
+			if err != nil {
+				return err
+			}
+			frame = value
+		}
+		d.runTo(fmt.Sprintf("frame %d", frame), func() bool {
+			return d.device.PPU.Frame >= frame
+		})
+	case "regs", "r":
+		d.printLocation()
+	case "print", "p":
+		e, err := parseExpr(strings.Join(args, " "))
+		if err != nil {
+			return err
+		}
+		value := e(d.device)
+		fmt.Fprintf(d.out, "%d ($%X)\n", value, value)
+	case "mem", "x":
+		return d.dump(args, d.device.Peek)
+	case "ppumem":
+		return d.dump(args, d.device.PeekPPU)
+	case "disasm", "u":
+		return d.disassemble(args)
+	case "oam":
+		for _, sprite := range ppuview.Sprites(d.device) {
+			fmt.Fprintln(d.out, sprite)
+		}
+	case "views":
+		prefix := ""
+		if len(args) > 0 {
+			prefix = args[0]
+		}
+		files, err := ppuview.Export(d.device, prefix)
+		for _, file := range files {
+			fmt.Fprintln(d.out, "wrote", file)
+		}
+		return err
+	default:
+		return fmt.Errorf("unknown command %q, try help", command)
+	}
+	return nil
+}
+
+func (d *Debugger) runTo(name string, target func() bool) {
+	d.target = target
+	d.targetName = name
+	d.resume()
+}
+
+// splitCondition separates a trailing "if <expr>" clause.
+func splitCondition(args []string) ([]string, expr, error) {
+	for i, arg := range args {
+		if arg == "if" {
+			condition, err := parseExpr(strings.Join(args[i+1:], " "))
+			return args[:i], condition, err
+		}
+	}
+	return args, nil, nil
+}
+
+func (d *Debugger) value(text string) (int, error) {
+	e, err := parseExpr(text)
+	if err != nil {
+		return 0, err
+	}
+	return e(d.device), nil
+}
+
+func (d *Debugger) add(b *breakpoint, args []string) {
+	b.id = d.nextID
+	b.enabled = true
+	b.text = strings.Join(args, " ")
+	d.nextID++
+	d.breakpoints = append(d.breakpoints, b)
+	fmt.Fprintf(d.out, "%d: %s\n", b.id, b.text)
+}
+
+func (d *Debugger) addBreakpoint(args []string) error {
+	location, condition, err := splitCondition(args)
+	if err != nil {
+		return err
+	}
+	b := &breakpoint{condition: condition}
+	switch len(location) {
+	case 0:
+		if condition == nil {
+			return fmt.Errorf("usage: break <addr> [if <expr>] | break if <expr>")
+		}
+		b.always = true
+	case 1:
+		address, err := d.value(location[0])
+		if err != nil {
+			return err
+		}
+		b.start = uint16(address)
+	default:
+		return fmt.Errorf("usage: break <addr> [if <expr>] | break if <expr>")
+	}
+	d.add(b, append([]string{"break"}, args...))
+	return nil
+}
+
+func (d *Debugger) addWatchpoint(args []string) error {
+	location, condition, err := splitCondition(args)
+	if err != nil {
+		return err
+	}
+	b := &breakpoint{watch: true, condition: condition, read: true, write: true}
+	if len(location) > 0 {
+		switch location[0] {
+		case "r":
+			b.write = false
+			location = location[1:]
+		case "w":
+			b.read = false
+			location = location[1:]
+		case "rw":
+			location = location[1:]
+		}
+	}
+	if len(location) > 0 {
+		switch location[0] {
+		case "cpu":
+			location = location[1:]
+		case "ppu":
+			b.space = device6502.SpacePPU
+			location = location[1:]
+		}
+	}
+	if len(location) < 1 || len(location) > 2 {
+		return fmt.Errorf("usage: watch [r|w|rw] [cpu|ppu] <addr> [<end>] [if <expr>]")
+	}
+	start, err := d.value(location[0])
+	if err != nil {
+		return err
+	}
+	b.start = uint16(start)
+	b.end = b.start
+	if len(location) == 2 {
+		end, err := d.value(location[1])
+		if err != nil {
+			return err
+		}
+		b.end = uint16(end)
+		if b.end < b.start {
+			return fmt.Errorf("watch range ends at $%04X before it starts at $%04X", b.end, b.start)
+		}
+	}
+	d.add(b, append([]string{"watch"}, args...))
+	d.updateHook()
+	return nil
+}
+
+func (d *Debugger) find(args []string) (*breakpoint, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expected a breakpoint number")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range d.breakpoints {
+		if b.id == id {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("no breakpoint %d", id)
+}
+
+func (d *Debugger) delete(args []string) error {
+	if len(args) == 1 && args[0] == "all" {
+		d.breakpoints = nil
+		d.updateHook()
+		return nil
+	}
+	b, err := d.find(args)
+	if err != nil {
+		return err
+	}
+	for i := range d.breakpoints {
+		if d.breakpoints[i] == b {
+			d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+			break
+		}
+	}
+	d.updateHook()
+	return nil
+}
+
+func (d *Debugger) printLocation() {
+	cpu := d.device.CPU
+	ppu := d.device.PPU
+	inst := disasm.DecodeMemory(disasm.ReaderFunc(d.device.Peek), cpu.PC)
+	fmt.Fprintf(d.out, "%04X  %-16s A:%02X X:%02X Y:%02X P:%02X SP:%02X PPU:%3d,%3d FRAME:%d CYC:%d\n",
+		cpu.PC, inst.String(), cpu.A, cpu.X, cpu.Y, cpu.Flags(), cpu.SP,
+		ppu.ScanLine, ppu.Cycle, ppu.Frame, cpu.Cycles)
+}
+
+func (d *Debugger) dump(args []string, peek func(uint16) byte) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: mem <addr> [len]")
+	}
+	start, err := d.value(args[0])
+	if err != nil {
+		return err
+	}
+	length := 64
+	if len(args) == 2 {
+		if length, err = d.value(args[1]); err != nil {
+			return err
+		}
+	}
+	for row := 0; row < length; row += 16 {
+		address := uint16(start + row)
+		fmt.Fprintf(d.out, "%04X:", address)
+		for i := 0; i < 16 && row+i < length; i++ {
+			fmt.Fprintf(d.out, " %02X", peek(address+uint16(i)))
+		}
+		fmt.Fprintln(d.out)
+	}
+	return nil
+}
+
+func (d *Debugger) disassemble(args []string) error {
+	address := int(d.device.CPU.PC)
+	count := 10
+	var err error
+	if len(args) > 0 {
+		if address, err = d.value(args[0]); err != nil {
+			return err
+		}
+	}
+	if len(args) > 1 {
+		if count, err = d.value(args[1]); err != nil {
+			return err
+		}
+	}
+	var instructions []disasm.Instruction
+	pc := uint16(address)
+	for i := 0; i < count; i++ {
+		inst := disasm.DecodeMemory(disasm.ReaderFunc(d.device.Peek), pc)
+		instructions = append(instructions, inst)
+		pc += uint16(len(inst.Bytes))
+	}
+	return disasm.Fprint(d.out, instructions, nil)
+}
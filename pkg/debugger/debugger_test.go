@@ -0,0 +1,213 @@
+package debugger
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/se-nonide/go6502/internal/nesrom"
+	"github.com/se-nonide/go6502/internal/nesrom/nesdevice"
+)
+
+func TestWatchpointHits(t *testing.T) {
+	// LDA #1; STA $0300; STA $0300; JMP *
+	program := []byte{0xA9, 0x01, 0x8D, 0x00, 0x03, 0x8D, 0x00, 0x03, 0x4C, 0x08, 0xC0}
+	device, err := nesdevice.New(nesrom.NROM(program, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	d := New(device, &out)
+	d.Execute("watch w $0300")
+	d.Execute("step 3")
+	if !strings.Contains(out.String(), "watchpoint 1: write $01 at CPU $0300") {
+		t.Fatalf("step did not report the hit:\n%s", out.String())
+	}
+	if device.CPU.PC != 0xC005 || d.hit != "" {
+		t.Fatalf("PC = $%04X, hit %q after the step, want $C005 and cleared", device.CPU.PC, d.hit)
+	}
+
+	// the reported hit must not stop the run, the second store must
+	out.Reset()
+	d.Execute("continue")
+	d.StepSeconds(0.001)
+	if !d.Paused() || device.CPU.PC != 0xC008 {
+		t.Errorf("PC = $%04X, paused %v, want a stop after the second store", device.CPU.PC, d.Paused())
+	}
+}
+
+func TestWatchRange(t *testing.T) {
+	device, err := nesdevice.New(nesrom.NROM(nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := New(device, &bytes.Buffer{})
+	if err := d.execute("watch", []string{"$0310", "$0300"}); err == nil {
+		t.Error("reversed range accepted")
+	}
+	if err := d.execute("watch", []string{"$0300", "$0310"}); err != nil {
+		t.Error(err)
+	}
+}
+
+// newProgramDebugger runs this program from $C000:
+//
+//	C000  LDX #$00
+//	C002  JSR $C010
+//	C005  INX
+//	C006  JMP $C006
+//	C010  LDA #$05
+//	C012  JSR $C020
+//	C015  RTS
+//	C020  STA $10
+//	C022  RTS
+func newProgramDebugger(t *testing.T) (*Debugger, *bytes.Buffer) {
+	program := make([]byte, 0x23)
+	copy(program, []byte{0xA2, 0x00, 0x20, 0x10, 0xC0, 0xE8, 0x4C, 0x06, 0xC0})
+	copy(program[0x10:], []byte{0xA9, 0x05, 0x20, 0x20, 0xC0, 0x60})
+	copy(program[0x20:], []byte{0x85, 0x10, 0x60})
+	device, err := nesdevice.New(nesrom.NROM(program, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	return New(device, &out), &out
+}
+
+// run lets the debugger go until it pauses, failing after a second of
+// emulated time.
+func run(t *testing.T, d *Debugger) {
+	t.Helper()
+	for i := 0; i < 1000 && !d.Paused(); i++ {
+		d.StepSeconds(0.001)
+	}
+	if !d.Paused() {
+		t.Fatal("never paused")
+	}
+}
+
+// checkStop checks where the debugger stopped, the cycle count relative to
+// the start of the program.
+func checkStop(t *testing.T, d *Debugger, start uint64, pc uint16, cycles uint64) {
+	t.Helper()
+	cpu := d.device.CPU
+	if cpu.PC != pc || cpu.Cycles-start != cycles {
+		t.Errorf("stopped at $%04X after %d cycles, want $%04X after %d", cpu.PC, cpu.Cycles-start, pc, cycles)
+	}
+}
+
+func TestBreakpoint(t *testing.T) {
+	d, out := newProgramDebugger(t)
+	start := d.device.CPU.Cycles
+	d.Execute("break $C020")
+	d.Execute("continue")
+	run(t, d)
+	// LDX 2, JSR 6, LDA 2, JSR 6
+	checkStop(t, d, start, 0xC020, 16)
+	if !strings.Contains(out.String(), "breakpoint 1: break $C020") {
+		t.Errorf("stop not reported:\n%s", out.String())
+	}
+
+	// continuing from the breakpoint runs on rather than stopping again
+	d.Execute("delete 1")
+	d.Execute("break $C006")
+	d.Execute("continue")
+	run(t, d)
+	// STA 3, RTS 6, RTS 6, INX 2
+	checkStop(t, d, start, 0xC006, 33)
+}
+
+func TestConditionalBreakpoint(t *testing.T) {
+	d, out := newProgramDebugger(t)
+	start := d.device.CPU.Cycles
+	d.Execute("break $C020 if A == 6")
+	d.Execute("break if X == 1")
+	d.Execute("continue")
+	run(t, d)
+	if !strings.Contains(out.String(), "breakpoint 2: break if X == 1") {
+		t.Errorf("stopped by the wrong breakpoint:\n%s", out)
+	}
+	checkStop(t, d, start, 0xC006, 33)
+}
+
+func TestStepNextFinish(t *testing.T) {
+	d, _ := newProgramDebugger(t)
+	start := d.device.CPU.Cycles
+	d.Execute("step")
+	checkStop(t, d, start, 0xC002, 2)
+	// step goes into the subroutine
+	d.Execute("step 2")
+	checkStop(t, d, start, 0xC012, 10)
+
+	// next runs the whole nested call
+	d.Execute("next")
+	run(t, d)
+	checkStop(t, d, start, 0xC015, 25)
+	if d.device.Peek(0x10) != 5 {
+		t.Errorf("$10 = %d after the call, want 5", d.device.Peek(0x10))
+	}
+
+	// finish returns to the caller
+	d, _ = newProgramDebugger(t)
+	start = d.device.CPU.Cycles
+	d.Execute("break $C020")
+	d.Execute("continue")
+	run(t, d)
+	d.Execute("finish")
+	run(t, d)
+	checkStop(t, d, start, 0xC015, 25)
+	d.Execute("finish")
+	run(t, d)
+	checkStop(t, d, start, 0xC005, 31)
+
+	// next on anything but JSR is a step
+	d.Execute("next")
+	checkStop(t, d, start, 0xC006, 33)
+}
+
+func TestRunToScanlineAndFrame(t *testing.T) {
+	d, _ := newProgramDebugger(t)
+	ppu := d.device.PPU
+	d.Execute("scanline 100")
+	run(t, d)
+	// the stop comes at the first instruction boundary on the line, and
+	// JMP takes 3 cycles, 9 dots
+	if ppu.ScanLine != 100 || ppu.Cycle >= 9 {
+		t.Errorf("stopped at scanline %d dot %d, want scanline 100 within 9 dots", ppu.ScanLine, ppu.Cycle)
+	}
+
+	frame := ppu.Frame
+	d.Execute("frame")
+	run(t, d)
+	if ppu.Frame != frame+1 || ppu.ScanLine != 0 || ppu.Cycle >= 9 {
+		t.Errorf("stopped in frame %d at scanline %d dot %d, want the start of frame %d",
+			ppu.Frame, ppu.ScanLine, ppu.Cycle, frame+1)
+	}
+	d.Execute(fmt.Sprintf("frame %d", frame+3))
+	run(t, d)
+	if ppu.Frame != frame+3 {
+		t.Errorf("stopped in frame %d, want %d", ppu.Frame, frame+3)
+	}
+}
+
+func TestMemAndDisasm(t *testing.T) {
+	d, out := newProgramDebugger(t)
+	d.Execute("mem $C000 20")
+	want := "C000: A2 00 20 10 C0 E8 4C 06 C0 00 00 00 00 00 00 00\n" +
+		"C010: A9 05 20 20\n"
+	if out.String() != want {
+		t.Errorf("mem printed\n%s\nwant\n%s", out, want)
+	}
+
+	out.Reset()
+	d.Execute("disasm $C010 3")
+	for _, line := range []string{"C010", "LDA #$05", "C012", "JSR $C020", "C015", "RTS"} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("disasm output lacks %q:\n%s", line, out)
+		}
+	}
+	if lines := strings.Count(out.String(), "\n"); lines != 3 {
+		t.Errorf("disasm printed %d lines, want 3:\n%s", lines, out)
+	}
+}
@@ -0,0 +1,324 @@
+package debugger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/se-nonide/go6502/pkg/device6502"
+)
+
+// Expressions are small C-like formulas over the machine state, e.g.
+//
+//	A == $10 && [$0300] != 0
+//	scanline > 240 || ppu[$3F00] == $0F
+//
+// Numbers are decimal, $hex or %binary. Identifiers name the registers A, X,
+// Y, SP, PC and P, the flags C, Z, I, D, V and N, and the counters scanline,
+// dot, frame and cycles. [addr] reads a byte from the CPU bus and ppu[addr]
+// one from the PPU bus, both without side effects. Comparisons and logical
+// operators yield 1 or 0.
+type expr func(device *device6502.Device) int
+
+type token struct {
+	kind  byte // 'n' number, 'i' identifier, 'o' operator, 0 end
+	text  string
+	value int
+}
+
+type exprParser struct {
+	tokens []token
+	pos    int
+}
+
+func parseExpr(text string) (expr, error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	e, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != 0 {
+		return nil, fmt.Errorf("unexpected %q", t.text)
+	}
+	return e, nil
+}
+
+func tokenize(text string) ([]token, error) {
+	var tokens []token
+	operators := []string{
+		"||", "&&", "==", "!=", "<=", ">=", "<<", ">>",
+		"<", ">", "+", "-", "*", "/", "%", "&", "|", "^", "!", "~", "(", ")", "[", "]",
+	}
+	for i := 0; i < len(text); {
+		c := rune(text[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c) || c == '$' || c == '%' && expectsOperand(tokens):
+			base := 10
+			start := i
+			if c == '$' {
+				base = 16
+				i++
+				start = i
+			} else if c == '%' {
+				base = 2
+				i++
+				start = i
+			}
+			for i < len(text) && isAlnum(rune(text[i])) {
+				i++
+			}
+			value, err := strconv.ParseInt(text[start:i], base, 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad number %q", text[start:i])
+			}
+			tokens = append(tokens, token{'n', text[start:i], int(value)})
+		case unicode.IsLetter(c) || c == '_':
+			start := i
+			for i < len(text) && (isAlnum(rune(text[i])) || text[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{kind: 'i', text: strings.ToLower(text[start:i])})
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(text[i:], op) {
+					tokens = append(tokens, token{kind: 'o', text: op})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q", c)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+// expectsOperand tells a binary number prefix apart from the modulo operator
+func expectsOperand(tokens []token) bool {
+	if len(tokens) == 0 {
+		return true
+	}
+	last := tokens[len(tokens)-1]
+	return last.kind == 'o' && last.text != ")" && last.text != "]"
+}
+
+func isAlnum(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+func (p *exprParser) peek() token {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return token{}
+}
+
+func (p *exprParser) next() token {
+	t := p.peek()
+	if p.pos < len(p.tokens) {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) expect(op string) error {
+	if t := p.next(); t.kind != 'o' || t.text != op {
+		return fmt.Errorf("expected %q", op)
+	}
+	return nil
+}
+
+// binary operators from lowest to highest precedence
+var precedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *exprParser) binary(level int) (expr, error) {
+	if level == len(precedence) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != 'o' || !contains(precedence[level], t.text) {
+			return left, nil
+		}
+		p.next()
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = combine(t.text, left, right)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func truth(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func combine(op string, l, r expr) expr {
+	switch op {
+	case "||":
+		return func(d *device6502.Device) int { return truth(l(d) != 0 || r(d) != 0) }
+	case "&&":
+		return func(d *device6502.Device) int { return truth(l(d) != 0 && r(d) != 0) }
+	case "|":
+		return func(d *device6502.Device) int { return l(d) | r(d) }
+	case "^":
+		return func(d *device6502.Device) int { return l(d) ^ r(d) }
+	case "&":
+		return func(d *device6502.Device) int { return l(d) & r(d) }
+	case "==":
+		return func(d *device6502.Device) int { return truth(l(d) == r(d)) }
+	case "!=":
+		return func(d *device6502.Device) int { return truth(l(d) != r(d)) }
+	case "<":
+		return func(d *device6502.Device) int { return truth(l(d) < r(d)) }
+	case "<=":
+		return func(d *device6502.Device) int { return truth(l(d) <= r(d)) }
+	case ">":
+		return func(d *device6502.Device) int { return truth(l(d) > r(d)) }
+	case ">=":
+		return func(d *device6502.Device) int { return truth(l(d) >= r(d)) }
+	case "<<":
+		return func(d *device6502.Device) int { return l(d) << uint(r(d)&63) }
+	case ">>":
+		return func(d *device6502.Device) int { return l(d) >> uint(r(d)&63) }
+	case "+":
+		return func(d *device6502.Device) int { return l(d) + r(d) }
+	case "-":
+		return func(d *device6502.Device) int { return l(d) - r(d) }
+	case "*":
+		return func(d *device6502.Device) int { return l(d) * r(d) }
+	case "/":
+		return func(d *device6502.Device) int {
+			if divisor := r(d); divisor != 0 {
+				return l(d) / divisor
+			}
+			return 0
+		}
+	case "%":
+		return func(d *device6502.Device) int {
+			if divisor := r(d); divisor != 0 {
+				return l(d) % divisor
+			}
+			return 0
+		}
+	}
+	panic("unknown operator " + op)
+}
+
+func (p *exprParser) unary() (expr, error) {
+	t := p.peek()
+	if t.kind == 'o' && (t.text == "-" || t.text == "!" || t.text == "~") {
+		p.next()
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		switch t.text {
+		case "-":
+			return func(d *device6502.Device) int { return -operand(d) }, nil
+		case "!":
+			return func(d *device6502.Device) int { return truth(operand(d) == 0) }, nil
+		default:
+			return func(d *device6502.Device) int { return ^operand(d) }, nil
+		}
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (expr, error) {
+	t := p.next()
+	switch {
+	case t.kind == 'n':
+		value := t.value
+		return func(*device6502.Device) int { return value }, nil
+	case t.kind == 'o' && t.text == "(":
+		e, err := p.binary(0)
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(")")
+	case t.kind == 'o' && t.text == "[":
+		address, err := p.binary(0)
+		if err != nil {
+			return nil, err
+		}
+		return func(d *device6502.Device) int {
+			return int(d.Peek(uint16(address(d))))
+		}, p.expect("]")
+	case t.kind == 'i' && t.text == "ppu":
+		if err := p.expect("["); err != nil {
+			return nil, err
+		}
+		address, err := p.binary(0)
+		if err != nil {
+			return nil, err
+		}
+		return func(d *device6502.Device) int {
+			return int(d.PeekPPU(uint16(address(d))))
+		}, p.expect("]")
+	case t.kind == 'i':
+		if e, ok := variables[t.text]; ok {
+			return e, nil
+		}
+		return nil, fmt.Errorf("unknown identifier %q", t.text)
+	case t.kind == 0:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q", t.text)
+}
+
+var variables = map[string]expr{
+	"a":        func(d *device6502.Device) int { return int(d.CPU.A) },
+	"x":        func(d *device6502.Device) int { return int(d.CPU.X) },
+	"y":        func(d *device6502.Device) int { return int(d.CPU.Y) },
+	"sp":       func(d *device6502.Device) int { return int(d.CPU.SP) },
+	"pc":       func(d *device6502.Device) int { return int(d.CPU.PC) },
+	"p":        func(d *device6502.Device) int { return int(d.CPU.Flags()) },
+	"c":        func(d *device6502.Device) int { return int(d.CPU.C) },
+	"z":        func(d *device6502.Device) int { return int(d.CPU.Z) },
+	"i":        func(d *device6502.Device) int { return int(d.CPU.I) },
+	"d":        func(d *device6502.Device) int { return int(d.CPU.D) },
+	"v":        func(d *device6502.Device) int { return int(d.CPU.V) },
+	"n":        func(d *device6502.Device) int { return int(d.CPU.N) },
+	"scanline": func(d *device6502.Device) int { return d.PPU.ScanLine },
+	"dot":      func(d *device6502.Device) int { return d.PPU.Cycle },
+	"frame":    func(d *device6502.Device) int { return int(d.PPU.Frame) },
+	"cycles":   func(d *device6502.Device) int { return int(d.CPU.Cycles) },
+}
@@ -0,0 +1,44 @@
+package debugger
+
+import (
+	"testing"
+
+	"github.com/se-nonide/go6502/pkg/cpu6502"
+	"github.com/se-nonide/go6502/pkg/device6502"
+)
+
+func TestParseExpr(t *testing.T) {
+	device := &device6502.Device{CPU: &cpu6502.CPU{A: 0x10, X: 3, PC: 0xC000}}
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"$FF & %1010", 10},
+		{"-1 + 2", 1},
+		{"a == $10 && x < 4", 1},
+		{"A == $10 && X > 4", 0},
+		{"pc >> 8", 0xC0},
+		{"!0 || 1 / 0", 1},
+		{"~0 & $FF", 0xFF},
+	}
+	for _, test := range tests {
+		e, err := parseExpr(test.text)
+		if err != nil {
+			t.Errorf("%q: %v", test.text, err)
+			continue
+		}
+		if got := e(device); got != test.want {
+			t.Errorf("%q = %d, want %d", test.text, got, test.want)
+		}
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	for _, text := range []string{"", "1 +", "(1", "[2", "foo", "1 2", "$"} {
+		if _, err := parseExpr(text); err == nil {
+			t.Errorf("%q: expected an error", text)
+		}
+	}
+}
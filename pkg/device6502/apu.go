@@ -1,8 +1,10 @@
 package device6502
 
-import "encoding/gob"
+import (
+	"encoding/gob"
 
-const frameCounterRate = CPUFrequency / 240.0
+	"github.com/se-nonide/go6502/pkg/cpu6502"
+)
 
 var lengthTable = []byte{
 	10, 254, 20, 2, 40, 4, 80, 6, 160, 8, 60, 10, 14, 12, 26, 14,
@@ -29,6 +31,14 @@ var dmcTable = []byte{
 	214, 190, 170, 160, 143, 127, 113, 107, 95, 80, 71, 64, 53, 42, 36, 27,
 }
 
+var noiseTablePAL = []uint16{
+	4, 8, 14, 30, 60, 88, 118, 148, 188, 236, 354, 472, 708, 944, 1890, 3778,
+}
+
+var dmcTablePAL = []byte{
+	199, 177, 158, 149, 138, 118, 105, 99, 88, 74, 66, 59, 49, 39, 33, 25,
+}
+
 var pulseTable [31]float32
 var tndTable [203]float32
 
@@ -55,6 +65,8 @@ type APU struct {
 	frameValue  byte
 	frameIRQ    bool
 	filterChain FilterChain
+
+	frameCounterRate float64 // CPU cycles per frame counter step
 }
 
 func NewAPU(device *Device) *APU {
@@ -64,6 +76,7 @@ func NewAPU(device *Device) *APU {
 	apu.pulse1.channel = 1
 	apu.pulse2.channel = 2
 	apu.dmc.cpu = device.CPU
+	apu.dmc.memory = device.memory
 	return &apu
 }
 
@@ -98,8 +111,8 @@ func (apu *APU) Step() {
 	apu.cycle++
 	cycle2 := apu.cycle
 	apu.stepTimer()
-	f1 := int(float64(cycle1) / frameCounterRate)
-	f2 := int(float64(cycle2) / frameCounterRate)
+	f1 := int(float64(cycle1) / apu.frameCounterRate)
+	f2 := int(float64(cycle2) / apu.frameCounterRate)
 	if f1 != f2 {
 		apu.stepFrameCounter()
 	}
@@ -190,7 +203,7 @@ func (apu *APU) stepLength() {
 
 func (apu *APU) fireIRQ() {
 	if apu.frameIRQ {
-		apu.device.CPU.triggerIRQ()
+		apu.device.CPU.AssertIRQ(IRQFrameCounter)
 	}
 }
 
@@ -250,6 +263,13 @@ func (apu *APU) writeRegister(address uint16, value byte) {
 }
 
 func (apu *APU) readStatus() byte {
+	result := apu.peekStatus()
+	apu.device.CPU.AcknowledgeIRQ(IRQFrameCounter)
+	return result
+}
+
+// peekStatus returns $4015 without acknowledging the frame interrupt.
+func (apu *APU) peekStatus() byte {
 	var result byte
 	if apu.pulse1.lengthValue > 0 {
 		result |= 1
@@ -266,6 +286,13 @@ func (apu *APU) readStatus() byte {
 	if apu.dmc.currentLength > 0 {
 		result |= 16
 	}
+	cpu := apu.device.CPU
+	if cpu.IRQAsserted(IRQFrameCounter) {
+		result |= 64
+	}
+	if cpu.IRQAsserted(IRQDMC) {
+		result |= 128
+	}
 	return result
 }
 
@@ -275,6 +302,7 @@ func (apu *APU) writeControl(value byte) {
 	apu.triangle.enabled = value&4 == 4
 	apu.noise.enabled = value&8 == 8
 	apu.dmc.enabled = value&16 == 16
+	apu.device.CPU.AcknowledgeIRQ(IRQDMC)
 	if !apu.pulse1.enabled {
 		apu.pulse1.lengthValue = 0
 	}
@@ -299,6 +327,9 @@ func (apu *APU) writeControl(value byte) {
 func (apu *APU) writeFrameCounter(value byte) {
 	apu.framePeriod = 4 + (value>>7)&1
 	apu.frameIRQ = (value>>6)&1 == 0
+	if !apu.frameIRQ {
+		apu.device.CPU.AcknowledgeIRQ(IRQFrameCounter)
+	}
 	if apu.framePeriod == 5 {
 		apu.stepEnvelope()
 		apu.stepSweep()
@@ -603,6 +634,7 @@ type Noise struct {
 	envelopeValue   byte
 	envelopeVolume  byte
 	constantVolume  byte
+	periods         []uint16 // timer periods of the region
 }
 
 func (n *Noise) Save(encoder *gob.Encoder) error {
@@ -652,7 +684,7 @@ func (n *Noise) writeControl(value byte) {
 
 func (n *Noise) writePeriod(value byte) {
 	n.mode = value&0x80 == 0x80
-	n.timerPeriod = noiseTable[value&0x0F]
+	n.timerPeriod = n.periods[value&0x0F]
 }
 
 func (n *Noise) writeLength(value byte) {
@@ -719,7 +751,8 @@ func (n *Noise) output() byte {
 }
 
 type DMC struct {
-	cpu            *CPU
+	cpu            *cpu6502.CPU
+	memory         Memory
 	enabled        bool
 	value          byte
 	sampleAddress  uint16
@@ -732,6 +765,7 @@ type DMC struct {
 	tickValue      byte
 	loop           bool
 	irq            bool
+	periods        []byte // tick periods of the region
 }
 
 func (d *DMC) Save(encoder *gob.Encoder) error {
@@ -768,8 +802,11 @@ func (d *DMC) Load(decoder *gob.Decoder) error {
 
 func (d *DMC) writeControl(value byte) {
 	d.irq = value&0x80 == 0x80
+	if !d.irq {
+		d.cpu.AcknowledgeIRQ(IRQDMC)
+	}
 	d.loop = value&0x40 == 0x40
-	d.tickPeriod = dmcTable[value&0x0F]
+	d.tickPeriod = d.periods[value&0x0F]
 }
 
 func (d *DMC) writeValue(value byte) {
@@ -804,8 +841,8 @@ func (d *DMC) stepTimer() {
 
 func (d *DMC) stepReader() {
 	if d.currentLength > 0 && d.bitCount == 0 {
-		d.cpu.stall += 4
-		d.shiftRegister = d.cpu.Read(d.currentAddress)
+		d.cpu.Stall(4)
+		d.shiftRegister = d.memory.Read(d.currentAddress)
 		d.bitCount = 8
 		d.currentAddress++
 		if d.currentAddress == 0 {
@@ -814,6 +851,8 @@ func (d *DMC) stepReader() {
 		d.currentLength--
 		if d.currentLength == 0 && d.loop {
 			d.restart()
+		} else if d.currentLength == 0 && d.irq {
+			d.cpu.AssertIRQ(IRQDMC)
 		}
 	}
 }
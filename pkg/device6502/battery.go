@@ -0,0 +1,196 @@
+package device6502
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/se-nonide/go6502/pkg/loader"
+)
+
+// NonVolatileMapper is implemented by mappers with memory of their own that
+// keeps its contents without power, such as the serial EEPROM on Bandai
+// boards. It is saved after the cartridge's battery-backed RAM.
+type NonVolatileMapper interface {
+	Mapper
+	// NonVolatile returns the memory itself, which loading overwrites.
+	NonVolatile() []byte
+}
+
+// batteryRegions returns the memory kept by the battery or EEPROM: the
+// PRG-NVRAM after the PRG-RAM in SRAM, the CHR-NVRAM after the CHR-RAM,
+// and the mapper's own.
+func (device *Device) batteryRegions() [][]byte {
+	c := device.Cartridge
+	prg := c.SRAM[c.PRGRAMSize : c.PRGRAMSize+c.PRGNVRAMSize]
+	if c.Battery == 1 && c.PRGNVRAMSize == 0 {
+		// headers with the battery bit that size the RAM as volatile
+		prg = c.SRAM
+	}
+	regions := [][]byte{prg}
+	if c.CHRNVRAMSize > 0 {
+		regions = append(regions, c.CHR[c.CHRRAMSize:c.CHRRAMSize+c.CHRNVRAMSize])
+	}
+	if m, ok := device.Mapper.(NonVolatileMapper); ok {
+		regions = append(regions, m.NonVolatile())
+	}
+	return regions
+}
+
+// BatteryRAM returns a copy of all the memory a .sav file holds, empty for
+// games without any.
+func (device *Device) BatteryRAM() []byte {
+	var data []byte
+	for _, region := range device.batteryRegions() {
+		data = append(data, region...)
+	}
+	return data
+}
+
+// SetBatteryRAM restores memory saved by BatteryRAM. It returns false when
+// the size does not match, having loaded as much as fits.
+func (device *Device) SetBatteryRAM(data []byte) bool {
+	size := len(data)
+	for _, region := range device.batteryRegions() {
+		size -= len(region)
+		data = data[copy(region, data):]
+	}
+	return size == 0
+}
+
+// BatterySaver keeps a game's battery-backed memory in a .sav file. The
+// file is written by a goroutine of its own, so that Flush does not hold up
+// emulation while the disk syncs.
+type BatterySaver struct {
+	device *Device
+	path   string
+	saved  []byte      // contents last handed to the writer
+	writes chan []byte // holds the latest contents not yet written
+	done   chan struct{}
+	mutex  sync.Mutex
+	err    error // of the last write
+}
+
+// NewBatterySaver returns a saver for the device, or nil when the game has
+// nothing to save.
+func NewBatterySaver(device *Device, path string) *BatterySaver {
+	if len(device.BatteryRAM()) == 0 {
+		return nil
+	}
+	s := &BatterySaver{
+		device: device,
+		path:   path,
+		saved:  device.BatteryRAM(),
+		writes: make(chan []byte, 1),
+		done:   make(chan struct{}),
+	}
+	go s.write()
+	return s
+}
+
+// SavePath returns where a ROM's battery RAM goes: game.sav next to the
+// ROM, or in dir when it is not empty.
+func SavePath(rom, dir string) string {
+	base := loader.BaseName(rom)
+	if dir == "" {
+		return base + ".sav"
+	}
+	return filepath.Join(dir, filepath.Base(base)+".sav")
+}
+
+// Path returns the .sav file.
+func (s *BatterySaver) Path() string {
+	return s.path
+}
+
+// Load reads the .sav file into the device, if there is one yet.
+func (s *BatterySaver) Load() error {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !s.device.SetBatteryRAM(data) {
+		log.Printf("%s is %d bytes, expected %d", s.path, len(data), len(s.device.BatteryRAM()))
+	}
+	s.saved = data
+	log.Printf("Battery RAM loaded from %s", s.path)
+	return nil
+}
+
+// Flush queues the battery RAM for writing if it changed since the last
+// flush, replacing contents still waiting from the one before, and returns
+// the error of the last write that finished. The file is replaced
+// atomically, so a crash leaves either the old or the new save.
+func (s *BatterySaver) Flush() error {
+	data := s.device.BatteryRAM()
+	if !bytes.Equal(data, s.saved) {
+		s.saved = data
+		select {
+		case s.writes <- data:
+		default:
+			select {
+			case <-s.writes:
+			default:
+			}
+			s.writes <- data
+		}
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.err
+}
+
+// Close flushes the battery RAM a last time and waits until it is written.
+// The saver cannot be used afterwards.
+func (s *BatterySaver) Close() error {
+	s.Flush()
+	close(s.writes)
+	<-s.done
+	return s.err
+}
+
+// write writes the contents Flush queues until Close.
+func (s *BatterySaver) write() {
+	defer close(s.done)
+	for data := range s.writes {
+		err := writeFileAtomic(s.path, data)
+		s.mutex.Lock()
+		s.err = err
+		s.mutex.Unlock()
+	}
+}
+
+// writeFileAtomic writes to a temporary file in the same directory, syncs
+// it and renames it over path.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Chmod(0644); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
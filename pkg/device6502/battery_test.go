@@ -0,0 +1,103 @@
+package device6502
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// eepromMapper adds a serial EEPROM to a mapper.
+type eepromMapper struct {
+	Mapper
+	eeprom []byte
+}
+
+func (m *eepromMapper) NonVolatile() []byte {
+	return m.eeprom
+}
+
+func TestBatterySaver(t *testing.T) {
+	if NewBatterySaver(newTestDevice(t, nil), "unused.sav") != nil {
+		t.Error("saver for a game without a battery")
+	}
+
+	program := []byte{0xA9, 0x42, 0x8D, 0x05, 0x60, 0x4C, 0x05, 0xC0} // LDA #$42, STA $6005
+	device := newTestDevice(t, program, 0x02)
+	path := filepath.Join(t.TempDir(), "saves", "game.sav")
+	saver := NewBatterySaver(device, path)
+	if err := saver.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if err := saver.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("unchanged RAM was written")
+	}
+
+	device.StepFrame()
+	if err := saver.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := saver.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 0x2000 || data[5] != 0x42 {
+		t.Errorf("saved %d bytes with $%02X at 5", len(data), data[5])
+	}
+	if files, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*")); len(files) != 1 {
+		t.Errorf("files left behind: %v", files)
+	}
+
+	device = newTestDevice(t, nil, 0x02)
+	saver = NewBatterySaver(device, path)
+	if err := saver.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if device.Cartridge.SRAM[5] != 0x42 {
+		t.Error("battery RAM not loaded")
+	}
+}
+
+func TestNonVolatileMapper(t *testing.T) {
+	device := newTestDevice(t, nil, 0x02)
+	mapper := &eepromMapper{device.Mapper, make([]byte, 16)}
+	device.Mapper = mapper
+	data := device.BatteryRAM()
+	if len(data) != 0x2000+16 {
+		t.Fatalf("battery RAM is %d bytes", len(data))
+	}
+	data[1], data[0x2000+3] = 1, 2
+	if !device.SetBatteryRAM(data) {
+		t.Error("SetBatteryRAM rejected a matching size")
+	}
+	if device.Cartridge.SRAM[1] != 1 || mapper.eeprom[3] != 2 {
+		t.Error("SetBatteryRAM did not restore SRAM and EEPROM")
+	}
+	if device.SetBatteryRAM(data[:100]) {
+		t.Error("SetBatteryRAM accepted a short save")
+	}
+	if !bytes.Equal(device.Cartridge.SRAM[:100], data[:100]) {
+		t.Error("short save not loaded")
+	}
+}
+
+func TestSavePath(t *testing.T) {
+	tests := []struct {
+		rom, dir, want string
+	}{
+		{"games/zelda.nes", "", "games/zelda.sav"},
+		{"games/zelda.nes.gz", "", "games/zelda.sav"},
+		{"games/zelda.zip", "saves", "saves/zelda.sav"},
+	}
+	for _, test := range tests {
+		if got := SavePath(test.rom, test.dir); got != filepath.FromSlash(test.want) {
+			t.Errorf("SavePath(%q, %q) = %q, want %q", test.rom, test.dir, got, test.want)
+		}
+	}
+}
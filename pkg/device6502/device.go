@@ -2,6 +2,7 @@ package device6502
 
 import (
 	"encoding/gob"
+	"fmt"
 	"image"
 	"image/color"
 	"log"
@@ -10,12 +11,24 @@ import (
 
 	"github.com/se-nonide/go6502/pkg/cartridge"
 	"github.com/se-nonide/go6502/pkg/controller"
+	"github.com/se-nonide/go6502/pkg/cpu6502"
 	"github.com/se-nonide/go6502/pkg/loader"
 	"github.com/se-nonide/go6502/pkg/pallete"
+	"github.com/se-nonide/go6502/pkg/romdb"
+)
+
+// CPUFrequency is the NTSC CPU clock in Hz; see Region.CPUFrequency.
+const CPUFrequency = 1789773
+
+// IRQ sources sharing the CPU's IRQ line
+const (
+	IRQFrameCounter cpu6502.IRQSource = 1 << iota // APU frame counter
+	IRQDMC                                        // APU delta modulation channel
+	IRQMapper                                     // cartridge hardware
 )
 
 type Device struct {
-	CPU         *CPU
+	CPU         *cpu6502.CPU
 	APU         *APU
 	PPU         *PPU
 	Cartridge   *cartridge.Cartridge
@@ -23,26 +36,62 @@ type Device struct {
 	Controller2 *controller.Controller
 	Mapper      Mapper
 	RAM         []byte
+	screen      *image.RGBA
+	palette     *pallete.Table
+	tracer      *Tracer
+	accessHook  AccessHook
+	memory      Memory // CPU bus without the cycle clock, for DMA
+	ticks       int    // CPU cycles already clocked during the current Step
+
+	region          Region
+	dots            int     // PPU dots owed to the region's clock ratio
+	audioSampleRate float64 // see SetAudioSampleRate
+}
+
+// JamError reports a CPU halted by one of the KIL opcodes.
+type JamError struct {
+	PC     uint16 // address of the KIL opcode
+	Opcode byte
 }
 
+func (e *JamError) Error() string {
+	return fmt.Sprintf("CPU jammed at $%04X (opcode $%02X)", e.PC, e.Opcode)
+}
+
+// NewDevice loads a ROM file, which may be zipped or gzipped, and builds a
+// device around it.
 func NewDevice(path string) (*Device, error) {
 	cartridge, err := loader.LoadNESFile(path)
 	if err != nil {
 		return nil, err
 	}
-	ram := make([]byte, 2048)
-	controller1 := controller.NewController()
-	controller2 := controller.NewController()
+	return NewDeviceFromCartridge(cartridge)
+}
+
+// NewDeviceFromCartridge builds a device around a cartridge loaded by other
+// means, such as loader.LoadBytes for embedded ROMs. Headers of games in the
+// game database are corrected first.
+func NewDeviceFromCartridge(cartridge *cartridge.Cartridge) (*Device, error) {
+	romdb.Correct(cartridge)
 	device := Device{
-		nil, nil, nil, cartridge, controller1, controller2, nil, ram}
+		Cartridge:   cartridge,
+		Controller1: controller.NewController(),
+		Controller2: controller.NewController(),
+		RAM:         make([]byte, 2048),
+		palette:     &pallete.Emphasized,
+		region:      RegionNTSC,
+	}
 	mapper, err := NewMapper(&device)
 	if err != nil {
 		return nil, err
 	}
 	device.Mapper = mapper
-	device.CPU = NewCPU(&device)
+	device.memory = NewCPUMemory(&device)
+	device.CPU = cpu6502.New(device.memory, cpu6502.RP2A03)
 	device.APU = NewAPU(&device)
 	device.PPU = NewPPU(&device)
+	device.SetCycleAccurate(true)
+	device.SetRegion(CartridgeRegion(cartridge))
 	log.Printf("Nintendo Entertainment System created")
 	return &device, nil
 }
@@ -53,40 +102,127 @@ func (device *Device) Reset() {
 
 func (device *Device) Step() int {
 	//log.Print("Step")
+	if device.tracer != nil && device.CPU.Fetching() {
+		device.tracer.trace(device)
+	}
+	device.ticks = 0
 	cpuCycles := device.CPU.Step()
-	ppuCycles := cpuCycles * 3
-	for i := 0; i < ppuCycles; i++ {
+	for device.ticks < cpuCycles {
+		device.tick()
+	}
+	return cpuCycles
+}
+
+// tick advances the PPU, mapper and APU by one CPU cycle, which is three PPU
+// dots except on PAL where it is 3.2. The cycle-stepped
+// core ticks before each of its bus accesses; Step catches up whatever is
+// left, which is the whole instruction for the instruction-level core.
+func (device *Device) tick() {
+	device.ticks++
+	timing := device.region.timing()
+	device.dots += timing.dotsPerCycle
+	for device.dots >= timing.cyclesPerDots {
+		device.dots -= timing.cyclesPerDots
 		device.PPU.Step()
 		device.Mapper.Step()
 	}
-	for i := 0; i < cpuCycles; i++ {
-		device.APU.Step()
+	if mapper, ok := device.Mapper.(CycleMapper); ok {
+		mapper.StepCPU()
 	}
-	return cpuCycles
+	device.APU.Step()
+}
+
+// SetCycleAccurate selects between the cycle-stepped CPU core, which makes
+// every bus access including dummy reads and writes on its own cycle with
+// the PPU and APU clocked in between, and the faster instruction-level core
+// that runs a whole instruction before catching the rest of the machine up.
+// New devices are cycle-accurate.
+func (device *Device) SetCycleAccurate(accurate bool) {
+	var bus cpu6502.Bus = device.memory
+	if accurate {
+		bus = &clockedMemory{device.memory, device.tick}
+	}
+	device.CPU.Bus = bus
+	device.CPU.SetCycleAccurate(accurate)
+}
+
+func (device *Device) CycleAccurate() bool {
+	return device.CPU.CycleAccurate()
+}
+
+// Err returns the condition that stopped the device, if any. StepFrame and
+// StepSeconds do nothing while it is non-nil; Reset clears it.
+func (device *Device) Err() error {
+	if device.CPU.Jammed() {
+		pc := device.CPU.PC
+		return &JamError{pc, device.Peek(pc)}
+	}
+	if device.tracer != nil {
+		return device.tracer.Err()
+	}
+	return nil
 }
 
 func (device *Device) StepFrame() int {
 	cpuCycles := 0
 	frame := device.PPU.Frame
-	for frame == device.PPU.Frame {
+	for frame == device.PPU.Frame && device.Err() == nil {
 		cpuCycles += device.Step()
 	}
 	return cpuCycles
 }
 
 func (device *Device) StepSeconds(seconds float64) {
-	cycles := int(CPUFrequency * seconds)
-	for cycles > 0 {
+	cycles := int(device.region.CPUFrequency() * seconds)
+	for cycles > 0 && device.Err() == nil {
 		cycles -= device.Step()
 	}
 }
 
-func (device *Device) Buffer() *image.RGBA {
+// FrameBuffer returns the last complete frame as 9-bit color indices. It is
+// overwritten two frames later.
+func (device *Device) FrameBuffer() *FrameBuffer {
 	return device.PPU.front
 }
 
+// FramePhase returns the NTSC color subcarrier phase, in twelfths of a
+// cycle, at the first dot of the frame returned by FrameBuffer. It moves by
+// 4 on frames of 341*262 dots and by 8 on the odd frames rendering shortens
+// by a dot, which is what makes the dot crawl pattern.
+func (device *Device) FramePhase() int {
+	return int(device.PPU.frontPhase)
+}
+
+// Buffer returns the last complete frame converted to RGBA. The image is
+// reused by the next call.
+func (device *Device) Buffer() *image.RGBA {
+	device.screen = device.PPU.front.RGBA(device.palette, device.screen)
+	return device.screen
+}
+
 func (device *Device) BackgroundColor() color.RGBA {
-	return pallete.Palette[device.PPU.readPalette(0)%64]
+	return device.palette[device.PPU.colorIndex(0)]
+}
+
+// SetUnlimitedSprites lifts the 8 sprites per line the PPU can draw, which
+// removes the flicker games use to show more. Sprite overflow and
+// evaluation timing still behave as on the hardware. The setting is not
+// part of saved states.
+func (device *Device) SetUnlimitedSprites(unlimited bool) {
+	device.PPU.unlimitedSprites = unlimited
+}
+
+func (device *Device) UnlimitedSprites() bool {
+	return device.PPU.unlimitedSprites
+}
+
+// SetPalette selects the colors Buffer and BackgroundColor use.
+func (device *Device) SetPalette(palette *pallete.Table) {
+	device.palette = palette
+}
+
+func (device *Device) Palette() *pallete.Table {
+	return device.palette
 }
 
 func (device *Device) SetButtons1(buttons [8]bool) {
@@ -102,8 +238,9 @@ func (device *Device) SetAudioChannel(channel chan float32) {
 }
 
 func (device *Device) SetAudioSampleRate(sampleRate float64) {
+	device.audioSampleRate = sampleRate
 	if sampleRate != 0 {
-		device.APU.sampleRate = CPUFrequency / sampleRate
+		device.APU.sampleRate = device.region.CPUFrequency() / sampleRate
 		device.APU.filterChain = FilterChain{
 			HighPassFilter(float32(sampleRate), 90),
 			HighPassFilter(float32(sampleRate), 440),
@@ -134,6 +271,8 @@ func (device *Device) Save(encoder *gob.Encoder) error {
 	device.PPU.Save(encoder)
 	device.Cartridge.Save(encoder)
 	device.Mapper.Save(encoder)
+	encoder.Encode(device.region)
+	encoder.Encode(device.dots)
 	return encoder.Encode(true)
 }
 
@@ -154,6 +293,12 @@ func (device *Device) Load(decoder *gob.Decoder) error {
 	device.PPU.Load(decoder)
 	device.Cartridge.Load(decoder)
 	device.Mapper.Load(decoder)
+	var region Region
+	decoder.Decode(&region)
+	// SetRegion restores the timings derived from the region, then the
+	// clock ratio remainder it clears
+	device.SetRegion(region)
+	decoder.Decode(&device.dots)
 	var dummy bool
 	if err := decoder.Decode(&dummy); err != nil {
 		return err
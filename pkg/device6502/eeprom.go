@@ -0,0 +1,154 @@
+package device6502
+
+import "encoding/gob"
+
+// I2C phases of the serial EEPROM
+const (
+	eepromIdle = iota
+	eepromChipAddress
+	eepromWordAddress
+	eepromWrite
+	eepromRead
+)
+
+// eeprom24C02 is the 256-byte I2C serial EEPROM on Bandai boards. The game
+// drives the clock (SCL) and data (SDA) lines by hand; the chip samples SDA
+// on rising clock edges and changes its own output while the clock is low.
+type eeprom24C02 struct {
+	data    [256]byte
+	mode    int
+	next    int  // mode after the acknowledge
+	bit     int  // clock pulses of the current byte, the ninth being the acknowledge
+	shift   byte // byte being received
+	address byte
+	output  byte // what the chip drives on SDA, 1 when released
+	scl     byte
+	sda     byte
+}
+
+func newEEPROM24C02() *eeprom24C02 {
+	return &eeprom24C02{output: 1, scl: 1, sda: 1}
+}
+
+func (e *eeprom24C02) Save(encoder *gob.Encoder) error {
+	encoder.Encode(e.data)
+	encoder.Encode(e.mode)
+	encoder.Encode(e.next)
+	encoder.Encode(e.bit)
+	encoder.Encode(e.shift)
+	encoder.Encode(e.address)
+	encoder.Encode(e.output)
+	encoder.Encode(e.scl)
+	encoder.Encode(e.sda)
+	return nil
+}
+
+func (e *eeprom24C02) Load(decoder *gob.Decoder) error {
+	decoder.Decode(&e.data)
+	decoder.Decode(&e.mode)
+	decoder.Decode(&e.next)
+	decoder.Decode(&e.bit)
+	decoder.Decode(&e.shift)
+	decoder.Decode(&e.address)
+	decoder.Decode(&e.output)
+	decoder.Decode(&e.scl)
+	decoder.Decode(&e.sda)
+	return nil
+}
+
+// read returns the level of SDA as the chip drives it.
+func (e *eeprom24C02) read() byte {
+	return e.output
+}
+
+// write sets the levels of SCL and SDA.
+func (e *eeprom24C02) write(scl, sda byte) {
+	switch {
+	case e.scl == 1 && scl == 1 && e.sda == 1 && sda == 0:
+		// start, or a repeated start
+		e.mode = eepromChipAddress
+		e.bit = 0
+		e.output = 1
+	case e.scl == 1 && scl == 1 && e.sda == 0 && sda == 1:
+		// stop
+		e.mode = eepromIdle
+		e.output = 1
+	case e.scl == 0 && scl == 1:
+		e.rise(sda)
+	case e.scl == 1 && scl == 0:
+		e.fall()
+	}
+	e.scl, e.sda = scl, sda
+}
+
+func (e *eeprom24C02) rise(sda byte) {
+	switch e.mode {
+	case eepromIdle:
+		return
+	case eepromRead:
+		if e.bit == 8 && sda == 1 {
+			// no acknowledge from the game: the read is over
+			e.mode = eepromIdle
+			return
+		}
+	default:
+		if e.bit < 8 {
+			e.shift = e.shift<<1 | sda
+		}
+	}
+	e.bit++
+}
+
+func (e *eeprom24C02) fall() {
+	switch e.mode {
+	case eepromIdle:
+		return
+	case eepromRead:
+		if e.bit == 9 {
+			e.address++
+			e.bit = 0
+		}
+		e.output = 1
+		if e.bit < 8 {
+			e.output = e.data[e.address] >> (7 - e.bit) & 1
+		}
+		return
+	}
+	switch e.bit {
+	case 8:
+		e.output = 0
+		e.receive()
+	case 9:
+		e.output = 1
+		e.bit = 0
+		e.mode = e.next
+		if e.mode == eepromRead {
+			e.output = e.data[e.address] >> 7
+		}
+	}
+}
+
+// receive acts on a byte received in full, before acknowledging it.
+func (e *eeprom24C02) receive() {
+	switch e.mode {
+	case eepromChipAddress:
+		switch {
+		case e.shift&0xF0 != 0xA0:
+			// addressed to another device
+			e.mode = eepromIdle
+			e.output = 1
+		case e.shift&1 == 1:
+			e.next = eepromRead
+		default:
+			e.next = eepromWordAddress
+		}
+	case eepromWordAddress:
+		e.address = e.shift
+		e.next = eepromWrite
+	case eepromWrite:
+		e.data[e.address] = e.shift
+		e.next = eepromWrite
+		// writes wrap within an 8-byte page
+		e.address = e.address&^7 | (e.address+1)&7
+	}
+}
@@ -0,0 +1,44 @@
+package device6502
+
+import (
+	"image"
+
+	"github.com/se-nonide/go6502/pkg/pallete"
+)
+
+const (
+	ScreenWidth  = 256
+	ScreenHeight = 240
+)
+
+// FrameBuffer holds one frame of raw PPU output: for every pixel, row by
+// row, the 9-bit color index made of the 6-bit palette color and the three
+// PPUMASK emphasis bits. See pallete.Emphasized.
+type FrameBuffer [ScreenWidth * ScreenHeight]uint16
+
+func (frame *FrameBuffer) At(x, y int) uint16 {
+	return frame[y*ScreenWidth+x]
+}
+
+func (frame *FrameBuffer) Set(x, y int, index uint16) {
+	frame[y*ScreenWidth+x] = index
+}
+
+// RGBA converts the frame through a palette into dst, allocating
+// it when nil, and returns it.
+func (frame *FrameBuffer) RGBA(palette *pallete.Table, dst *image.RGBA) *image.RGBA {
+	if dst == nil {
+		dst = image.NewRGBA(image.Rect(0, 0, ScreenWidth, ScreenHeight))
+	}
+	for y := 0; y < ScreenHeight; y++ {
+		row := dst.Pix[y*dst.Stride:]
+		for x, index := range frame[y*ScreenWidth : (y+1)*ScreenWidth] {
+			c := palette[index&0x1FF]
+			row[x*4+0] = c.R
+			row[x*4+1] = c.G
+			row[x*4+2] = c.B
+			row[x*4+3] = c.A
+		}
+	}
+	return dst
+}
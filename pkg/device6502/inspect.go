@@ -0,0 +1,45 @@
+package device6502
+
+// Read-only views of PPU state for debugging tools.
+
+// OAM returns a copy of sprite memory.
+func (device *Device) OAM() [256]byte {
+	return device.PPU.oamData
+}
+
+// PaletteRAM returns a copy of the 32 bytes of palette memory, with the
+// sprite backdrop entries mirrored from the background ones.
+func (device *Device) PaletteRAM() [32]byte {
+	var ram [32]byte
+	for i := range ram {
+		ram[i] = device.PPU.readPalette(uint16(i))
+	}
+	return ram
+}
+
+// Scroll returns the top-left corner of the picture in the 512x480 space
+// of the four nametables, from the t register and fine X the next frame
+// starts from.
+func (device *Device) Scroll() (x, y int) {
+	t := int(device.PPU.t)
+	table := (t >> 10) & 3
+	x = (table&1)*256 + (t&0x1F)*8 + int(device.PPU.x)
+	y = (table>>1)*240 + (t>>5&0x1F)*8 + (t>>12)&7
+	return x, y
+}
+
+// BackgroundTable and SpriteTable return the pattern table address PPUCTRL
+// selects, $0000 or $1000. Sprites 16 pixels high take their table from
+// the tile number instead.
+func (device *Device) BackgroundTable() uint16 {
+	return 0x1000 * uint16(device.PPU.flagBackgroundTable)
+}
+
+func (device *Device) SpriteTable() uint16 {
+	return 0x1000 * uint16(device.PPU.flagSpriteTable)
+}
+
+// SpriteHeight returns 8 or 16.
+func (device *Device) SpriteHeight() int {
+	return device.PPU.spriteHeight()
+}
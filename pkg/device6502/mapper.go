@@ -14,6 +14,14 @@ type Mapper interface {
 	Load(decoder *gob.Decoder) error
 }
 
+// CycleMapper is implemented by mappers with hardware clocked by the CPU
+// rather than the PPU, such as IRQ timers counting M2 cycles. StepCPU runs
+// once per CPU cycle, after the PPU dots of that cycle.
+type CycleMapper interface {
+	Mapper
+	StepCPU()
+}
+
 func NewMapper(device *Device) (Mapper, error) {
 	cartridge := device.Cartridge
 	switch cartridge.Mapper {
@@ -35,6 +43,9 @@ func NewMapper(device *Device) (Mapper, error) {
 	case 7:
 		log.Print("Mapper 7")
 		return NewMapper7(cartridge), nil
+	case 16:
+		log.Print("Mapper 16")
+		return NewMapper16(device, cartridge), nil
 	case 40:
 		log.Print("Mapper 40")
 		return NewMapper40(device, cartridge), nil
@@ -69,7 +69,7 @@ func (m *Mapper1) Read(address uint16) byte {
 		offset := address % 0x4000
 		return m.PRG[m.prgOffsets[bank]+int(offset)]
 	case address >= 0x6000:
-		return m.SRAM[int(address)-0x6000]
+		return m.ReadSRAM(address)
 	default:
 		log.Fatalf("unhandled mapper1 read at address: 0x%04X", address)
 	}
@@ -85,7 +85,7 @@ func (m *Mapper1) Write(address uint16, value byte) {
 	case address >= 0x8000:
 		m.loadRegister(address, value)
 	case address >= 0x6000:
-		m.SRAM[int(address)-0x6000] = value
+		m.WriteSRAM(address, value)
 	default:
 		log.Fatalf("unhandled mapper1 write at address: 0x%04X", address)
 	}
@@ -0,0 +1,169 @@
+package device6502
+
+import (
+	"encoding/gob"
+	"log"
+
+	"github.com/se-nonide/go6502/pkg/cartridge"
+)
+
+// Mapper16 is Bandai's FCG board. The FCG-1 and FCG-2 chips (submapper 4)
+// take their registers at $6000-$7FFF; the LZ93D50 (submapper 5) takes them
+// at $8000-$FFFF and adds a 24C02 serial EEPROM for saves, read back through
+// bit 4 of $6000-$7FFF. Headers without a submapper get both register
+// ranges, and the EEPROM when they have the battery bit.
+type Mapper16 struct {
+	*cartridge.Cartridge
+	device     *Device
+	eeprom     *eeprom24C02 // nil on boards without one
+	chrBanks   [8]byte
+	prgBank    byte
+	irqEnable  bool
+	irqLatch   uint16
+	irqCounter uint16
+}
+
+func NewMapper16(device *Device, cartridge *cartridge.Cartridge) Mapper {
+	m := Mapper16{Cartridge: cartridge, device: device}
+	if cartridge.Submapper == 5 || cartridge.Submapper != 4 && cartridge.Battery == 1 {
+		m.eeprom = newEEPROM24C02()
+	}
+	// the board has no PRG-RAM, saving to the EEPROM if anything
+	cartridge.SetRAMSizes(0, 0, cartridge.CHRRAMSize, cartridge.CHRNVRAMSize)
+	return &m
+}
+
+// NonVolatile returns the EEPROM's contents, nil on boards without one.
+func (m *Mapper16) NonVolatile() []byte {
+	if m.eeprom == nil {
+		return nil
+	}
+	return m.eeprom.data[:]
+}
+
+func (m *Mapper16) Save(encoder *gob.Encoder) error {
+	encoder.Encode(m.chrBanks)
+	encoder.Encode(m.prgBank)
+	encoder.Encode(m.irqEnable)
+	encoder.Encode(m.irqLatch)
+	encoder.Encode(m.irqCounter)
+	if m.eeprom != nil {
+		m.eeprom.Save(encoder)
+	}
+	return nil
+}
+
+func (m *Mapper16) Load(decoder *gob.Decoder) error {
+	decoder.Decode(&m.chrBanks)
+	decoder.Decode(&m.prgBank)
+	decoder.Decode(&m.irqEnable)
+	decoder.Decode(&m.irqLatch)
+	decoder.Decode(&m.irqCounter)
+	if m.eeprom != nil {
+		m.eeprom.Load(decoder)
+	}
+	return nil
+}
+
+func (m *Mapper16) Step() {
+}
+
+// StepCPU counts the IRQ timer down. The counter is checked before it is
+// decremented, as games rely on.
+func (m *Mapper16) StepCPU() {
+	if !m.irqEnable {
+		return
+	}
+	if m.irqCounter == 0 {
+		m.device.CPU.AssertIRQ(IRQMapper)
+	}
+	m.irqCounter--
+}
+
+func (m *Mapper16) Read(address uint16) byte {
+	switch {
+	case address < 0x2000:
+		bank := int(m.chrBanks[address/0x0400]) % (len(m.CHR) / 0x0400)
+		return m.CHR[bank*0x0400+int(address%0x0400)]
+	case address >= 0xC000:
+		return m.PRG[len(m.PRG)-0x4000+int(address-0xC000)]
+	case address >= 0x8000:
+		bank := int(m.prgBank) % (len(m.PRG) / 0x4000)
+		return m.PRG[bank*0x4000+int(address-0x8000)]
+	case address >= 0x6000:
+		// open bus, but for the EEPROM's data line
+		value := byte(address>>8) &^ 0x10
+		if m.eeprom != nil {
+			value |= m.eeprom.read() << 4
+		}
+		return value
+	default:
+		log.Fatalf("unhandled mapper16 read at address: 0x%04X", address)
+	}
+	return 0
+}
+
+func (m *Mapper16) Write(address uint16, value byte) {
+	switch {
+	case address < 0x2000:
+		bank := int(m.chrBanks[address/0x0400]) % (len(m.CHR) / 0x0400)
+		m.CHR[bank*0x0400+int(address%0x0400)] = value
+	case address >= 0x8000:
+		if m.Submapper != 4 {
+			m.writeRegister(address, value)
+		}
+	case address >= 0x6000:
+		if m.Submapper != 5 {
+			m.writeRegister(address, value)
+		}
+	default:
+		log.Fatalf("unhandled mapper16 write at address: 0x%04X", address)
+	}
+}
+
+func (m *Mapper16) writeRegister(address uint16, value byte) {
+	switch register := address & 0x0F; {
+	case register < 8:
+		m.chrBanks[register] = value
+	case register == 8:
+		m.prgBank = value & 0x0F
+	case register == 9:
+		m.writeMirror(value)
+	case register == 0xA:
+		m.irqEnable = value&1 == 1
+		if m.Submapper != 4 {
+			// the LZ93D50 loads the counter from the latch
+			m.irqCounter = m.irqLatch
+		}
+		m.device.CPU.AcknowledgeIRQ(IRQMapper)
+	case register == 0xB:
+		m.writeIRQ(m.irqLatch&0xFF00 | uint16(value))
+	case register == 0xC:
+		m.writeIRQ(m.irqLatch&0x00FF | uint16(value)<<8)
+	case register == 0xD:
+		if m.eeprom != nil {
+			m.eeprom.write(value>>5&1, value>>6&1)
+		}
+	}
+}
+
+// writeIRQ sets the latch, which on FCG chips is the counter itself.
+func (m *Mapper16) writeIRQ(value uint16) {
+	m.irqLatch = value
+	if m.Submapper == 4 {
+		m.irqCounter = value
+	}
+}
+
+func (m *Mapper16) writeMirror(value byte) {
+	switch value & 3 {
+	case 0:
+		m.Cartridge.Mirror = MirrorVertical
+	case 1:
+		m.Cartridge.Mirror = MirrorHorizontal
+	case 2:
+		m.Cartridge.Mirror = MirrorSingle0
+	case 3:
+		m.Cartridge.Mirror = MirrorSingle1
+	}
+}
@@ -0,0 +1,142 @@
+package device6502
+
+import "testing"
+
+// i2cMaster bit-bangs the EEPROM lines through $800D the way games do.
+type i2cMaster struct {
+	t      *testing.T
+	mapper *Mapper16
+}
+
+func (bus *i2cMaster) set(scl, sda byte) {
+	bus.mapper.Write(0x800D, scl<<5|sda<<6)
+}
+
+func (bus *i2cMaster) sda() byte {
+	return bus.mapper.Read(0x6000) >> 4 & 1
+}
+
+func (bus *i2cMaster) start() {
+	bus.set(1, 1)
+	bus.set(1, 0)
+	bus.set(0, 0)
+}
+
+func (bus *i2cMaster) stop() {
+	bus.set(0, 0)
+	bus.set(1, 0)
+	bus.set(1, 1)
+}
+
+// send clocks a byte out and returns whether the EEPROM acknowledged it.
+func (bus *i2cMaster) send(value byte) bool {
+	for i := 7; i >= 0; i-- {
+		bit := value >> i & 1
+		bus.set(0, bit)
+		bus.set(1, bit)
+		bus.set(0, bit)
+	}
+	bus.set(0, 1)
+	bus.set(1, 1)
+	ack := bus.sda() == 0
+	bus.set(0, 1)
+	return ack
+}
+
+// receive clocks a byte in, acknowledging it when more are wanted.
+func (bus *i2cMaster) receive(more bool) byte {
+	var value byte
+	for i := 0; i < 8; i++ {
+		bus.set(0, 1)
+		bus.set(1, 1)
+		value = value<<1 | bus.sda()
+		bus.set(0, 1)
+	}
+	var ack byte = 1
+	if more {
+		ack = 0
+	}
+	bus.set(0, ack)
+	bus.set(1, ack)
+	bus.set(0, ack)
+	return value
+}
+
+func (bus *i2cMaster) sendAll(values ...byte) {
+	bus.t.Helper()
+	for _, value := range values {
+		if !bus.send(value) {
+			bus.t.Fatalf("$%02X not acknowledged", value)
+		}
+	}
+}
+
+func TestMapper16EEPROM(t *testing.T) {
+	// mapper 16 with the battery bit
+	device := newTestDevice(t, nil, 0x02, 0x10)
+	mapper := device.Mapper.(*Mapper16)
+	bus := &i2cMaster{t, mapper}
+
+	// a page write wraps from $07 to $00
+	bus.start()
+	bus.sendAll(0xA0, 0x06, 0x11, 0x22, 0x33)
+	bus.stop()
+	data := mapper.NonVolatile()
+	if data[6] != 0x11 || data[7] != 0x22 || data[0] != 0x33 {
+		t.Errorf("EEPROM holds % X", data[:8])
+	}
+
+	// random read: set the address, then read from it after a repeated start
+	bus.start()
+	bus.sendAll(0xA0, 0x06)
+	bus.start()
+	bus.sendAll(0xA1)
+	if first, second := bus.receive(true), bus.receive(false); first != 0x11 || second != 0x22 {
+		t.Errorf("read $%02X $%02X, want $11 $22", first, second)
+	}
+	bus.stop()
+
+	bus.start()
+	if bus.send(0xB0) {
+		t.Error("EEPROM acknowledged another device's address")
+	}
+	bus.stop()
+
+	saved := device.BatteryRAM()
+	if len(saved) != 256 || saved[6] != 0x11 {
+		t.Fatalf("battery RAM is %d bytes", len(saved))
+	}
+	saved[0x80] = 0x44
+	if !device.SetBatteryRAM(saved) || data[0x80] != 0x44 {
+		t.Error("SetBatteryRAM did not restore the EEPROM")
+	}
+
+	if NewBatterySaver(newTestDevice(t, nil, 0x00, 0x10), "unused.sav") != nil {
+		t.Error("saver for a board without an EEPROM")
+	}
+}
+
+func TestMapper16IRQ(t *testing.T) {
+	// the timer counts CPU cycles, which PAL spreads over 3.2 dots
+	for _, region := range []Region{RegionNTSC, RegionPAL} {
+		device := newTestDevice(t, nil, 0x00, 0x10)
+		device.SetRegion(region)
+		mapper := device.Mapper.(*Mapper16)
+		mapper.Write(0x800B, 2)
+		mapper.Write(0x800C, 0)
+		mapper.Write(0x800A, 1)
+		cycles := 0
+		for !device.CPU.IRQAsserted(IRQMapper) && cycles < 100 {
+			device.tick()
+			cycles++
+		}
+		// two cycles to count down, the IRQ on the third
+		if cycles != 3 {
+			t.Errorf("%v: IRQ after %d CPU cycles, want 3", region, cycles)
+		}
+		mapper.Write(0x800A, 0)
+		if device.CPU.IRQAsserted(IRQMapper) {
+			t.Errorf("%v: IRQ still asserted after writing $800A", region)
+		}
+	}
+}
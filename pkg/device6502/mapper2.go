@@ -49,8 +49,7 @@ func (m *Mapper2) Read(address uint16) byte {
 		index := m.prgBank1*0x4000 + int(address-0x8000)
 		return m.PRG[index]
 	case address >= 0x6000:
-		index := int(address) - 0x6000
-		return m.SRAM[index]
+		return m.ReadSRAM(address)
 	default:
 		log.Fatalf("unhandled mapper2 read at address: 0x%04X", address)
 	}
@@ -64,8 +63,7 @@ func (m *Mapper2) Write(address uint16, value byte) {
 	case address >= 0x8000:
 		m.prgBank1 = int(value) % m.prgBanks
 	case address >= 0x6000:
-		index := int(address) - 0x6000
-		m.SRAM[index] = value
+		m.WriteSRAM(address, value)
 	default:
 		log.Fatalf("unhandled mapper2 write at address: 0x%04X", address)
 	}
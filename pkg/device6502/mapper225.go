@@ -48,8 +48,7 @@ func (m *Mapper225) Read(address uint16) byte {
 		index := m.prgBank1*0x4000 + int(address-0x8000)
 		return m.PRG[index]
 	case address >= 0x6000:
-		index := int(address) - 0x6000
-		return m.SRAM[index]
+		return m.ReadSRAM(address)
 	default:
 		log.Fatalf("unhandled Mapper225 read at address: 0x%04X", address)
 	}
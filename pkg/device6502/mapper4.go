@@ -76,7 +76,7 @@ func (m *Mapper4) HandleScanLine() {
 	} else {
 		m.counter--
 		if m.counter == 0 && m.irqEnable {
-			m.device.CPU.triggerIRQ()
+			m.device.CPU.AssertIRQ(IRQMapper)
 		}
 	}
 }
@@ -93,7 +93,7 @@ func (m *Mapper4) Read(address uint16) byte {
 		offset := address % 0x2000
 		return m.PRG[m.prgOffsets[bank]+int(offset)]
 	case address >= 0x6000:
-		return m.SRAM[int(address)-0x6000]
+		return m.ReadSRAM(address)
 	default:
 		log.Fatalf("unhandled mapper4 read at address: 0x%04X", address)
 	}
@@ -109,7 +109,7 @@ func (m *Mapper4) Write(address uint16, value byte) {
 	case address >= 0x8000:
 		m.writeRegister(address, value)
 	case address >= 0x6000:
-		m.SRAM[int(address)-0x6000] = value
+		m.WriteSRAM(address, value)
 	default:
 		log.Fatalf("unhandled mapper4 write at address: 0x%04X", address)
 	}
@@ -149,6 +149,10 @@ func (m *Mapper4) writeBankData(value byte) {
 }
 
 func (m *Mapper4) writeMirror(value byte) {
+	if m.Cartridge.Mirror == MirrorFour {
+		// hardwired on four-screen boards
+		return
+	}
 	switch value & 1 {
 	case 0:
 		m.Cartridge.Mirror = MirrorVertical
@@ -170,6 +174,7 @@ func (m *Mapper4) writeIRQReload(value byte) {
 
 func (m *Mapper4) writeIRQDisable(value byte) {
 	m.irqEnable = false
+	m.device.CPU.AcknowledgeIRQ(IRQMapper)
 }
 
 func (m *Mapper4) writeIRQEnable(value byte) {
@@ -38,7 +38,7 @@ func (m *Mapper40) Step() {
 	m.cycles++
 	if m.cycles%(4096*3) == 0 {
 		m.cycles = 0
-		m.device.CPU.triggerIRQ()
+		m.device.CPU.AssertIRQ(IRQMapper)
 	}
 }
 
@@ -68,6 +68,7 @@ func (m *Mapper40) Write(address uint16, value byte) {
 		m.CHR[address] = value
 	case address >= 0x8000 && address < 0xa000:
 		m.cycles = -1
+		m.device.CPU.AcknowledgeIRQ(IRQMapper)
 	case address >= 0xa000 && address < 0xc000:
 		m.cycles = 0
 	case address >= 0xe000:
@@ -37,8 +37,7 @@ func (m *Mapper7) Read(address uint16) byte {
 		index := m.prgBank*0x8000 + int(address-0x8000)
 		return m.PRG[index]
 	case address >= 0x6000:
-		index := int(address) - 0x6000
-		return m.SRAM[index]
+		return m.ReadSRAM(address)
 	default:
 		log.Fatalf("unhandled mapper7 read at address: 0x%04X", address)
 	}
@@ -58,8 +57,7 @@ func (m *Mapper7) Write(address uint16, value byte) {
 			m.Cartridge.Mirror = MirrorSingle1
 		}
 	case address >= 0x6000:
-		index := int(address) - 0x6000
-		m.SRAM[index] = value
+		m.WriteSRAM(address, value)
 	default:
 		log.Fatalf("unhandled mapper7 write at address: 0x%04X", address)
 	}
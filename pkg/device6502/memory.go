@@ -7,6 +7,22 @@ type Memory interface {
 	Write(address uint16, value byte)
 }
 
+// AddressSpace identifies the bus a memory access was made on.
+type AddressSpace byte
+
+const (
+	SpaceCPU AddressSpace = iota
+	SpacePPU
+)
+
+// AccessHook observes every access the emulated hardware makes on the CPU
+// and PPU buses. Peeks made by tools are not reported.
+type AccessHook func(space AddressSpace, address uint16, value byte, write bool)
+
+func (device *Device) SetAccessHook(hook AccessHook) {
+	device.accessHook = hook
+}
+
 type cpuMemory struct {
 	device *Device
 }
@@ -16,6 +32,14 @@ func NewCPUMemory(device *Device) Memory {
 }
 
 func (mem *cpuMemory) Read(address uint16) byte {
+	value := mem.read(address)
+	if hook := mem.device.accessHook; hook != nil {
+		hook(SpaceCPU, address, value, false)
+	}
+	return value
+}
+
+func (mem *cpuMemory) read(address uint16) byte {
 	switch {
 	case address < 0x2000:
 		return mem.device.RAM[address%0x0800]
@@ -38,7 +62,34 @@ func (mem *cpuMemory) Read(address uint16) byte {
 	return 0
 }
 
+// Peek reads CPU memory without side effects: the PPU registers, APU status
+// and controller ports are returned without clearing, incrementing,
+// acknowledging or shifting anything. Everything else reads as the CPU
+// sees it, including 0 from the write-only and unmapped addresses of
+// $4000-$5FFF.
+func (device *Device) Peek(address uint16) byte {
+	switch {
+	case address < 0x2000:
+		return device.RAM[address%0x0800]
+	case address < 0x4000:
+		return device.PPU.peekRegister(0x2000 + address%8)
+	case address == 0x4015:
+		return device.APU.peekStatus()
+	case address == 0x4016:
+		return device.Controller1.Peek()
+	case address == 0x4017:
+		return device.Controller2.Peek()
+	case address < 0x6000:
+		return 0
+	default:
+		return device.Mapper.Read(address)
+	}
+}
+
 func (mem *cpuMemory) Write(address uint16, value byte) {
+	if hook := mem.device.accessHook; hook != nil {
+		hook(SpaceCPU, address, value, true)
+	}
 	switch {
 	case address < 0x2000:
 		mem.device.RAM[address%0x0800] = value
@@ -63,6 +114,24 @@ func (mem *cpuMemory) Write(address uint16, value byte) {
 	}
 }
 
+// clockedMemory advances the rest of the machine by one CPU cycle before
+// every access. Since the 6502 reads or writes the bus on every cycle, this
+// is what makes the CPU cycle-stepped.
+type clockedMemory struct {
+	Memory
+	tick func()
+}
+
+func (mem *clockedMemory) Read(address uint16) byte {
+	mem.tick()
+	return mem.Memory.Read(address)
+}
+
+func (mem *clockedMemory) Write(address uint16, value byte) {
+	mem.tick()
+	mem.Memory.Write(address, value)
+}
+
 type ppuMemory struct {
 	device *Device
 }
@@ -72,13 +141,29 @@ func NewPPUMemory(device *Device) Memory {
 }
 
 func (mem *ppuMemory) Read(address uint16) byte {
+	value := mem.read(address)
+	if hook := mem.device.accessHook; hook != nil {
+		hook(SpacePPU, address%0x4000, value, false)
+	}
+	return value
+}
+
+// PeekPPU reads a byte from the PPU address space without side effects.
+func (device *Device) PeekPPU(address uint16) byte {
+	mem := ppuMemory{device}
+	return mem.read(address)
+}
+
+func (mem *ppuMemory) read(address uint16) byte {
 	address = address % 0x4000
 	switch {
 	case address < 0x2000:
 		return mem.device.Mapper.Read(address)
 	case address < 0x3F00:
-		mode := mem.device.Cartridge.Mirror
-		return mem.device.PPU.nameTableData[MirrorAddress(mode, address)%2048]
+		if m, ok := mem.device.Mapper.(NameTableMapper); ok {
+			return m.ReadNameTable(0x2000 + address%0x1000)
+		}
+		return *mem.device.nameTable(address)
 	case address < 0x4000:
 		return mem.device.PPU.readPalette(address % 32)
 	default:
@@ -89,12 +174,18 @@ func (mem *ppuMemory) Read(address uint16) byte {
 
 func (mem *ppuMemory) Write(address uint16, value byte) {
 	address = address % 0x4000
+	if hook := mem.device.accessHook; hook != nil {
+		hook(SpacePPU, address, value, true)
+	}
 	switch {
 	case address < 0x2000:
 		mem.device.Mapper.Write(address, value)
 	case address < 0x3F00:
-		mode := mem.device.Cartridge.Mirror
-		mem.device.PPU.nameTableData[MirrorAddress(mode, address)%2048] = value
+		if m, ok := mem.device.Mapper.(NameTableMapper); ok {
+			m.WriteNameTable(0x2000+address%0x1000, value)
+			return
+		}
+		*mem.device.nameTable(address) = value
 	case address < 0x4000:
 		mem.device.PPU.writePalette(address%32, value)
 	default:
@@ -102,6 +193,25 @@ func (mem *ppuMemory) Write(address uint16, value byte) {
 	}
 }
 
+// NameTableMapper is implemented by mappers that decode $2000-$2FFF
+// themselves, like MMC5 and Namco 163, instead of leaving it to the
+// cartridge's mirroring. $3000-$3EFF reaches them folded to $2000.
+type NameTableMapper interface {
+	ReadNameTable(address uint16) byte
+	WriteNameTable(address uint16, value byte)
+}
+
+// nameTable returns the byte a nametable address selects through the
+// cartridge's mirroring: the console's 2KB, or with four-screen mirroring
+// the board's own RAM for the last two tables.
+func (device *Device) nameTable(address uint16) *byte {
+	index := MirrorAddress(device.Cartridge.Mirror, address) - 0x2000
+	if vram := device.Cartridge.VRAM; index >= 0x800 && len(vram) > 0 {
+		return &vram[int(index-0x800)%len(vram)]
+	}
+	return &device.PPU.nameTableData[index%2048]
+}
+
 const (
 	MirrorHorizontal = 0
 	MirrorVertical   = 1
@@ -0,0 +1,80 @@
+package device6502
+
+import "testing"
+
+func TestFourScreenNameTables(t *testing.T) {
+	device := newTestDevice(t, nil, 0x08)
+	if device.Cartridge.Mirror != MirrorFour || len(device.Cartridge.VRAM) != 0x800 {
+		t.Fatalf("mirror %d with %d bytes of VRAM", device.Cartridge.Mirror, len(device.Cartridge.VRAM))
+	}
+	memory := device.PPU.Memory
+	for table := uint16(0); table < 4; table++ {
+		memory.Write(0x2000+table*0x400, byte(table+1))
+	}
+	for table := uint16(0); table < 4; table++ {
+		if value := memory.Read(0x2000 + table*0x400); value != byte(table+1) {
+			t.Errorf("table %d read %d, want %d", table, value, table+1)
+		}
+		if value := memory.Read(0x3000 + table*0x400); value != byte(table+1) {
+			t.Errorf("mirror of table %d at $3xxx read %d, want %d", table, value, table+1)
+		}
+	}
+	if device.Cartridge.VRAM[0x400] != 4 {
+		t.Error("the fourth table is not in the cartridge VRAM")
+	}
+}
+
+// nameTableMapper maps a single nametable of its own everywhere.
+type nameTableMapper struct {
+	Mapper
+	ram [0x400]byte
+}
+
+func (m *nameTableMapper) ReadNameTable(address uint16) byte {
+	return m.ram[address%0x400]
+}
+
+func (m *nameTableMapper) WriteNameTable(address uint16, value byte) {
+	m.ram[address%0x400] = value
+}
+
+func TestNameTableMapper(t *testing.T) {
+	device := newTestDevice(t, nil)
+	mapper := &nameTableMapper{Mapper: device.Mapper}
+	device.Mapper = mapper
+	device.PPU.Memory.Write(0x2C05, 0x42)
+	if mapper.ram[5] != 0x42 {
+		t.Error("write did not reach the mapper")
+	}
+	if value := device.PeekPPU(0x3405); value != 0x42 {
+		t.Errorf("read $%02X through the mapper, want $42", value)
+	}
+	for _, value := range device.PPU.nameTableData {
+		if value != 0 {
+			t.Fatal("the console's nametable RAM was written")
+		}
+	}
+}
+
+func TestPeekHasNoSideEffects(t *testing.T) {
+	device := newTestDevice(t, nil)
+	device.Controller1.SetButtons([8]bool{false, true})
+	device.CPU.AssertIRQ(IRQFrameCounter)
+	for i := 0; i < 2; i++ {
+		if value := device.Peek(0x4015); value&0x40 == 0 {
+			t.Fatalf("peek %d of $4015 = $%02X, want the frame IRQ flag", i, value)
+		}
+		if value := device.Peek(0x4016); value != 0 {
+			t.Fatalf("peek %d of $4016 = %d, want button A", i, value)
+		}
+	}
+	if !device.CPU.IRQAsserted(IRQFrameCounter) {
+		t.Error("peeking $4015 acknowledged the frame IRQ")
+	}
+	memory := NewCPUMemory(device)
+	for _, address := range []uint16{0x4000, 0x4014, 0x4018, 0x5123} {
+		if peeked, read := device.Peek(address), memory.Read(address); peeked != read {
+			t.Errorf("peek of $%04X = $%02X, but the CPU reads $%02X", address, peeked, read)
+		}
+	}
+}
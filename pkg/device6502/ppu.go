@@ -2,9 +2,6 @@ package device6502
 
 import (
 	"encoding/gob"
-	"image"
-
-	"github.com/se-nonide/go6502/pkg/pallete"
 )
 
 type PPU struct {
@@ -12,15 +9,20 @@ type PPU struct {
 	device *Device // reference to parent object
 
 	Cycle    int    // 0-340
-	ScanLine int    // 0-261, 0-239=visible, 240=post, 241-260=vblank, 261=pre
+	ScanLine int    // 0-239=visible, then post-render and vblank lines, last=pre
 	Frame    uint64 // frame counter
 
+	// region timing, see Device.SetRegion
+	scanLines  int  // 262 on NTSC, 312 on PAL and Dendy
+	vblankLine int  // 241, or 291 on Dendy
+	skipOddDot bool // NTSC only
+
 	// storage variables
 	paletteData   [32]byte
 	nameTableData [2048]byte
 	oamData       [256]byte
-	front         *image.RGBA
-	back          *image.RGBA
+	front         *FrameBuffer // last complete frame
+	back          *FrameBuffer // frame being rendered
 
 	// PPU registers
 	v uint16 // current vram address (15 bit)
@@ -29,7 +31,16 @@ type PPU struct {
 	w byte   // write toggle (1 bit)
 	f byte   // even/odd frame flag (1 bit)
 
-	register byte
+	// color subcarrier phase at the start of the frame being rendered and
+	// of the front buffer, in twelfths of a cycle
+	phase      byte
+	frontPhase byte
+
+	// open bus: the I/O latch between the CPU and the PPU, and the frame
+	// each of its bits was last driven in
+	register      byte
+	registerDrive [8]uint64
+	decayFrames   uint64 // about 600ms, see Device.SetRegion
 
 	// NMI flags
 	nmiOccurred bool
@@ -49,7 +60,20 @@ type PPU struct {
 	spritePatterns   [8]uint32
 	spritePositions  [8]byte
 	spritePriorities [8]byte
-	spriteIndexes    [8]byte
+	spriteZero       bool // the first sprite of the line is sprite 0
+	unlimitedSprites bool // draw more than 8 sprites per line, see Device
+	// sprites past the eighth, drawn only without the sprite limit. Like
+	// the setting they are not saved: a state loaded mid-line drops them.
+	extraSprites []extraSprite
+
+	// sprite evaluation, see sprites.go
+	secondaryOAM   [32]byte
+	secondaryIndex int
+	evalState      int
+	evalRemaining  int
+	spriteZeroNext bool
+	evalCopied     uint64 // sprites copied to secondary OAM, by number; not saved
+	oamBus         byte   // last value on the OAM bus, read by $2004 while rendering
 
 	// $2000 PPUCTRL
 	flagNameTable       byte // 0: $2000; 1: $2400; 2: $2800; 3: $2C00
@@ -82,8 +106,8 @@ type PPU struct {
 
 func NewPPU(device *Device) *PPU {
 	ppu := PPU{Memory: NewPPUMemory(device), device: device}
-	ppu.front = image.NewRGBA(image.Rect(0, 0, 256, 240))
-	ppu.back = image.NewRGBA(image.Rect(0, 0, 256, 240))
+	ppu.front = &FrameBuffer{}
+	ppu.back = &FrameBuffer{}
 	ppu.Reset()
 	return &ppu
 }
@@ -100,7 +124,10 @@ func (ppu *PPU) Save(encoder *gob.Encoder) error {
 	encoder.Encode(ppu.x)
 	encoder.Encode(ppu.w)
 	encoder.Encode(ppu.f)
+	encoder.Encode(ppu.phase)
+	encoder.Encode(ppu.frontPhase)
 	encoder.Encode(ppu.register)
+	encoder.Encode(ppu.registerDrive)
 	encoder.Encode(ppu.nmiOccurred)
 	encoder.Encode(ppu.nmiOutput)
 	encoder.Encode(ppu.nmiPrevious)
@@ -114,7 +141,13 @@ func (ppu *PPU) Save(encoder *gob.Encoder) error {
 	encoder.Encode(ppu.spritePatterns)
 	encoder.Encode(ppu.spritePositions)
 	encoder.Encode(ppu.spritePriorities)
-	encoder.Encode(ppu.spriteIndexes)
+	encoder.Encode(ppu.spriteZero)
+	encoder.Encode(ppu.secondaryOAM)
+	encoder.Encode(ppu.secondaryIndex)
+	encoder.Encode(ppu.evalState)
+	encoder.Encode(ppu.evalRemaining)
+	encoder.Encode(ppu.spriteZeroNext)
+	encoder.Encode(ppu.oamBus)
 	encoder.Encode(ppu.flagNameTable)
 	encoder.Encode(ppu.flagIncrement)
 	encoder.Encode(ppu.flagSpriteTable)
@@ -148,7 +181,10 @@ func (ppu *PPU) Load(decoder *gob.Decoder) error {
 	decoder.Decode(&ppu.x)
 	decoder.Decode(&ppu.w)
 	decoder.Decode(&ppu.f)
+	decoder.Decode(&ppu.phase)
+	decoder.Decode(&ppu.frontPhase)
 	decoder.Decode(&ppu.register)
+	decoder.Decode(&ppu.registerDrive)
 	decoder.Decode(&ppu.nmiOccurred)
 	decoder.Decode(&ppu.nmiOutput)
 	decoder.Decode(&ppu.nmiPrevious)
@@ -162,7 +198,14 @@ func (ppu *PPU) Load(decoder *gob.Decoder) error {
 	decoder.Decode(&ppu.spritePatterns)
 	decoder.Decode(&ppu.spritePositions)
 	decoder.Decode(&ppu.spritePriorities)
-	decoder.Decode(&ppu.spriteIndexes)
+	decoder.Decode(&ppu.spriteZero)
+	ppu.extraSprites = nil
+	decoder.Decode(&ppu.secondaryOAM)
+	decoder.Decode(&ppu.secondaryIndex)
+	decoder.Decode(&ppu.evalState)
+	decoder.Decode(&ppu.evalRemaining)
+	decoder.Decode(&ppu.spriteZeroNext)
+	decoder.Decode(&ppu.oamBus)
 	decoder.Decode(&ppu.flagNameTable)
 	decoder.Decode(&ppu.flagIncrement)
 	decoder.Decode(&ppu.flagSpriteTable)
@@ -204,6 +247,18 @@ func (ppu *PPU) readPalette(address uint16) byte {
 	return ppu.paletteData[address]
 }
 
+// colorIndex returns the 9-bit color the PPU outputs for a palette entry:
+// the 6-bit color, masked to the gray column in grayscale mode, with the
+// emphasis bits of PPUMASK above it.
+func (ppu *PPU) colorIndex(address byte) uint16 {
+	value := ppu.readPalette(uint16(address)) & 0x3F
+	if ppu.flagGrayscale != 0 {
+		value &= 0x30
+	}
+	emphasis := ppu.flagRedTint | ppu.flagGreenTint<<1 | ppu.flagBlueTint<<2
+	return uint16(emphasis)<<6 | uint16(value)
+}
+
 func (ppu *PPU) writePalette(address uint16, value byte) {
 	if address >= 16 && address%4 == 0 {
 		address -= 16
@@ -219,12 +274,57 @@ func (ppu *PPU) readRegister(address uint16) byte {
 		return ppu.readOAMData()
 	case 0x2007:
 		return ppu.readData()
+	case 0x4014:
+		return 0
 	}
-	return 0
+	// write-only registers read back whatever is left on the bus
+	return ppu.openBus()
+}
+
+// peekRegister returns what reading a register would, without the read's
+// side effects and without driving the I/O latch.
+func (ppu *PPU) peekRegister(address uint16) byte {
+	switch address {
+	case 0x2002:
+		return ppu.status() | ppu.openBus()&0x1F
+	case 0x2004:
+		return ppu.oamValue()
+	case 0x2007:
+		if ppu.v%0x4000 < 0x3F00 {
+			return ppu.bufferedData
+		}
+		return ppu.paletteValue()&0x3F | ppu.openBus()&0xC0
+	}
+	return ppu.openBus()
+}
+
+// drive puts the bits of value selected by mask on the I/O latch and
+// returns the latch, whose other bits are open bus.
+func (ppu *PPU) drive(value, mask byte) byte {
+	ppu.register = ppu.openBus()&^mask | value&mask
+	for i := uint(0); i < 8; i++ {
+		if mask&(1<<i) != 0 {
+			ppu.registerDrive[i] = ppu.Frame
+		}
+	}
+	return ppu.register
+}
+
+// openBus returns the I/O latch after letting every bit that has not been
+// driven for decayFrames fade to 0.
+func (ppu *PPU) openBus() byte {
+	for i := uint(0); i < 8; i++ {
+		if ppu.Frame > ppu.registerDrive[i]+ppu.decayFrames {
+			ppu.register &^= 1 << i
+		}
+	}
+	return ppu.register
 }
 
 func (ppu *PPU) writeRegister(address uint16, value byte) {
-	ppu.register = value
+	if address != 0x4014 {
+		ppu.drive(value, 0xFF)
+	}
 	switch address {
 	case 0x2000:
 		ppu.writeControl(value)
@@ -273,16 +373,23 @@ func (ppu *PPU) writeMask(value byte) {
 
 // $2002: PPUSTATUS
 func (ppu *PPU) readStatus() byte {
-	result := ppu.register & 0x1F
+	result := ppu.status()
+	ppu.nmiOccurred = false
+	ppu.nmiChange()
+	// w:                   = 0
+	ppu.w = 0
+	// the low 5 bits are open bus
+	return ppu.drive(result, 0xE0)
+}
+
+// status returns the flags in bits 5-7 of $2002.
+func (ppu *PPU) status() byte {
+	var result byte
 	result |= ppu.flagSpriteOverflow << 5
 	result |= ppu.flagSpriteZeroHit << 6
 	if ppu.nmiOccurred {
 		result |= 1 << 7
 	}
-	ppu.nmiOccurred = false
-	ppu.nmiChange()
-	// w:                   = 0
-	ppu.w = 0
 	return result
 }
 
@@ -291,8 +398,24 @@ func (ppu *PPU) writeOAMAddress(value byte) {
 	ppu.oamAddress = value
 }
 
+// rendering tells whether the PPU is fetching, which is when OAM belongs to
+// sprite evaluation rather than to $2003/$2004.
+func (ppu *PPU) rendering() bool {
+	enabled := ppu.flagShowBackground != 0 || ppu.flagShowSprites != 0
+	return enabled && (ppu.ScanLine < 240 || ppu.ScanLine == ppu.scanLines-1)
+}
+
 // $2004: OAMDATA (read)
 func (ppu *PPU) readOAMData() byte {
+	return ppu.drive(ppu.oamValue(), 0xFF)
+}
+
+// oamValue returns what $2004 reads: the sprite evaluation's OAM bus while
+// rendering, else the addressed byte with the unused attribute bits clear.
+func (ppu *PPU) oamValue() byte {
+	if ppu.rendering() {
+		return ppu.oamBus
+	}
 	data := ppu.oamData[ppu.oamAddress]
 	if (ppu.oamAddress & 0x03) == 0x02 {
 		data = data & 0xE3
@@ -302,10 +425,25 @@ func (ppu *PPU) readOAMData() byte {
 
 // $2004: OAMDATA (write)
 func (ppu *PPU) writeOAMData(value byte) {
+	if ppu.rendering() {
+		// no write, and a glitchy increment of the sprite number only
+		ppu.oamAddress += 4
+		return
+	}
 	ppu.oamData[ppu.oamAddress] = value
 	ppu.oamAddress++
 }
 
+// paletteValue returns the palette entry at v as $2007 reads it. Entries
+// are 6 bits wide, the top two come from the bus.
+func (ppu *PPU) paletteValue() byte {
+	value := ppu.readPalette(ppu.v % 32)
+	if ppu.flagGrayscale != 0 {
+		value &= 0x30
+	}
+	return value
+}
+
 // $2005: PPUSCROLL
 func (ppu *PPU) writeScroll(value byte) {
 	if ppu.w == 0 {
@@ -349,9 +487,10 @@ func (ppu *PPU) readData() byte {
 	if ppu.v%0x4000 < 0x3F00 {
 		buffered := ppu.bufferedData
 		ppu.bufferedData = value
-		value = buffered
+		value = ppu.drive(buffered, 0xFF)
 	} else {
 		ppu.bufferedData = ppu.Read(ppu.v - 0x1000)
+		value = ppu.drive(ppu.paletteValue(), 0x3F)
 	}
 	// increment address
 	if ppu.flagIncrement == 0 {
@@ -377,13 +516,13 @@ func (ppu *PPU) writeDMA(value byte) {
 	cpu := ppu.device.CPU
 	address := uint16(value) << 8
 	for i := 0; i < 256; i++ {
-		ppu.oamData[ppu.oamAddress] = cpu.Read(address)
+		ppu.oamData[ppu.oamAddress] = ppu.device.memory.Read(address)
 		ppu.oamAddress++
 		address++
 	}
-	cpu.stall += 513
+	cpu.Stall(513)
 	if cpu.Cycles%2 == 1 {
-		cpu.stall++
+		cpu.Stall(1)
 	}
 }
 
@@ -445,17 +584,19 @@ func (ppu *PPU) copyY() {
 
 func (ppu *PPU) nmiChange() {
 	nmi := ppu.nmiOutput && ppu.nmiOccurred
-	if nmi && !ppu.nmiPrevious {
-		// TODO: this fixes some games but the delay shouldn't have to be so
-		// long, so the timings are off somewhere
+	if nmi && !ppu.nmiPrevious && !ppu.device.CPU.CycleAccurate() {
+		// TODO: this fixes some games on the instruction-level core but the
+		// delay shouldn't have to be so long, so the timings are off somewhere
 		ppu.nmiDelay = 15
+	} else {
+		ppu.device.CPU.SetNMI(nmi)
 	}
 	ppu.nmiPrevious = nmi
 }
 
 func (ppu *PPU) setVerticalBlank() {
-	ppu.front = ppu.back
-	ppu.back = ppu.front
+	ppu.front, ppu.back = ppu.back, ppu.front
+	ppu.frontPhase = ppu.phase
 	ppu.nmiOccurred = true
 	ppu.nmiChange()
 }
@@ -524,13 +665,14 @@ func (ppu *PPU) spritePixel() (byte, byte) {
 	if ppu.flagShowSprites == 0 {
 		return 0, 0
 	}
-	for i := 0; i < ppu.spriteCount; i++ {
-		offset := (ppu.Cycle - 1) - int(ppu.spritePositions[i])
+	for i := 0; i < ppu.spriteCount+len(ppu.extraSprites); i++ {
+		pattern, position, _ := ppu.sprite(i)
+		offset := (ppu.Cycle - 1) - int(position)
 		if offset < 0 || offset > 7 {
 			continue
 		}
 		offset = 7 - offset
-		color := byte((ppu.spritePatterns[i] >> byte(offset*4)) & 0x0F)
+		color := byte((pattern >> byte(offset*4)) & 0x0F)
 		if color%4 == 0 {
 			continue
 		}
@@ -560,22 +702,19 @@ func (ppu *PPU) renderPixel() {
 	} else if b && !s {
 		color = background
 	} else {
-		if ppu.spriteIndexes[i] == 0 && x < 255 {
+		if i == 0 && ppu.spriteZero && x < 255 {
 			ppu.flagSpriteZeroHit = 1
 		}
-		if ppu.spritePriorities[i] == 0 {
+		if _, _, priority := ppu.sprite(int(i)); priority == 0 {
 			color = sprite | 0x10
 		} else {
 			color = background
 		}
 	}
-	c := pallete.Palette[ppu.readPalette(uint16(color))%64]
-	ppu.back.SetRGBA(x, y, c)
+	ppu.back.Set(x, y, ppu.colorIndex(color))
 }
 
-func (ppu *PPU) fetchSpritePattern(i, row int) uint32 {
-	tile := ppu.oamData[i*4+1]
-	attributes := ppu.oamData[i*4+2]
+func (ppu *PPU) fetchSpritePattern(tile, attributes byte, row int) uint32 {
 	var address uint16
 	if ppu.flagSpriteSize == 0 {
 		if attributes&0x80 == 0x80 {
@@ -618,52 +757,23 @@ func (ppu *PPU) fetchSpritePattern(i, row int) uint32 {
 	return data
 }
 
-func (ppu *PPU) evaluateSprites() {
-	var h int
-	if ppu.flagSpriteSize == 0 {
-		h = 8
-	} else {
-		h = 16
-	}
-	count := 0
-	for i := 0; i < 64; i++ {
-		y := ppu.oamData[i*4+0]
-		a := ppu.oamData[i*4+2]
-		x := ppu.oamData[i*4+3]
-		row := ppu.ScanLine - int(y)
-		if row < 0 || row >= h {
-			continue
-		}
-		if count < 8 {
-			ppu.spritePatterns[count] = ppu.fetchSpritePattern(i, row)
-			ppu.spritePositions[count] = x
-			ppu.spritePriorities[count] = (a >> 5) & 1
-			ppu.spriteIndexes[count] = byte(i)
-		}
-		count++
-	}
-	if count > 8 {
-		count = 8
-		ppu.flagSpriteOverflow = 1
-	}
-	ppu.spriteCount = count
-}
-
 // tick updates Cycle, ScanLine and Frame counters
 func (ppu *PPU) tick() {
 	if ppu.nmiDelay > 0 {
 		ppu.nmiDelay--
 		if ppu.nmiDelay == 0 && ppu.nmiOutput && ppu.nmiOccurred {
-			ppu.device.CPU.triggerNMI()
+			ppu.device.CPU.SetNMI(true)
 		}
 	}
 
-	if ppu.flagShowBackground != 0 || ppu.flagShowSprites != 0 {
-		if ppu.f == 1 && ppu.ScanLine == 261 && ppu.Cycle == 339 {
+	if ppu.skipOddDot && (ppu.flagShowBackground != 0 || ppu.flagShowSprites != 0) {
+		if ppu.f == 1 && ppu.ScanLine == ppu.scanLines-1 && ppu.Cycle == 339 {
 			ppu.Cycle = 0
 			ppu.ScanLine = 0
 			ppu.Frame++
 			ppu.f ^= 1
+			// each dot is 8 twelfths of a subcarrier cycle
+			ppu.phase = byte((int(ppu.phase) + (341*ppu.scanLines-1)*8) % 12)
 			return
 		}
 	}
@@ -671,10 +781,11 @@ func (ppu *PPU) tick() {
 	if ppu.Cycle > 340 {
 		ppu.Cycle = 0
 		ppu.ScanLine++
-		if ppu.ScanLine > 261 {
+		if ppu.ScanLine >= ppu.scanLines {
 			ppu.ScanLine = 0
 			ppu.Frame++
 			ppu.f ^= 1
+			ppu.phase = byte((int(ppu.phase) + 341*ppu.scanLines*8) % 12)
 		}
 	}
 }
@@ -683,7 +794,7 @@ func (ppu *PPU) tick() {
 func (ppu *PPU) Step() {
 	ppu.tick()
 	renderingEnabled := ppu.flagShowBackground != 0 || ppu.flagShowSprites != 0
-	preLine := ppu.ScanLine == 261
+	preLine := ppu.ScanLine == ppu.scanLines-1
 	visibleLine := ppu.ScanLine < 240
 	renderLine := preLine || visibleLine
 	preFetchCycle := ppu.Cycle >= 321 && ppu.Cycle <= 336
@@ -727,18 +838,15 @@ func (ppu *PPU) Step() {
 	}
 
 	// sprite logic
-	if renderingEnabled {
-		if ppu.Cycle == 257 {
-			if visibleLine {
-				ppu.evaluateSprites()
-			} else {
-				ppu.spriteCount = 0
-			}
+	if renderingEnabled && renderLine {
+		if preLine && ppu.Cycle == 1 {
+			ppu.corruptOAM()
 		}
+		ppu.stepSprites(visibleLine)
 	}
 
 	// vblank logic
-	if ppu.ScanLine == 241 && ppu.Cycle == 1 {
+	if ppu.ScanLine == ppu.vblankLine && ppu.Cycle == 1 {
 		ppu.setVerticalBlank()
 	}
 	if preLine && ppu.Cycle == 1 {
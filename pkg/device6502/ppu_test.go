@@ -0,0 +1,134 @@
+package device6502
+
+import (
+	"testing"
+
+	"github.com/se-nonide/go6502/pkg/pallete"
+)
+
+func TestColorIndex(t *testing.T) {
+	ppu := &PPU{}
+	ppu.writePalette(0, 0x16)
+	tests := []struct {
+		mask  byte
+		index uint16
+	}{
+		{0x00, 0x016},
+		{0x01, 0x010}, // grayscale keeps the brightness column
+		{0x20, 0x056}, // red
+		{0x40, 0x096}, // green
+		{0x80, 0x116}, // blue
+		{0xE1, 0x1D0}, // all emphasis bits in grayscale
+	}
+	for _, test := range tests {
+		ppu.writeMask(test.mask)
+		if index := ppu.colorIndex(0); index != test.index {
+			t.Errorf("mask $%02X: index $%03X, want $%03X", test.mask, index, test.index)
+		}
+	}
+}
+
+func TestEmphasizedPalette(t *testing.T) {
+	for i := 0; i < 64; i++ {
+		if pallete.Emphasized[i] != pallete.Palette[i] {
+			t.Fatalf("color $%02X changed without emphasis", i)
+		}
+	}
+	white := pallete.Palette[0x30]
+	red := pallete.Emphasized[1<<6|0x30]
+	if red.R != white.R || red.G >= white.G || red.B >= white.B {
+		t.Errorf("red emphasis of %v gave %v", white, red)
+	}
+	all := pallete.Emphasized[7<<6|0x30]
+	if all.R >= red.R || all.G >= red.G {
+		t.Errorf("full emphasis %v should be darker than %v", all, red)
+	}
+}
+
+func TestFrameBufferRGBA(t *testing.T) {
+	frame := &FrameBuffer{}
+	frame.Set(3, 2, 0x30)
+	frame.Set(255, 239, 1<<6|0x30)
+	image := frame.RGBA(&pallete.Emphasized, nil)
+	if frame.At(3, 2) != 0x30 || frame[2*ScreenWidth+3] != 0x30 {
+		t.Error("Set and At disagree on the layout")
+	}
+	if c := image.RGBAAt(3, 2); c != pallete.Palette[0x30] {
+		t.Errorf("got %v, want %v", c, pallete.Palette[0x30])
+	}
+	if c := image.RGBAAt(255, 239); c != pallete.Emphasized[1<<6|0x30] {
+		t.Errorf("got %v, want %v", c, pallete.Emphasized[1<<6|0x30])
+	}
+	if c := image.RGBAAt(0, 0); c != pallete.Palette[0] {
+		t.Errorf("got %v, want %v", c, pallete.Palette[0])
+	}
+}
+
+func TestOpenBus(t *testing.T) {
+	device := newTestDevice(t, nil)
+	ppu := device.PPU
+	read := func(address uint16) byte {
+		return device.memory.Read(address)
+	}
+	device.memory.Write(0x2000, 0x80)
+	device.memory.Write(0x2003, 0x5F)
+	if value := read(0x2005); value != 0x5F {
+		t.Errorf("write-only register read $%02X, want the latch $5F", value)
+	}
+	ppu.nmiOccurred = true
+	if value := read(0x2002); value != 0x9F {
+		t.Errorf("status read $%02X, want $9F", value)
+	}
+
+	// palette reads keep the top two bits of the latch
+	device.memory.Write(0x2006, 0x3F)
+	device.memory.Write(0x2006, 0x00)
+	ppu.writePalette(0, 0x0F)
+	device.memory.Write(0x2001, 0xC0)
+	if value := read(0x2007); value != 0xCF {
+		t.Errorf("palette read $%02X, want $CF", value)
+	}
+
+	// bits fade one by one once they have not been driven for ~600ms
+	device.memory.Write(0x2003, 0xFF)
+	ppu.Frame += ppu.decayFrames / 2
+	ppu.nmiOccurred = true
+	read(0x2002) // drives bits 7-5 again, bit 7 as 1
+	ppu.Frame += ppu.decayFrames/2 + 1
+	if value := read(0x2000); value != 0x80 {
+		t.Errorf("latch $%02X after the low bits decayed, want $80", value)
+	}
+	ppu.Frame += ppu.decayFrames / 2
+	if value := read(0x2000); value != 0x00 {
+		t.Errorf("latch $%02X after every bit decayed, want $00", value)
+	}
+}
+
+func TestPeekRegisters(t *testing.T) {
+	device := newTestDevice(t, nil)
+	ppu := device.PPU
+	device.memory.Write(0x2003, 0x10)
+	ppu.oamData[0x10] = 0x42
+	device.memory.Write(0x2006, 0x21)
+	device.memory.Write(0x2006, 0x00)
+	ppu.bufferedData = 0x33
+	device.memory.Write(0x2000, 0x1F)
+	ppu.nmiOccurred = true
+	for i := 0; i < 2; i++ {
+		if value := device.Peek(0x2002); value != 0x9F {
+			t.Errorf("peek %d of $2002 = $%02X, want $9F", i, value)
+		}
+		if value := device.Peek(0x200C); value != 0x42 {
+			t.Errorf("peek %d of $2004 mirror = $%02X, want $42", i, value)
+		}
+		if value := device.Peek(0x2007); value != 0x33 {
+			t.Errorf("peek %d of $2007 = $%02X, want the buffer $33", i, value)
+		}
+	}
+	if !ppu.nmiOccurred || ppu.w != 0 || ppu.oamAddress != 0x10 || ppu.v != 0x2100 {
+		t.Error("peeking changed the PPU")
+	}
+	if ppu.register != 0x1F {
+		t.Errorf("latch $%02X after peeking, want $1F", ppu.register)
+	}
+}
@@ -0,0 +1,115 @@
+package device6502
+
+import (
+	"fmt"
+
+	"github.com/se-nonide/go6502/pkg/cartridge"
+)
+
+// Region selects the console's video system and with it the clocks of the
+// CPU, PPU and APU.
+type Region byte
+
+const (
+	RegionNTSC  Region = iota // NES and Famicom, 2C02 PPU
+	RegionPAL                 // European NES, 2C07 PPU
+	RegionDendy               // Famicom clones sold for PAL televisions
+)
+
+var regionNames = []string{"ntsc", "pal", "dendy"}
+
+func (r Region) String() string {
+	if int(r) < len(regionNames) {
+		return regionNames[r]
+	}
+	return fmt.Sprintf("Region(%d)", byte(r))
+}
+
+// ParseRegion accepts the names String returns.
+func ParseRegion(name string) (Region, error) {
+	for i, n := range regionNames {
+		if n == name {
+			return Region(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown region %q, expected one of %v", name, regionNames)
+}
+
+// CartridgeRegion picks the region a cartridge header asks for; NTSC for
+// cartridges made for several systems or that don't say.
+func CartridgeRegion(c *cartridge.Cartridge) Region {
+	switch c.Timing {
+	case cartridge.TimingPAL:
+		return RegionPAL
+	case cartridge.TimingDendy:
+		return RegionDendy
+	}
+	return RegionNTSC
+}
+
+type regionTiming struct {
+	cpuFrequency float64
+	// PPU dots per CPU cycle as a fraction
+	dotsPerCycle, cyclesPerDots int
+	scanLines                   int  // per frame, the last being the pre-render line
+	vblankLine                  int  // first scanline of vertical blank
+	skipOddDot                  bool // odd frames drop a dot while rendering
+	frameCounterRate            float64
+	noiseTable                  []uint16
+	dmcTable                    []byte
+}
+
+var regionTimings = []regionTiming{
+	RegionNTSC: {CPUFrequency, 3, 1, 262, 241, true, CPUFrequency / 240.0, noiseTable, dmcTable},
+	RegionPAL:  {1662607, 16, 5, 312, 241, false, 1662607 / 200.0, noiseTablePAL, dmcTablePAL},
+	// the Dendy keeps the NTSC APU periods in CPU cycles, and starts vblank
+	// after 51 post-render lines so NMI handlers get NTSC-like timing
+	RegionDendy: {1773448, 3, 1, 312, 291, false, CPUFrequency / 240.0, noiseTable, dmcTable},
+}
+
+func (r Region) timing() *regionTiming {
+	return &regionTimings[r]
+}
+
+// CPUFrequency returns the CPU clock in Hz.
+func (r Region) CPUFrequency() float64 {
+	return r.timing().cpuFrequency
+}
+
+// FrameRate returns the frames per second of the region, counting NTSC's
+// shortened odd frames.
+func (r Region) FrameRate() float64 {
+	t := r.timing()
+	dots := float64(341 * t.scanLines)
+	if t.skipOddDot {
+		dots -= 0.5
+	}
+	return t.cpuFrequency * float64(t.dotsPerCycle) / float64(t.cyclesPerDots) / dots
+}
+
+// SetRegion switches the timing of the CPU, PPU and APU. New devices take
+// the region from the cartridge header.
+func (device *Device) SetRegion(region Region) {
+	if int(region) >= len(regionTimings) {
+		region = RegionNTSC
+	}
+	device.region = region
+	device.dots = 0
+	timing := region.timing()
+	device.PPU.scanLines = timing.scanLines
+	device.PPU.vblankLine = timing.vblankLine
+	device.PPU.skipOddDot = timing.skipOddDot
+	// the PPU's I/O latch holds a bit for about 600ms
+	device.PPU.decayFrames = uint64(region.FrameRate() * 0.6)
+	if device.PPU.ScanLine >= timing.scanLines {
+		device.PPU.ScanLine = timing.vblankLine
+	}
+	device.APU.frameCounterRate = timing.frameCounterRate
+	device.APU.noise.periods = timing.noiseTable
+	device.APU.dmc.periods = timing.dmcTable
+	device.SetAudioSampleRate(device.audioSampleRate)
+}
+
+func (device *Device) Region() Region {
+	return device.region
+}
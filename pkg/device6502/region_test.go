@@ -0,0 +1,146 @@
+package device6502
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/se-nonide/go6502/internal/nesrom"
+	"github.com/se-nonide/go6502/pkg/loader"
+	"github.com/se-nonide/go6502/pkg/romdb"
+)
+
+// newTestDevice builds an NROM device around a program at $C000 with the
+// given header bytes 6-15.
+func newTestDevice(t *testing.T, program []byte, header ...byte) *Device {
+	cartridge, err := loader.LoadBytes(nesrom.NROM(program, nil, header...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := NewDeviceFromCartridge(cartridge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device.Reset()
+	return device
+}
+
+func TestCartridgeRegion(t *testing.T) {
+	tests := []struct {
+		header []byte
+		region Region
+	}{
+		{nil, RegionNTSC},
+		{[]byte{0, 0, 0, 1}, RegionPAL},                       // iNES byte 9
+		{[]byte{0, 0, 0, 1, 0, 0, 'D', 'u', 'd'}, RegionNTSC}, // junk in 12-15
+		{[]byte{0, 0x08, 0, 0, 0, 0, 1}, RegionPAL},           // NES 2.0 byte 12
+		{[]byte{0, 0x08, 0, 0, 0, 0, 2}, RegionNTSC},          // multi-region
+		{[]byte{0, 0x08, 0, 0, 0, 0, 3}, RegionDendy},
+	}
+	for _, test := range tests {
+		device := newTestDevice(t, nil, test.header...)
+		if device.Region() != test.region {
+			t.Errorf("header % X: region %v, want %v", test.header, device.Region(), test.region)
+		}
+	}
+}
+
+func TestDatabaseCorrection(t *testing.T) {
+	defer func(db *romdb.Database) { romdb.Default = db }(romdb.Default)
+	romdb.Default = romdb.New()
+	// the header says NTSC, horizontal mirroring and no battery
+	rom := newTestDevice(t, nil).Cartridge
+	crc, sum := romdb.Hash(rom)
+	err := romdb.Default.ReadNES20DB(strings.NewReader(fmt.Sprintf(`<nes20db>
+	<game>
+		<!-- Games\Test (Europe).nes -->
+		<rom size="%d" crc32="%08X" sha1="%s"/>
+		<prgnvram size="8192"/>
+		<pcb mapper="0" submapper="0" mirroring="V" battery="1"/>
+		<console type="0" region="1"/>
+	</game>
+</nes20db>`, len(romdb.ROM(rom)), crc, strings.ToUpper(sum))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	device := newTestDevice(t, nil)
+	if device.Region() != RegionPAL {
+		t.Errorf("region %v, want the database's PAL", device.Region())
+	}
+	c := device.Cartridge
+	if c.Mirror != MirrorVertical || c.Battery != 1 || c.PRGNVRAMSize != 0x2000 {
+		t.Errorf("mirroring %d, battery %d, %d bytes of PRG-NVRAM; want the database's vertical, 1 and 8KB",
+			c.Mirror, c.Battery, c.PRGNVRAMSize)
+	}
+}
+
+func TestRegionTiming(t *testing.T) {
+	tests := []struct {
+		region     Region
+		cycles     float64 // CPU cycles per frame with rendering off
+		vblankLine int
+		frameRate  float64
+	}{
+		{RegionNTSC, 341 * 262 / 3.0, 241, 60.0988},
+		{RegionPAL, 341 * 312 / 3.2, 241, 50.0070},
+		{RegionDendy, 341 * 312 / 3.0, 291, 50.0070},
+	}
+	for _, test := range tests {
+		// JMP *
+		device := newTestDevice(t, []byte{0x4C, 0x00, 0xC0})
+		device.SetRegion(test.region)
+		device.StepFrame()
+		frames := 10
+		cycles := 0
+		for i := 0; i < frames; i++ {
+			cycles += device.StepFrame()
+		}
+		if math.Abs(float64(cycles)/float64(frames)-test.cycles) > 1 {
+			t.Errorf("%v: %d cycles per frame, want %.1f", test.region, cycles/frames, test.cycles)
+		}
+		for !device.PPU.nmiOccurred {
+			device.Step()
+		}
+		if device.PPU.ScanLine != test.vblankLine {
+			t.Errorf("%v: vblank started on line %d, want %d", test.region, device.PPU.ScanLine, test.vblankLine)
+		}
+		if rate := test.region.FrameRate(); math.Abs(rate-test.frameRate) > 0.001 {
+			t.Errorf("%v: %.4f frames per second, want %.4f", test.region, rate, test.frameRate)
+		}
+	}
+}
+
+func TestSaveRegion(t *testing.T) {
+	device := newTestDevice(t, nil)
+	device.SetRegion(RegionPAL)
+	device.dots = 2
+	var state bytes.Buffer
+	if err := device.Save(gob.NewEncoder(&state)); err != nil {
+		t.Fatal(err)
+	}
+	loaded := newTestDevice(t, nil)
+	if err := loaded.Load(gob.NewDecoder(&state)); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Region() != RegionPAL || loaded.dots != 2 {
+		t.Fatalf("loaded %v with %d dots owed, want PAL and 2", loaded.Region(), loaded.dots)
+	}
+	if loaded.PPU.decayFrames != device.PPU.decayFrames || loaded.PPU.scanLines != 312 {
+		t.Errorf("decay after %d frames over %d lines, want %d frames over 312",
+			loaded.PPU.decayFrames, loaded.PPU.scanLines, device.PPU.decayFrames)
+	}
+}
+
+func TestParseRegion(t *testing.T) {
+	for _, region := range []Region{RegionNTSC, RegionPAL, RegionDendy} {
+		if parsed, err := ParseRegion(region.String()); err != nil || parsed != region {
+			t.Errorf("ParseRegion(%q) = %v, %v", region, parsed, err)
+		}
+	}
+	if _, err := ParseRegion("secam"); err == nil {
+		t.Error("expected an error for secam")
+	}
+}
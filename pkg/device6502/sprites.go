@@ -0,0 +1,211 @@
+package device6502
+
+// Sprite evaluation as the 2C02 does it, one step per dot of a visible
+// line: dots 1-64 clear secondary OAM, dots 65-256 read primary OAM on odd
+// dots and copy the sprites in range of the line on even ones, dots 257-320
+// fetch the eight sprites found for the next line. OAMADDR is the scan
+// position throughout, which is why writing it before rendering matters.
+
+// states of the evaluation during dots 65-256
+const (
+	evalSearch       = iota // looking at the Y byte of sprite n
+	evalCopy                // copying the other three bytes of a sprite in range
+	evalOverflow            // secondary OAM is full, looking for a ninth sprite
+	evalOverflowCopy        // ninth sprite found, reading its other bytes
+	evalDone                // every sprite seen
+)
+
+func (ppu *PPU) spriteHeight() int {
+	if ppu.flagSpriteSize == 0 {
+		return 8
+	}
+	return 16
+}
+
+func (ppu *PPU) spriteInRange(y byte) bool {
+	row := ppu.ScanLine - int(y)
+	return row >= 0 && row < ppu.spriteHeight()
+}
+
+// stepSprites runs the sprite half of the PPU for one dot of a visible or
+// pre-render line with rendering enabled.
+func (ppu *PPU) stepSprites(visibleLine bool) {
+	cycle := ppu.Cycle
+	switch {
+	case cycle == 0:
+	case cycle <= 64:
+		if !visibleLine {
+			return
+		}
+		// the clear reads $FF from a forced bus on odd dots
+		ppu.oamBus = 0xFF
+		if cycle%2 == 0 {
+			ppu.secondaryOAM[cycle/2-1] = ppu.oamBus
+		}
+		if cycle == 64 {
+			ppu.evalState = evalSearch
+			ppu.secondaryIndex = 0
+			ppu.evalRemaining = 0
+			ppu.spriteZeroNext = false
+			ppu.evalCopied = 0
+		}
+	case cycle <= 256:
+		if !visibleLine {
+			return
+		}
+		if cycle%2 == 1 {
+			ppu.oamBus = ppu.oamData[ppu.oamAddress]
+		} else {
+			ppu.evaluateSprite(ppu.oamBus)
+		}
+	case cycle <= 320:
+		ppu.oamAddress = 0
+		i := (cycle - 257) / 8
+		k := (cycle - 257) % 8
+		if k > 3 {
+			k = 3
+		}
+		ppu.oamBus = ppu.secondaryOAM[i*4+k]
+		if cycle == 257 {
+			if visibleLine {
+				ppu.loadSprites()
+			} else {
+				ppu.spriteCount = 0
+				ppu.extraSprites = ppu.extraSprites[:0]
+			}
+		}
+	default:
+		ppu.oamBus = ppu.secondaryOAM[0]
+	}
+}
+
+// evaluateSprite is the even dot of sprite evaluation, acting on the byte
+// read from OAMADDR on the dot before.
+func (ppu *PPU) evaluateSprite(value byte) {
+	switch ppu.evalState {
+	case evalSearch, evalCopy:
+		ppu.secondaryOAM[ppu.secondaryIndex] = value
+		if ppu.evalState == evalSearch {
+			if !ppu.spriteInRange(value) {
+				ppu.nextSprite()
+				return
+			}
+			// whichever sprite OAMADDR selects at the first step is
+			// treated as sprite 0 for hits, even when it is not at 0
+			if ppu.Cycle == 66 {
+				ppu.spriteZeroNext = true
+			}
+			ppu.evalCopied |= 1 << (ppu.oamAddress >> 2)
+			ppu.evalState = evalCopy
+		}
+		ppu.secondaryIndex++
+		ppu.oamAddress++
+		if ppu.oamAddress&3 != 0 {
+			return
+		}
+		ppu.evalState = evalSearch
+		switch {
+		case ppu.oamAddress == 0:
+			ppu.evalState = evalDone
+		case ppu.secondaryIndex == 32:
+			ppu.evalState = evalOverflow
+		}
+	case evalOverflow:
+		if ppu.spriteInRange(value) {
+			ppu.flagSpriteOverflow = 1
+			ppu.evalState = evalOverflowCopy
+			ppu.evalRemaining = 3
+			ppu.oamAddress++
+			return
+		}
+		// the hardware bug: m is incremented along with n, so the search
+		// goes on diagonally through tile, attribute and X bytes
+		n := ppu.oamAddress>>2 + 1
+		m := (ppu.oamAddress + 1) & 3
+		if n == 64 {
+			ppu.evalState = evalDone
+		}
+		ppu.oamAddress = n<<2 | m
+	case evalOverflowCopy:
+		ppu.evalRemaining--
+		ppu.oamAddress++
+		if ppu.evalRemaining == 0 {
+			ppu.oamAddress &= 0xFC
+			ppu.evalState = evalDone
+		}
+	case evalDone:
+		// keeps failing to copy Y bytes while n goes round
+		ppu.oamAddress = (ppu.oamAddress + 4) & 0xFC
+	}
+}
+
+// nextSprite moves the search to the next sprite, ending it after 64.
+func (ppu *PPU) nextSprite() {
+	if ppu.oamAddress >= 0xFC {
+		ppu.evalState = evalDone
+	}
+	ppu.oamAddress += 4
+}
+
+// extraSprite is a sprite drawn past the eighth of a line.
+type extraSprite struct {
+	pattern  uint32
+	position byte
+	priority byte
+}
+
+// sprite returns slot i of the line: one of the eight from secondary OAM,
+// then the extra ones.
+func (ppu *PPU) sprite(i int) (pattern uint32, position, priority byte) {
+	if i < ppu.spriteCount {
+		return ppu.spritePatterns[i], ppu.spritePositions[i], ppu.spritePriorities[i]
+	}
+	extra := ppu.extraSprites[i-ppu.spriteCount]
+	return extra.pattern, extra.position, extra.priority
+}
+
+// loadSprites fetches the patterns of the sprites in secondary OAM for the
+// next line, followed without the sprite limit by every other sprite in
+// range that evaluation did not copy.
+func (ppu *PPU) loadSprites() {
+	count := ppu.secondaryIndex / 4
+	if count > 8 {
+		count = 8
+	}
+	for i := 0; i < count; i++ {
+		sprite := ppu.secondaryOAM[i*4 : i*4+4]
+		ppu.spritePatterns[i], ppu.spritePositions[i], ppu.spritePriorities[i] = ppu.loadSprite(sprite)
+	}
+	ppu.extraSprites = ppu.extraSprites[:0]
+	if ppu.unlimitedSprites && count == 8 {
+		for i := 0; i < 64; i++ {
+			sprite := ppu.oamData[i*4 : i*4+4]
+			if ppu.evalCopied&(1<<i) != 0 || !ppu.spriteInRange(sprite[0]) {
+				continue
+			}
+			var extra extraSprite
+			extra.pattern, extra.position, extra.priority = ppu.loadSprite(sprite)
+			ppu.extraSprites = append(ppu.extraSprites, extra)
+		}
+	}
+	ppu.spriteCount = count
+	ppu.spriteZero = ppu.spriteZeroNext
+}
+
+// loadSprite fetches the pattern row of a sprite for the next line and
+// returns it with the sprite's X position and priority.
+func (ppu *PPU) loadSprite(sprite []byte) (uint32, byte, byte) {
+	attributes := sprite[2]
+	row := ppu.ScanLine - int(sprite[0])
+	return ppu.fetchSpritePattern(sprite[1], attributes, row), sprite[3], (attributes >> 5) & 1
+}
+
+// corruptOAM reproduces the 2C02's OAM refresh artifact: when rendering
+// starts with OAMADDR at 8 or above, the eight bytes at OAMADDR & $F8
+// overwrite the first eight.
+func (ppu *PPU) corruptOAM() {
+	if ppu.oamAddress >= 8 {
+		start := int(ppu.oamAddress & 0xF8)
+		copy(ppu.oamData[:8], ppu.oamData[start:start+8])
+	}
+}
@@ -0,0 +1,197 @@
+package device6502
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+)
+
+// runLine renders the scanline before line, where sprites for it are
+// evaluated, with OAM set from sprites (Y and tile of each, in order) and
+// the rest of OAM off screen.
+func runLine(t *testing.T, line int, sprites ...[2]byte) *PPU {
+	return runLineOn(newTestDevice(t, nil), line, sprites...)
+}
+
+func runLineOn(device *Device, line int, sprites ...[2]byte) *PPU {
+	ppu := device.PPU
+	for i := range ppu.oamData {
+		ppu.oamData[i] = 0xF0
+	}
+	for i, sprite := range sprites {
+		ppu.oamData[i*4+0] = sprite[0]
+		ppu.oamData[i*4+1] = sprite[1]
+		ppu.oamData[i*4+3] = byte(i)
+	}
+	ppu.writeMask(0x18)
+	ppu.ScanLine = line
+	ppu.Cycle = 0
+	for ppu.Cycle < 340 {
+		ppu.Step()
+	}
+	return ppu
+}
+
+func TestSpriteEvaluation(t *testing.T) {
+	inRange := [2]byte{10, 0xF0}
+	outOfRange := [2]byte{0xF0, 0xF0}
+	eight := [][2]byte{inRange, inRange, inRange, inRange, inRange, inRange, inRange, inRange}
+	tests := []struct {
+		name     string
+		sprites  [][2]byte
+		count    int
+		overflow byte
+	}{
+		{"none", nil, 0, 0},
+		{"eight", eight, 8, 0},
+		{"nine", append(eight, inRange), 8, 1},
+		// sprite 8 is out of range, so the buggy search reads the tile of
+		// sprite 9, which holds a Y in range
+		{"false overflow", append(eight, outOfRange, [2]byte{0xF0, 10}), 8, 1},
+		// and here it reads sprite 9's tile instead of its Y, missing it
+		{"missed overflow", append(eight, outOfRange, [2]byte{10, 0xF0}), 8, 0},
+	}
+	for _, test := range tests {
+		ppu := runLine(t, 12, test.sprites...)
+		if ppu.spriteCount != test.count || ppu.flagSpriteOverflow != test.overflow {
+			t.Errorf("%s: %d sprites, overflow %d; want %d, %d", test.name,
+				ppu.spriteCount, ppu.flagSpriteOverflow, test.count, test.overflow)
+		}
+		for i := 0; i < ppu.spriteCount; i++ {
+			if ppu.spritePositions[i] != byte(i) {
+				t.Errorf("%s: slot %d holds sprite %d", test.name, i, ppu.spritePositions[i])
+			}
+		}
+		if !ppu.spriteZero && test.count > 0 {
+			t.Errorf("%s: sprite 0 not flagged", test.name)
+		}
+	}
+
+	// only the second sprite in range: slot 0 is not sprite 0
+	ppu := runLine(t, 12, outOfRange, inRange)
+	if ppu.spriteCount != 1 || ppu.spriteZero || ppu.spritePositions[0] != 1 {
+		t.Errorf("got %d sprites, sprite zero %v, X %d", ppu.spriteCount, ppu.spriteZero, ppu.spritePositions[0])
+	}
+}
+
+func TestOAMDuringRendering(t *testing.T) {
+	device := newTestDevice(t, nil)
+	ppu := device.PPU
+	ppu.writeMask(0x18)
+	ppu.ScanLine = 20
+	ppu.Cycle = 0
+	ppu.oamData[0] = 0x42
+	for ppu.Cycle < 10 {
+		ppu.Step()
+	}
+	if value := device.memory.Read(0x2004); value != 0xFF {
+		t.Errorf("$2004 read $%02X while clearing secondary OAM, want $FF", value)
+	}
+	device.memory.Write(0x2004, 0x99)
+	if ppu.oamAddress != 4 || ppu.oamData[0] != 0x42 {
+		t.Errorf("write during rendering: OAMADDR $%02X, OAM[0] $%02X", ppu.oamAddress, ppu.oamData[0])
+	}
+	for ppu.Cycle < 300 {
+		ppu.Step()
+	}
+	if ppu.oamAddress != 0 {
+		t.Errorf("OAMADDR $%02X during sprite fetches, want 0", ppu.oamAddress)
+	}
+
+	// outside rendering $2004 reads OAM, with the attribute bits masked
+	ppu.writeMask(0)
+	ppu.oamData[2] = 0xFF
+	ppu.writeOAMAddress(2)
+	if value := device.memory.Read(0x2004); value != 0xE3 {
+		t.Errorf("attribute read $%02X, want $E3", value)
+	}
+}
+
+func TestSpriteZeroLatch(t *testing.T) {
+	inRange := [2]byte{10, 0}
+	outOfRange := [2]byte{0xF0, 0}
+	tests := []struct {
+		name    string
+		address byte
+		zero    bool
+	}{
+		{"first sprite in range", 0, true},
+		{"first sprite out of range", 4, false},
+		// evaluation starting at sprite 2 takes it for sprite 0
+		{"nonzero OAMADDR", 8, true},
+	}
+	for _, test := range tests {
+		device := newTestDevice(t, nil)
+		device.PPU.oamAddress = test.address
+		ppu := runLineOn(device, 12, inRange, outOfRange, inRange)
+		if ppu.spriteZero != test.zero {
+			t.Errorf("%s: sprite 0 on the line %v, want %v", test.name, ppu.spriteZero, test.zero)
+		}
+	}
+}
+
+func TestOAMAddressCorruption(t *testing.T) {
+	device := newTestDevice(t, nil)
+	ppu := device.PPU
+	for i := range ppu.oamData {
+		ppu.oamData[i] = byte(i)
+	}
+	ppu.writeMask(0x18)
+	ppu.writeOAMAddress(0x23)
+	ppu.ScanLine = ppu.scanLines - 1
+	ppu.Cycle = 0
+	ppu.Step()
+	for i := 0; i < 8; i++ {
+		if ppu.oamData[i] != byte(0x20+i) {
+			t.Fatalf("OAM[%d] = $%02X, want $%02X", i, ppu.oamData[i], 0x20+i)
+		}
+	}
+}
+
+// checkSlots checks which sprites, numbered by their X positions, the line
+// draws in each slot.
+func checkSlots(t *testing.T, ppu *PPU, want ...byte) {
+	t.Helper()
+	if count := ppu.spriteCount + len(ppu.extraSprites); count != len(want) {
+		t.Fatalf("%d sprites on the line, want %d", count, len(want))
+	}
+	for i, x := range want {
+		if _, position, _ := ppu.sprite(i); position != x {
+			t.Errorf("slot %d holds sprite %d, want %d", i, position, x)
+		}
+	}
+}
+
+func TestUnlimitedSprites(t *testing.T) {
+	var sprites [][2]byte
+	for i := 0; i < 12; i++ {
+		sprites = append(sprites, [2]byte{10, 0})
+	}
+	sprites[4][0] = 0xF0
+	device := newTestDevice(t, nil)
+	device.SetUnlimitedSprites(true)
+	ppu := runLineOn(device, 12, sprites...)
+	checkSlots(t, ppu, 0, 1, 2, 3, 5, 6, 7, 8, 9, 10, 11)
+	if ppu.flagSpriteOverflow != 1 {
+		t.Error("no overflow")
+	}
+
+	// evaluation starting at sprite 2 copies sprites 2-9, the extra ones
+	// are those it did not copy rather than the ninth sprite on
+	device.PPU.oamAddress = 8
+	sprites[4][0] = 10
+	ppu = runLineOn(device, 12, sprites...)
+	checkSlots(t, ppu, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1, 10, 11)
+
+	var state bytes.Buffer
+	if err := device.Save(gob.NewEncoder(&state)); err != nil {
+		t.Fatal(err)
+	}
+	loaded := newTestDevice(t, nil)
+	if err := loaded.Load(gob.NewDecoder(&state)); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.UnlimitedSprites() {
+		t.Error("the sprite limit setting was restored from the state")
+	}
+}
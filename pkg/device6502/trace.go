@@ -0,0 +1,238 @@
+package device6502
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/se-nonide/go6502/pkg/cpu6502"
+)
+
+// Tracer logs every executed instruction in the nestest/Nintendulator
+// format. In compare mode each line is also checked against a reference log
+// and the device stops at the first divergence.
+type Tracer struct {
+	writer    io.Writer
+	enabled   bool
+	reference *bufio.Scanner
+	line      int
+	first     *traceState // first reference line, for cycle alignment
+	base      uint64      // live cycle count on the first line
+	ppuFirst  *traceState // first reference line with a PPU position
+	ppuBase   int         // live dot within the frame on that line
+	frameDots int         // dots per frame of the live run
+	err       error
+}
+
+// TraceMismatch describes the first line where a run diverged from the
+// reference log.
+type TraceMismatch struct {
+	Line     int
+	Field    string
+	Expected string
+	Actual   string
+}
+
+func (e *TraceMismatch) Error() string {
+	return fmt.Sprintf("trace diverged at line %d:\nexpected: %s\nactual:   %s",
+		e.Line, e.Expected, e.Actual)
+}
+
+type traceState struct {
+	PC       uint16
+	A        byte
+	X        byte
+	Y        byte
+	P        byte
+	SP       byte
+	ScanLine int
+	Dot      int
+	Cycles   uint64
+}
+
+func NewTracer(writer io.Writer) *Tracer {
+	return &Tracer{writer: writer, enabled: true}
+}
+
+func (t *Tracer) SetEnabled(enabled bool) {
+	t.enabled = enabled
+}
+
+func (t *Tracer) Enabled() bool {
+	return t.enabled
+}
+
+// Compare switches the tracer to compare mode against a reference log.
+// Registers and flags must match exactly. Cycle counts, and the PPU
+// scanline and dot on lines that give them, are compared relative to the
+// first line so that differing power-up timing does not matter. The writer
+// may be nil when only the comparison is wanted.
+func (t *Tracer) Compare(reference io.Reader) {
+	t.reference = bufio.NewScanner(reference)
+}
+
+// Err returns the mismatch or write error that stopped the trace.
+func (t *Tracer) Err() error {
+	return t.err
+}
+
+func (device *Device) SetTracer(tracer *Tracer) {
+	device.tracer = tracer
+}
+
+func (device *Device) Tracer() *Tracer {
+	return device.tracer
+}
+
+func (t *Tracer) trace(device *Device) {
+	if !t.enabled || t.err != nil {
+		return
+	}
+	cpu := device.CPU
+	state := traceState{
+		cpu.PC, cpu.A, cpu.X, cpu.Y, cpu.Flags(), cpu.SP,
+		device.PPU.ScanLine, device.PPU.Cycle, cpu.Cycles,
+	}
+	t.frameDots = 341 * device.PPU.scanLines
+	line := formatTrace(device.Peek, state)
+	t.line++
+	if t.writer != nil {
+		if _, err := fmt.Fprintln(t.writer, line); err != nil {
+			t.err = err
+			return
+		}
+	}
+	if t.reference != nil {
+		t.compare(state, line)
+	}
+}
+
+func (t *Tracer) compare(state traceState, line string) {
+	if !t.reference.Scan() {
+		if err := t.reference.Err(); err != nil {
+			t.err = err
+		} else {
+			t.reference = nil
+		}
+		return
+	}
+	expected := t.reference.Text()
+	want, err := parseTrace(expected)
+	if err != nil {
+		t.err = fmt.Errorf("reference line %d: %v", t.line, err)
+		return
+	}
+	if t.first == nil {
+		t.first = &want
+		t.base = state.Cycles
+	}
+	if t.ppuFirst == nil && want.ScanLine >= 0 {
+		t.ppuFirst = &want
+		t.ppuBase = state.ScanLine*341 + state.Dot
+	}
+	mismatch := func(field string) {
+		t.err = &TraceMismatch{t.line, field, expected, line}
+	}
+	switch {
+	case state.PC != want.PC:
+		mismatch("PC")
+	case state.A != want.A:
+		mismatch("A")
+	case state.X != want.X:
+		mismatch("X")
+	case state.Y != want.Y:
+		mismatch("Y")
+	case state.P != want.P:
+		mismatch("P")
+	case state.SP != want.SP:
+		mismatch("SP")
+	case want.ScanLine >= 0 && !t.ppuAligned(state, want):
+		mismatch("PPU")
+	case state.Cycles-t.base != want.Cycles-t.first.Cycles:
+		mismatch("CYC")
+	}
+}
+
+// ppuAligned reports whether the PPU has moved as far since the first line
+// with a PPU position as it did in the reference, within a frame.
+func (t *Tracer) ppuAligned(state, want traceState) bool {
+	frame := t.frameDots
+	if frame == 0 {
+		frame = 341 * 262
+	}
+	moved := func(position, first int) int {
+		return ((position-first)%frame + frame) % frame
+	}
+	return moved(state.ScanLine*341+state.Dot, t.ppuBase) ==
+		moved(want.ScanLine*341+want.Dot, t.ppuFirst.ScanLine*341+t.ppuFirst.Dot)
+}
+
+// formatTrace renders one log line, e.g.
+//
+//	C000  4C F5 C5  JMP $C5F5                       A:00 X:00 Y:00 P:24 SP:FD PPU:  0, 21 CYC:7
+func formatTrace(peek func(uint16) byte, state traceState) string {
+	return fmt.Sprintf("%sA:%02X X:%02X Y:%02X P:%02X SP:%02X PPU:%3d,%3d CYC:%d",
+		cpu6502.FormatInstruction(peek, state.PC, state.X, state.Y),
+		state.A, state.X, state.Y, state.P, state.SP,
+		state.ScanLine, state.Dot, state.Cycles)
+}
+
+// parseTrace extracts the machine state from a nestest-style log line.
+// ScanLine and Dot are -1 when the line has no PPU position.
+func parseTrace(line string) (traceState, error) {
+	state := traceState{ScanLine: -1, Dot: -1}
+	malformed := fmt.Errorf("malformed trace line %q", line)
+	registers := strings.Index(line, "A:")
+	if len(line) < 4 || registers < 0 {
+		return state, malformed
+	}
+	pc, err := strconv.ParseUint(line[:4], 16, 16)
+	if err != nil {
+		return state, malformed
+	}
+	state.PC = uint16(pc)
+	text := line[registers:]
+	for _, space := range []string{": ", ", "} {
+		for strings.Contains(text, space) {
+			text = strings.ReplaceAll(text, space, space[:1])
+		}
+	}
+	for _, f := range strings.Fields(text) {
+		i := strings.IndexByte(f, ':')
+		if i < 0 {
+			continue
+		}
+		key, value := f[:i], f[i+1:]
+		var register *byte
+		switch key {
+		case "A":
+			register = &state.A
+		case "X":
+			register = &state.X
+		case "Y":
+			register = &state.Y
+		case "P":
+			register = &state.P
+		case "SP":
+			register = &state.SP
+		case "PPU":
+			if _, err := fmt.Sscanf(value, "%d,%d", &state.ScanLine, &state.Dot); err != nil {
+				return state, malformed
+			}
+		case "CYC":
+			if state.Cycles, err = strconv.ParseUint(value, 10, 64); err != nil {
+				return state, malformed
+			}
+		}
+		if register != nil {
+			n, err := strconv.ParseUint(value, 16, 8)
+			if err != nil {
+				return state, malformed
+			}
+			*register = byte(n)
+		}
+	}
+	return state, nil
+}
@@ -0,0 +1,125 @@
+package device6502
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatTrace(t *testing.T) {
+	mem := make([]byte, 0x10000)
+	copy(mem[0xC000:], []byte{0x4C, 0xF5, 0xC5})
+	copy(mem[0xC5F5:], []byte{0xA2, 0x00, 0x86, 0x00})
+	copy(mem[0xD000:], []byte{0xB1, 0x89, 0x04, 0x80})
+	mem[0x89] = 0x00
+	mem[0x8A] = 0x03
+	mem[0x0300] = 0x89
+	tests := []struct {
+		state traceState
+		want  string
+	}{
+		{
+			traceState{PC: 0xC000, P: 0x24, SP: 0xFD, ScanLine: 0, Dot: 21, Cycles: 7},
+			"C000  4C F5 C5  JMP $C5F5                       A:00 X:00 Y:00 P:24 SP:FD PPU:  0, 21 CYC:7",
+		},
+		{
+			traceState{PC: 0xC5F5, P: 0x24, SP: 0xFD, ScanLine: 0, Dot: 30, Cycles: 10},
+			"C5F5  A2 00     LDX #$00                        A:00 X:00 Y:00 P:24 SP:FD PPU:  0, 30 CYC:10",
+		},
+		{
+			traceState{PC: 0xC5F7, P: 0x26, SP: 0xFD, ScanLine: 0, Dot: 39, Cycles: 13},
+			"C5F7  86 00     STX $00 = 00                    A:00 X:00 Y:00 P:26 SP:FD PPU:  0, 39 CYC:13",
+		},
+		{
+			traceState{PC: 0xD000, A: 0x01, P: 0x24, SP: 0xFB, ScanLine: 12, Dot: 140, Cycles: 4000},
+			"D000  B1 89     LDA ($89),Y = 0300 @ 0300 = 89  A:01 X:00 Y:00 P:24 SP:FB PPU: 12,140 CYC:4000",
+		},
+		{
+			traceState{PC: 0xD002, P: 0x24, SP: 0xFB, ScanLine: 12, Dot: 149, Cycles: 4003},
+			"D002  04 80    *NOP $80 = 00                    A:00 X:00 Y:00 P:24 SP:FB PPU: 12,149 CYC:4003",
+		},
+	}
+	for _, tc := range tests {
+		got := formatTrace(func(address uint16) byte { return mem[address] }, tc.state)
+		if got != tc.want {
+			t.Errorf("formatTrace:\n got %q\nwant %q", got, tc.want)
+		}
+		parsed, err := parseTrace(tc.want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed != tc.state {
+			t.Errorf("parseTrace = %+v, want %+v", parsed, tc.state)
+		}
+	}
+}
+
+func TestTraceCompareStopsAtDivergence(t *testing.T) {
+	reference := strings.Join([]string{
+		"C000  4C F5 C5  JMP $C5F5                       A:00 X:00 Y:00 P:24 SP:FD PPU:  0, 21 CYC:7",
+		"C5F5  A2 00     LDX #$00                        A:00 X:00 Y:00 P:24 SP:FD PPU:  0, 30 CYC:10",
+		"C5F7  86 00     STX $00 = 00                    A:00 X:00 Y:00 P:26 SP:FD PPU:  0, 39 CYC:13",
+	}, "\n")
+	tracer := NewTracer(nil)
+	tracer.Compare(strings.NewReader(reference))
+	// the live run starts at a different cycle count and PPU position, which
+	// is allowed
+	states := []traceState{
+		{PC: 0xC000, P: 0x24, SP: 0xFD, ScanLine: 261, Dot: 340, Cycles: 0},
+		{PC: 0xC5F5, P: 0x24, SP: 0xFD, ScanLine: 0, Dot: 8, Cycles: 3},
+		{PC: 0xC5F7, P: 0x24, SP: 0xFD, ScanLine: 0, Dot: 17, Cycles: 6},
+	}
+	for _, state := range states {
+		tracer.line++
+		tracer.compare(state, "")
+	}
+	mismatch, ok := tracer.Err().(*TraceMismatch)
+	if !ok {
+		t.Fatalf("Err() = %v, want *TraceMismatch", tracer.Err())
+	}
+	if mismatch.Line != 3 || mismatch.Field != "P" {
+		t.Errorf("mismatch at line %d field %s, want line 3 field P", mismatch.Line, mismatch.Field)
+	}
+}
+
+func TestTraceComparePPU(t *testing.T) {
+	reference := strings.Join([]string{
+		"C000  4C F5 C5  JMP $C5F5                       A:00 X:00 Y:00 P:24 SP:FD PPU:  0, 21 CYC:7",
+		"C5F5  A2 00     LDX #$00                        A:00 X:00 Y:00 P:24 SP:FD PPU:  0, 30 CYC:10",
+		"C5F7  86 00     STX $00 = 00                    A:00 X:00 Y:00 P:26 SP:FD CYC:13",
+		"C5F9  86 00     STX $00 = 00                    A:00 X:00 Y:00 P:26 SP:FD PPU:  0, 48 CYC:16",
+	}, "\n")
+	tracer := NewTracer(nil)
+	tracer.Compare(strings.NewReader(reference))
+	// the CPU matches throughout, but the PPU runs a dot late from line 2;
+	// line 3 gives no PPU position to check
+	states := []traceState{
+		{PC: 0xC000, P: 0x24, SP: 0xFD, Dot: 21, Cycles: 7},
+		{PC: 0xC5F5, P: 0x24, SP: 0xFD, Dot: 31, Cycles: 10},
+		{PC: 0xC5F7, P: 0x26, SP: 0xFD, Dot: 40, Cycles: 13},
+		{PC: 0xC5F9, P: 0x26, SP: 0xFD, Dot: 49, Cycles: 16},
+	}
+	for _, state := range states {
+		tracer.line++
+		tracer.compare(state, "")
+		if tracer.Err() != nil {
+			break
+		}
+	}
+	mismatch, ok := tracer.Err().(*TraceMismatch)
+	if !ok {
+		t.Fatalf("Err() = %v, want *TraceMismatch", tracer.Err())
+	}
+	if mismatch.Line != 2 || mismatch.Field != "PPU" {
+		t.Errorf("mismatch at line %d field %s, want line 2 field PPU", mismatch.Line, mismatch.Field)
+	}
+
+	tracer = NewTracer(nil)
+	tracer.Compare(strings.NewReader(reference))
+	for _, state := range []traceState{states[0], {PC: 0xC5F5, P: 0x24, SP: 0xFD, Dot: 30, Cycles: 10}, states[2]} {
+		tracer.line++
+		tracer.compare(state, "")
+	}
+	if err := tracer.Err(); err != nil {
+		t.Errorf("line without a PPU position: %v", err)
+	}
+}
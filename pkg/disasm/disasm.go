@@ -0,0 +1,191 @@
+package disasm
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/se-nonide/go6502/pkg/cpu6502"
+)
+
+// Reader is the read half of cpu6502.Bus. Decoding from a live bus
+// should go through a side-effect free reader such as Device.Peek.
+type Reader interface {
+	Read(address uint16) byte
+}
+
+// ReaderFunc adapts a function such as Device.Peek to a Reader.
+type ReaderFunc func(address uint16) byte
+
+func (f ReaderFunc) Read(address uint16) byte {
+	return f(address)
+}
+
+// Symbols maps addresses to labels used in place of raw operands.
+type Symbols map[uint16]string
+
+type Instruction struct {
+	Address uint16
+	Bytes   []byte
+	cpu6502.Opcode
+	Operand uint16 // raw operand, one or two bytes
+	Target  uint16 // resolved destination of branches, JMP and JSR
+	// HasTarget is set when Target holds a statically known destination.
+	HasTarget bool
+}
+
+// Decode decodes the instruction at the start of code, which is located at
+// address. Missing operand bytes past the end of code read as zero.
+func Decode(code []byte, address uint16) Instruction {
+	return DecodeMemory(bytesReader{code, address}, address)
+}
+
+// DecodeMemory decodes the instruction at address.
+func DecodeMemory(mem Reader, address uint16) Instruction {
+	opcode := mem.Read(address)
+	info := cpu6502.LookupOpcode(opcode)
+	inst := Instruction{Address: address, Opcode: info}
+	inst.Bytes = make([]byte, info.Size)
+	for i := range inst.Bytes {
+		inst.Bytes[i] = mem.Read(address + uint16(i))
+	}
+	switch info.Size {
+	case 2:
+		inst.Operand = uint16(inst.Bytes[1])
+	case 3:
+		inst.Operand = uint16(inst.Bytes[2])<<8 | uint16(inst.Bytes[1])
+	}
+	switch {
+	case info.Mode == cpu6502.ModeRelative:
+		inst.Target = address + 2 + uint16(int8(inst.Operand))
+		inst.HasTarget = true
+	case info.Mode == cpu6502.ModeAbsolute && (info.Name == "JMP" || info.Name == "JSR"):
+		inst.Target = inst.Operand
+		inst.HasTarget = true
+	}
+	return inst
+}
+
+// Disassemble decodes code linearly, starting at origin.
+func Disassemble(code []byte, origin uint16) []Instruction {
+	var result []Instruction
+	for offset := 0; offset < len(code); {
+		inst := Decode(code[offset:], origin+uint16(offset))
+		result = append(result, inst)
+		offset += len(inst.Bytes)
+	}
+	return result
+}
+
+// String formats the instruction without symbols, e.g. "LDA $0300,X".
+func (inst Instruction) String() string {
+	return inst.Format(nil)
+}
+
+// Format formats the instruction in standard assembler syntax, replacing
+// operands that match a symbol with its label.
+func (inst Instruction) Format(symbols Symbols) string {
+	name := inst.Name
+	if !inst.Official {
+		name = "*" + name
+	}
+	zp := func() string {
+		if label, ok := symbols[inst.Operand]; ok {
+			return label
+		}
+		return fmt.Sprintf("$%02X", inst.Operand)
+	}
+	abs := func(address uint16) string {
+		if label, ok := symbols[address]; ok {
+			return label
+		}
+		return fmt.Sprintf("$%04X", address)
+	}
+	switch inst.Mode {
+	case cpu6502.ModeAbsolute:
+		return name + " " + abs(inst.Operand)
+	case cpu6502.ModeAbsoluteX:
+		return name + " " + abs(inst.Operand) + ",X"
+	case cpu6502.ModeAbsoluteY:
+		return name + " " + abs(inst.Operand) + ",Y"
+	case cpu6502.ModeAccumulator:
+		return name + " A"
+	case cpu6502.ModeImmediate:
+		return fmt.Sprintf("%s #$%02X", name, inst.Operand)
+	case cpu6502.ModeIndexedIndirect:
+		return name + " (" + zp() + ",X)"
+	case cpu6502.ModeIndirect:
+		return name + " (" + abs(inst.Operand) + ")"
+	case cpu6502.ModeIndirectIndexed:
+		return name + " (" + zp() + "),Y"
+	case cpu6502.ModeRelative:
+		return name + " " + abs(inst.Target)
+	case cpu6502.ModeZeroPage:
+		return name + " " + zp()
+	case cpu6502.ModeZeroPageX:
+		return name + " " + zp() + ",X"
+	case cpu6502.ModeZeroPageY:
+		return name + " " + zp() + ",Y"
+	}
+	return name
+}
+
+// Fprint writes a listing with addresses, raw bytes and labels, e.g.
+//
+//	reset:
+//	C000  4C F5 C5  JMP main
+func Fprint(w io.Writer, instructions []Instruction, symbols Symbols) error {
+	for _, inst := range instructions {
+		if label, ok := symbols[inst.Address]; ok {
+			if _, err := fmt.Fprintf(w, "%s:\n", label); err != nil {
+				return err
+			}
+		}
+		bytes := make([]string, len(inst.Bytes))
+		for i, b := range inst.Bytes {
+			bytes[i] = fmt.Sprintf("%02X", b)
+		}
+		_, err := fmt.Fprintf(w, "%04X  %-8s  %s\n",
+			inst.Address, strings.Join(bytes, " "), inst.Format(symbols))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type bytesReader struct {
+	code   []byte
+	origin uint16
+}
+
+func (r bytesReader) Read(address uint16) byte {
+	offset := int(address - r.origin)
+	if offset >= len(r.code) {
+		return 0
+	}
+	return r.code[offset]
+}
+
+// ParseSymbols reads labels in the form "C000 reset" or "$C000 reset", one
+// per line. Blank lines and lines starting with ';' or '#' are ignored.
+func ParseSymbols(r io.Reader) (Symbols, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	symbols := Symbols{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+		var address uint16
+		var label string
+		if _, err := fmt.Sscanf(strings.TrimPrefix(line, "$"), "%x %s", &address, &label); err != nil {
+			return nil, fmt.Errorf("symbols line %d: %v", i+1, err)
+		}
+		symbols[address] = label
+	}
+	return symbols, nil
+}
@@ -0,0 +1,68 @@
+package disasm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFormatAddressingModes(t *testing.T) {
+	tests := []struct {
+		code []byte
+		want string
+	}{
+		{[]byte{0xEA}, "NOP"},
+		{[]byte{0x0A}, "ASL A"},
+		{[]byte{0xA9, 0x10}, "LDA #$10"},
+		{[]byte{0xA5, 0x10}, "LDA $10"},
+		{[]byte{0xB5, 0x10}, "LDA $10,X"},
+		{[]byte{0xB6, 0x10}, "LDX $10,Y"},
+		{[]byte{0xAD, 0x34, 0x12}, "LDA $1234"},
+		{[]byte{0xBD, 0x34, 0x12}, "LDA $1234,X"},
+		{[]byte{0xB9, 0x34, 0x12}, "LDA $1234,Y"},
+		{[]byte{0xA1, 0x10}, "LDA ($10,X)"},
+		{[]byte{0xB1, 0x10}, "LDA ($10),Y"},
+		{[]byte{0x6C, 0xFC, 0xFF}, "JMP ($FFFC)"},
+		{[]byte{0xD0, 0xFE}, "BNE $C000"},
+		{[]byte{0x10, 0x10}, "BPL $C012"},
+		{[]byte{0xA7, 0x10}, "*LAX $10"},
+		{[]byte{0x02}, "*KIL"},
+	}
+	for _, tc := range tests {
+		inst := Decode(tc.code, 0xC000)
+		if got := inst.String(); got != tc.want {
+			t.Errorf("% X: got %q, want %q", tc.code, got, tc.want)
+		}
+		if len(inst.Bytes) != len(tc.code) {
+			t.Errorf("% X: decoded %d bytes, want %d", tc.code, len(inst.Bytes), len(tc.code))
+		}
+	}
+}
+
+func TestListingWithSymbols(t *testing.T) {
+	code := []byte{
+		0x20, 0x06, 0xC0, // JSR init
+		0x4C, 0x03, 0xC0, // JMP forever
+		0xA5, 0x10, //       LDA counter
+		0x60, //             RTS
+	}
+	symbols, err := ParseSymbols(strings.NewReader("; labels\nC000 reset\n$C003 forever\nC006 init\n0010 counter\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := Fprint(&out, Disassemble(code, 0xC000), symbols); err != nil {
+		t.Fatal(err)
+	}
+	want := `reset:
+C000  20 06 C0  JSR init
+forever:
+C003  4C 03 C0  JMP forever
+init:
+C006  A5 10     LDA counter
+C008  60        RTS
+`
+	if out.String() != want {
+		t.Errorf("listing:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
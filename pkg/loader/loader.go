@@ -1,35 +1,149 @@
 package loader
 
 import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
 	"encoding/binary"
 	"errors"
 	"io"
-	"os"
+	"log"
+	"path"
+	"strings"
 
 	"github.com/se-nonide/go6502/pkg/cartridge"
 )
 
 const iNESFileMagic = 0x1a53454e
 
+// mirrorFour is device6502.MirrorFour: the board carries the RAM for the
+// third and fourth nametables
+const mirrorFour = 4
+
+// container signatures
+var (
+	zipMagic  = []byte{'P', 'K', 3, 4}
+	gzipMagic = []byte{0x1F, 0x8B}
+)
+
+// maxROMSize bounds the PRG and CHR sizes a header can ask for
+const maxROMSize = 64 << 20
+
+// header formats, told apart by byte 7 and the end of the header
+const (
+	formatINES    = iota
+	formatNES20   // sizes, submapper, RAM and console fields in bytes 8-15
+	formatArchaic // bytes 7-15 hold garbage such as "DiskDude!"
+)
+
 type iNESFileHeader struct {
 	Magic    uint32  // iNES magic number
 	NumPRG   byte    // number of PRG-ROM banks (16KB each)
 	NumCHR   byte    // number of CHR-ROM banks (8KB each)
 	Control1 byte    // control bits
 	Control2 byte    // control bits
-	NumRAM   byte    // PRG-RAM size (x 8KB)
-	_        [7]byte // unused padding
+	NumRAM   byte    // PRG-RAM size (x 8KB), or NES 2.0 mapper MSB and submapper
+	Extra    [7]byte // bytes 9-15: TV system, NES 2.0 fields or padding
 }
 
+// LoadNESFile loads a .nes file, or a .zip or .gz archive holding one, as
+// it is on disk. Patches are only applied through LoadNESFilePatched.
 func LoadNESFile(path string) (*cartridge.Cartridge, error) {
-	file, err := os.Open(path)
+	return LoadNESFilePatched(path, nil)
+}
+
+// LoadBytes loads a ROM image held in memory, which may be a .zip or .gz
+// archive.
+func LoadBytes(data []byte) (*cartridge.Cartridge, error) {
+	return LoadReaderAt(bytes.NewReader(data), int64(len(data)))
+}
+
+// LoadReaderAt loads a ROM image of the given size, unpacking it first when
+// it is a .zip or .gz archive.
+func LoadReaderAt(r io.ReaderAt, size int64) (*cartridge.Cartridge, error) {
+	rom, err := ReadImage(r, size)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
+	return Load(bytes.NewReader(rom))
+}
+
+// ReadImage returns the raw ROM image, unpacked from its .zip or .gz
+// archive if it is in one, ready for patching or Load.
+func ReadImage(r io.ReaderAt, size int64) ([]byte, error) {
+	magic := make([]byte, 4)
+	if _, err := r.ReadAt(magic, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.Equal(magic, zipMagic):
+		return readZip(r, size)
+	case bytes.Equal(magic[:2], gzipMagic):
+		reader, err := gzip.NewReader(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return io.ReadAll(reader)
+	}
+	return io.ReadAll(io.NewSectionReader(r, 0, size))
+}
 
+// readZip reads the ROM in a zip archive.
+func readZip(r io.ReaderAt, size int64) ([]byte, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	rom := findROM(archive.File)
+	if rom == nil {
+		return nil, errors.New("no .nes file in zip archive")
+	}
+	if len(archive.File) > 1 {
+		log.Printf("Loading %s from zip archive", rom.Name)
+	}
+	reader, err := rom.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// findROM picks the ROM among the files of an archive: the first one named
+// .nes, or failing that the first one that starts like a ROM.
+func findROM(files []*zip.File) *zip.File {
+	for _, file := range files {
+		if strings.EqualFold(path.Ext(file.Name), ".nes") {
+			return file
+		}
+	}
+	for _, file := range files {
+		if isROM(file) {
+			return file
+		}
+	}
+	return nil
+}
+
+// isROM tells whether an archive entry starts with the iNES magic number.
+func isROM(file *zip.File) bool {
+	reader, err := file.Open()
+	if err != nil {
+		return false
+	}
+	defer reader.Close()
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(reader, magic); err != nil {
+		return false
+	}
+	return binary.LittleEndian.Uint32(magic) == iNESFileMagic
+}
+
+// Load reads a raw iNES or NES 2.0 ROM image from a stream.
+func Load(r io.Reader) (*cartridge.Cartridge, error) {
 	header := iNESFileHeader{}
-	if err := binary.Read(file, binary.LittleEndian, &header); err != nil {
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
 		return nil, err
 	}
 
@@ -37,36 +151,153 @@ func LoadNESFile(path string) (*cartridge.Cartridge, error) {
 		return nil, errors.New("invalid .device6502 file")
 	}
 
+	format := headerFormat(&header)
+	if format == formatArchaic {
+		log.Print("Ignoring garbage in header bytes 7-15")
+		header.Control2, header.NumRAM, header.Extra = 0, 0, [7]byte{}
+	}
+
 	mapper1 := header.Control1 >> 4
 	mapper2 := header.Control2 >> 4
-	mapper := mapper1 | mapper2<<4
+	mapper := uint16(mapper1) | uint16(mapper2)<<4
 
-	mirror1 := header.Control1 & 1
-	mirror2 := (header.Control1 >> 3) & 1
-	mirror := mirror1 | mirror2<<1
+	mirror := header.Control1 & 1
+	fourScreen := header.Control1&8 == 8
+	if fourScreen {
+		mirror = mirrorFour
+	}
 
 	battery := (header.Control1 >> 1) & 1
 
+	prgSize := int(header.NumPRG) * 16384
+	chrSize := int(header.NumCHR) * 8192
+	if format == formatNES20 {
+		mapper |= uint16(header.NumRAM&0x0F) << 8
+		prgSize = romSize(header.NumPRG, header.Extra[0]&0x0F, 16384)
+		chrSize = romSize(header.NumCHR, header.Extra[0]>>4, 8192)
+	}
+	if prgSize > maxROMSize || chrSize > maxROMSize {
+		return nil, errors.New("ROM size in header is too large")
+	}
+
 	if header.Control1&4 == 4 {
 		trainer := make([]byte, 512)
-		if _, err := io.ReadFull(file, trainer); err != nil {
+		if _, err := io.ReadFull(r, trainer); err != nil {
 			return nil, err
 		}
 	}
 
-	prg := make([]byte, int(header.NumPRG)*16384)
-	if _, err := io.ReadFull(file, prg); err != nil {
+	prg := make([]byte, prgSize)
+	if _, err := io.ReadFull(r, prg); err != nil {
 		return nil, err
 	}
 
-	chr := make([]byte, int(header.NumCHR)*8192)
-	if _, err := io.ReadFull(file, chr); err != nil {
+	chr := make([]byte, chrSize)
+	if _, err := io.ReadFull(r, chr); err != nil {
 		return nil, err
 	}
 
-	if header.NumCHR == 0 {
-		chr = make([]byte, 8192)
+	c := cartridge.NewCartridge(prg, chr, mapper, mirror, battery)
+	c.Timing = timing(&header, format)
+	if fourScreen {
+		c.VRAM = make([]byte, 0x800)
+	}
+	if format == formatNES20 {
+		readNES20(c, &header)
+	} else {
+		readINES(c, &header)
+	}
+	return c, nil
+}
+
+// headerFormat follows the detection recommended on the NESdev wiki: byte 7
+// marks NES 2.0, and an iNES header must end in zeros.
+func headerFormat(header *iNESFileHeader) int {
+	switch header.Control2 & 0x0C {
+	case 0x08:
+		return formatNES20
+	case 0x04:
+		return formatArchaic
+	}
+	for _, b := range header.Extra[3:] {
+		if b != 0 {
+			return formatArchaic
+		}
+	}
+	return formatINES
+}
+
+// readINES fills in the few extra fields of iNES 1.0 headers.
+func readINES(c *cartridge.Cartridge, header *iNESFileHeader) {
+	switch {
+	case header.Control2&1 == 1:
+		c.Console = cartridge.ConsoleVs
+	case header.Control2&2 == 2:
+		c.Console = cartridge.ConsolePlaychoice
 	}
+	// byte 8 counts 8KB units, with 0 meaning 8KB for compatibility
+	if header.NumRAM > 1 {
+		size := int(header.NumRAM) * 0x2000
+		if c.Battery == 1 {
+			c.SetRAMSizes(0, size, c.CHRRAMSize, 0)
+		} else {
+			c.SetRAMSizes(size, 0, c.CHRRAMSize, 0)
+		}
+	}
+}
 
-	return cartridge.NewCartridge(prg, chr, mapper, mirror, battery), nil
+// readNES20 fills in the submapper, RAM sizes, console and expansion device
+// of NES 2.0 headers.
+func readNES20(c *cartridge.Cartridge, header *iNESFileHeader) {
+	c.NES20 = true
+	c.Submapper = header.NumRAM >> 4
+	ram, chrRAM := header.Extra[1], header.Extra[2]
+	c.SetRAMSizes(shiftSize(ram&0x0F), shiftSize(ram>>4),
+		shiftSize(chrRAM&0x0F), shiftSize(chrRAM>>4))
+	switch header.Control2 & 3 {
+	case cartridge.ConsoleVs:
+		c.Console = cartridge.ConsoleVs
+		c.VsPPU = header.Extra[4] & 0x0F
+		c.VsHardware = header.Extra[4] >> 4
+	case cartridge.ConsolePlaychoice:
+		c.Console = cartridge.ConsolePlaychoice
+	case 3:
+		c.Console = header.Extra[4] & 0x0F
+	}
+	c.Expansion = header.Extra[6] & 0x3F
+}
+
+// romSize decodes an NES 2.0 ROM size from its LSB and MSB nibble. An MSB
+// of $F switches to exponent-multiplier notation for sizes that are not a
+// multiple of the bank size.
+func romSize(lsb, msb byte, bank int) int {
+	if msb != 0x0F {
+		return (int(msb)<<8 | int(lsb)) * bank
+	}
+	exponent := uint(lsb >> 2)
+	if exponent > 26 {
+		return maxROMSize + 1
+	}
+	return (1 << exponent) * (int(lsb&3)*2 + 1)
+}
+
+// shiftSize decodes an NES 2.0 RAM size, 64 bytes shifted left by the
+// nibble, where 0 means none.
+func shiftSize(shift byte) int {
+	if shift == 0 {
+		return 0
+	}
+	return 64 << shift
+}
+
+// timing reads the TV system from byte 12 of NES 2.0 headers, or from the
+// rarely set PAL bit in byte 9 of iNES ones.
+func timing(header *iNESFileHeader, format int) byte {
+	if format == formatNES20 {
+		return header.Extra[3] & 3
+	}
+	if header.Extra[0]&1 == 1 {
+		return cartridge.TimingPAL
+	}
+	return cartridge.TimingNTSC
 }
@@ -0,0 +1,216 @@
+package loader
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/se-nonide/go6502/internal/nesrom"
+	"github.com/se-nonide/go6502/pkg/cartridge"
+)
+
+// writeROM writes a file with the given header bytes 4-15, followed by the
+// PRG and CHR sizes they describe when prg and chr are given.
+func writeROM(t *testing.T, header []byte, prg, chr int) string {
+	path, err := nesrom.Write(t.TempDir(), nesrom.Image(header, prg, chr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadNESFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   []byte
+		prg, chr int
+		want     cartridge.Cartridge
+	}{
+		{
+			"iNES", []byte{2, 1, 0x41, 0x10}, 0x8000, 0x2000,
+			cartridge.Cartridge{Mapper: 0x14, Mirror: 1, PRGRAMSize: 0x2000},
+		},
+		{
+			"iNES CHR-RAM and battery", []byte{1, 0, 0x12}, 0x4000, 0,
+			cartridge.Cartridge{Mapper: 1, Battery: 1, PRGNVRAMSize: 0x2000, CHRRAMSize: 0x2000},
+		},
+		{
+			"iNES RAM size", []byte{1, 1, 0, 0, 4}, 0x4000, 0x2000,
+			cartridge.Cartridge{PRGRAMSize: 0x8000},
+		},
+		{
+			"iNES Vs. System", []byte{1, 1, 0, 0x01}, 0x4000, 0x2000,
+			cartridge.Cartridge{PRGRAMSize: 0x2000, Console: cartridge.ConsoleVs},
+		},
+		{
+			"DiskDude!", []byte{1, 1, 0x40, 'D', 'i', 's', 'k', 'D', 'u', 'd', 'e', '!'}, 0x4000, 0x2000,
+			cartridge.Cartridge{Mapper: 4, PRGRAMSize: 0x2000},
+		},
+		{
+			"junk at the end", []byte{1, 1, 0x10, 0x40, 0, 1, 0, 0, 0, 'x'}, 0x4000, 0x2000,
+			cartridge.Cartridge{Mapper: 1, PRGRAMSize: 0x2000},
+		},
+		{
+			"NES 2.0", []byte{2, 0, 0x52, 0x08 | 0x30, 0x21, 0, 0x70, 0x09, 1, 0, 0, 0x2A}, 0x8000, 0,
+			cartridge.Cartridge{
+				Mapper: 0x135, Submapper: 2, Battery: 1, PRGNVRAMSize: 0x2000,
+				CHRRAMSize: 0x8000, Timing: cartridge.TimingPAL, Expansion: 0x2A, NES20: true,
+			},
+		},
+		{
+			"NES 2.0 Vs. System", []byte{1, 1, 0, 0x09, 0, 0, 0x07, 0, 0, 0x23}, 0x4000, 0x2000,
+			cartridge.Cartridge{
+				PRGRAMSize: 0x2000, Console: cartridge.ConsoleVs, VsPPU: 3, VsHardware: 2, NES20: true,
+			},
+		},
+		{
+			"NES 2.0 extended console", []byte{1, 1, 0, 0x0B, 0, 0, 0, 0, 0, 0x05}, 0x4000, 0x2000,
+			cartridge.Cartridge{Console: 5, NES20: true},
+		},
+		{
+			"NES 2.0 exponent size", []byte{0x41, 1, 0, 0x08, 0, 0x0F}, 0x30000, 0x2000,
+			cartridge.Cartridge{NES20: true},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := LoadNESFile(writeROM(t, test.header, test.prg, test.chr))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := test.want
+			if len(c.PRG) != test.prg {
+				t.Errorf("PRG is %d bytes, want %d", len(c.PRG), test.prg)
+			}
+			chr := test.chr
+			if chr == 0 {
+				chr = want.CHRRAMSize + want.CHRNVRAMSize
+			}
+			if len(c.CHR) != chr {
+				t.Errorf("CHR is %d bytes, want %d", len(c.CHR), chr)
+			}
+			if len(c.SRAM) != want.PRGRAMSize+want.PRGNVRAMSize {
+				t.Errorf("SRAM is %d bytes, want %d", len(c.SRAM), want.PRGRAMSize+want.PRGNVRAMSize)
+			}
+			c.PRG, c.CHR, c.SRAM = nil, nil, nil
+			if !reflect.DeepEqual(*c, want) {
+				t.Errorf("got %+v\nwant %+v", *c, want)
+			}
+		})
+	}
+}
+
+func TestLoadNESFileErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+	}{
+		{"truncated", []byte{2, 1}},
+		{"huge exponent size", []byte{0xFC, 0, 0, 0x08, 0, 0x0F}},
+	}
+	for _, test := range tests {
+		if _, err := LoadNESFile(writeROM(t, test.header, 0x4000, 0)); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+}
+
+func TestSRAMMirroring(t *testing.T) {
+	c, err := LoadNESFile(writeROM(t, []byte{1, 1, 0, 0x08, 0, 0, 0x01}, 0x4000, 0x2000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.SRAM) != 128 {
+		t.Fatalf("SRAM is %d bytes, want 128", len(c.SRAM))
+	}
+	c.WriteSRAM(0x6005, 0x42)
+	if got := c.ReadSRAM(0x6085); got != 0x42 {
+		t.Errorf("mirrored read = $%02X, want $42", got)
+	}
+
+	c, err = LoadNESFile(writeROM(t, []byte{1, 1, 0, 0x08}, 0x4000, 0x2000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.WriteSRAM(0x7123, 0x42)
+	if got := c.ReadSRAM(0x7123); got != 0x71 {
+		t.Errorf("read without RAM = $%02X, want open bus $71", got)
+	}
+}
+
+func TestLoadArchives(t *testing.T) {
+	rom := nesrom.Image([]byte{1, 1, 0x10}, 0x4000, 0x2000)
+	rom[16] = 0x42
+
+	var zipped bytes.Buffer
+	archive := zip.NewWriter(&zipped)
+	for _, file := range []struct {
+		name string
+		data []byte
+	}{
+		{"readme.txt", []byte("not a ROM")},
+		{"game.NES", rom},
+		{"other.nes", []byte("second ROM")},
+	} {
+		w, err := archive.Create(file.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(file.data)
+	}
+	archive.Close()
+
+	var unnamed bytes.Buffer
+	archive = zip.NewWriter(&unnamed)
+	w, _ := archive.Create("notes")
+	w.Write([]byte("not a ROM"))
+	w, _ = archive.Create("game")
+	w.Write(rom)
+	archive.Close()
+
+	var gzipped bytes.Buffer
+	compressor := gzip.NewWriter(&gzipped)
+	compressor.Write(rom)
+	compressor.Close()
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"raw", rom},
+		{"zip", zipped.Bytes()},
+		{"zip without .nes names", unnamed.Bytes()},
+		{"gzip", gzipped.Bytes()},
+	}
+	for _, test := range tests {
+		c, err := LoadBytes(test.data)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if c.Mapper != 1 || len(c.PRG) != 0x4000 || c.PRG[0] != 0x42 {
+			t.Errorf("%s: loaded mapper %d with %d bytes of PRG", test.name, c.Mapper, len(c.PRG))
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "game.zip")
+	if err := os.WriteFile(path, zipped.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadNESFile(path); err != nil {
+		t.Errorf("LoadNESFile: %v", err)
+	}
+
+	var empty bytes.Buffer
+	archive = zip.NewWriter(&empty)
+	w, _ = archive.Create("readme.txt")
+	w.Write([]byte("not a ROM"))
+	archive.Close()
+	if _, err := LoadBytes(empty.Bytes()); err == nil {
+		t.Error("zip without a ROM: no error")
+	}
+}
@@ -0,0 +1,334 @@
+package loader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/se-nonide/go6502/pkg/cartridge"
+)
+
+// PatchExtensions are the patch files FindPatches looks for next to a ROM,
+// in the order they are applied.
+var PatchExtensions = []string{".ips", ".ups", ".bps"}
+
+var (
+	ipsMagic = []byte("PATCH")
+	ipsEOF   = []byte("EOF")
+	upsMagic = []byte("UPS1")
+	bpsMagic = []byte("BPS1")
+)
+
+var errTruncated = errors.New("patch is truncated")
+
+// FindPatches returns the patches sharing the ROM's name, game.ips for
+// game.nes, game.zip or game.nes.gz, that exist on disk.
+func FindPatches(path string) []string {
+	base := BaseName(path)
+	var patches []string
+	for _, ext := range PatchExtensions {
+		if _, err := os.Stat(base + ext); err == nil {
+			patches = append(patches, base+ext)
+		}
+	}
+	return patches
+}
+
+// BaseName drops the ROM and archive extensions from a path, giving dir/game
+// for dir/game.nes.gz.
+func BaseName(path string) string {
+	for {
+		ext := filepath.Ext(path)
+		switch strings.ToLower(ext) {
+		case ".nes", ".zip", ".gz":
+			path = strings.TrimSuffix(path, ext)
+		default:
+			return path
+		}
+	}
+}
+
+// LoadNESFilePatched loads a ROM file like LoadNESFile, applying the given
+// patch files to the image in order. The files on disk are not modified.
+func LoadNESFilePatched(path string, patches []string) (*cartridge.Cartridge, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	rom, err := ReadImage(file, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range patches {
+		patch, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		if rom, err = ApplyPatch(rom, patch); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		log.Printf("Applied patch %s", name)
+	}
+	return Load(bytes.NewReader(rom))
+}
+
+// ApplyPatch applies an IPS, UPS or BPS patch to a ROM image and returns
+// the patched copy. UPS and BPS checksums of the source, result and patch
+// are checked.
+func ApplyPatch(rom, patch []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(patch, ipsMagic):
+		return applyIPS(rom, patch)
+	case bytes.HasPrefix(patch, upsMagic):
+		return applyUPS(rom, patch)
+	case bytes.HasPrefix(patch, bpsMagic):
+		return applyBPS(rom, patch)
+	}
+	return nil, errors.New("unknown patch format")
+}
+
+// applyIPS applies records of a 24-bit offset and 16-bit length, where a
+// zero length marks a run of one repeated byte. An optional 24-bit size
+// after the EOF marker truncates the result.
+func applyIPS(rom, patch []byte) ([]byte, error) {
+	out := append([]byte(nil), rom...)
+	p := patch[len(ipsMagic):]
+	for {
+		if len(p) < 3 {
+			return nil, errTruncated
+		}
+		if bytes.Equal(p[:3], ipsEOF) {
+			p = p[3:]
+			break
+		}
+		if len(p) < 5 {
+			return nil, errTruncated
+		}
+		offset := int(p[0])<<16 | int(p[1])<<8 | int(p[2])
+		size := int(binary.BigEndian.Uint16(p[3:]))
+		p = p[5:]
+		var data []byte
+		if size == 0 {
+			if len(p) < 3 {
+				return nil, errTruncated
+			}
+			size = int(binary.BigEndian.Uint16(p))
+			data = bytes.Repeat(p[2:3], size)
+			p = p[3:]
+		} else {
+			if len(p) < size {
+				return nil, errTruncated
+			}
+			data = p[:size]
+			p = p[size:]
+		}
+		if end := offset + size; end > len(out) {
+			out = append(out, make([]byte, end-len(out))...)
+		}
+		copy(out[offset:], data)
+	}
+	if len(p) >= 3 {
+		size := int(p[0])<<16 | int(p[1])<<8 | int(p[2])
+		if size < len(out) {
+			out = out[:size]
+		}
+	}
+	return out, nil
+}
+
+// applyUPS XORs runs of bytes into the ROM, each run ending in a zero byte
+// and following a gap encoded as a varint.
+func applyUPS(rom, patch []byte) ([]byte, error) {
+	r, err := newPatchReader(patch, upsMagic)
+	if err != nil {
+		return nil, err
+	}
+	sourceSize := r.varint()
+	targetSize := r.varint()
+	if r.err != nil {
+		return nil, r.err
+	}
+	if err := r.checkSizes(rom, sourceSize, targetSize); err != nil {
+		return nil, err
+	}
+	out := make([]byte, targetSize)
+	copy(out, rom)
+	position := 0
+	for r.err == nil && r.offset < r.end {
+		position += r.varint()
+		for r.err == nil {
+			x := r.byte()
+			position++
+			if x == 0 {
+				break
+			}
+			if position-1 < len(out) {
+				out[position-1] ^= x
+			}
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return out, r.checkTarget(out)
+}
+
+// applyBPS runs the copy commands of a BPS patch: from the source at the
+// same position, literal bytes from the patch, and relative copies from the
+// source or from the output so far.
+func applyBPS(rom, patch []byte) ([]byte, error) {
+	r, err := newPatchReader(patch, bpsMagic)
+	if err != nil {
+		return nil, err
+	}
+	sourceSize := r.varint()
+	targetSize := r.varint()
+	r.skip(r.varint()) // metadata
+	if r.err != nil {
+		return nil, r.err
+	}
+	if err := r.checkSizes(rom, sourceSize, targetSize); err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, targetSize)
+	sourceOffset, targetOffset := 0, 0
+	for r.err == nil && r.offset < r.end {
+		data := r.varint()
+		length := data>>2 + 1
+		switch data & 3 {
+		case 0: // source read
+			if len(out)+length > len(rom) {
+				return nil, errors.New("patch reads past the source")
+			}
+			out = append(out, rom[len(out):len(out)+length]...)
+		case 1: // target read
+			out = append(out, r.bytes(length)...)
+		case 2: // source copy
+			sourceOffset += r.signed()
+			if sourceOffset < 0 || sourceOffset+length > len(rom) {
+				return nil, errors.New("patch copies past the source")
+			}
+			out = append(out, rom[sourceOffset:sourceOffset+length]...)
+			sourceOffset += length
+		case 3: // target copy, which may overlap what it writes
+			targetOffset += r.signed()
+			if targetOffset < 0 || targetOffset >= len(out) {
+				return nil, errors.New("patch copies past the output")
+			}
+			for i := 0; i < length; i++ {
+				out = append(out, out[targetOffset])
+				targetOffset++
+			}
+		}
+		if len(out) > targetSize {
+			return nil, errors.New("patch writes past the target size")
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(out) != targetSize {
+		return nil, errors.New("patch does not fill the target")
+	}
+	return out, r.checkTarget(out)
+}
+
+// patchReader decodes the body of a UPS or BPS patch, which ends in the
+// CRC32 of the source, the target and the rest of the patch. The first
+// error sticks.
+type patchReader struct {
+	patch  []byte
+	offset int
+	end    int // start of the checksums
+	err    error
+}
+
+func newPatchReader(patch, magic []byte) (*patchReader, error) {
+	end := len(patch) - 12
+	if end < len(magic) {
+		return nil, errTruncated
+	}
+	if crc32.ChecksumIEEE(patch[:end+8]) != binary.LittleEndian.Uint32(patch[end+8:]) {
+		return nil, errors.New("patch checksum mismatch")
+	}
+	return &patchReader{patch, len(magic), end, nil}, nil
+}
+
+func (r *patchReader) byte() byte {
+	if r.offset >= r.end {
+		r.err = errTruncated
+		return 0
+	}
+	r.offset++
+	return r.patch[r.offset-1]
+}
+
+func (r *patchReader) bytes(n int) []byte {
+	if n > r.end-r.offset {
+		r.err = errTruncated
+		return nil
+	}
+	r.offset += n
+	return r.patch[r.offset-n : r.offset]
+}
+
+func (r *patchReader) skip(n int) {
+	r.bytes(n)
+}
+
+// varint decodes the byuu variable-length encoding shared by UPS and BPS.
+func (r *patchReader) varint() int {
+	value, shift := 0, 1
+	for r.err == nil {
+		x := r.byte()
+		value += int(x&0x7F) * shift
+		if x&0x80 != 0 {
+			break
+		}
+		shift <<= 7
+		value += shift
+		if shift > 1<<42 {
+			r.err = errors.New("patch number is too large")
+		}
+	}
+	return value
+}
+
+// signed decodes a BPS relative offset, a varint with the sign in bit 0.
+func (r *patchReader) signed() int {
+	data := r.varint()
+	if data&1 != 0 {
+		return -(data >> 1)
+	}
+	return data >> 1
+}
+
+// checkSizes checks the patch was made for this ROM and asks for a sane
+// result.
+func (r *patchReader) checkSizes(rom []byte, sourceSize, targetSize int) error {
+	if targetSize > 2*maxROMSize {
+		return errors.New("patch target is too large")
+	}
+	if len(rom) != sourceSize || crc32.ChecksumIEEE(rom) != binary.LittleEndian.Uint32(r.patch[r.end:]) {
+		return errors.New("patch is for a different ROM")
+	}
+	return nil
+}
+
+func (r *patchReader) checkTarget(out []byte) error {
+	if crc32.ChecksumIEEE(out) != binary.LittleEndian.Uint32(r.patch[r.end+4:]) {
+		return errors.New("patched ROM checksum mismatch")
+	}
+	return nil
+}
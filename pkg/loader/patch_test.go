@@ -0,0 +1,210 @@
+package loader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/se-nonide/go6502/internal/nesrom"
+)
+
+func varint(v int) []byte {
+	var out []byte
+	for {
+		x := byte(v & 0x7F)
+		v >>= 7
+		if v == 0 {
+			return append(out, x|0x80)
+		}
+		out = append(out, x)
+		v--
+	}
+}
+
+// checksums appends the source, target and patch CRC32s.
+func checksums(patch, source, target []byte) []byte {
+	crc := make([]byte, 4)
+	for _, data := range [][]byte{source, target, nil} {
+		if data == nil {
+			data = patch
+		}
+		binary.LittleEndian.PutUint32(crc, crc32.ChecksumIEEE(data))
+		patch = append(patch, crc...)
+	}
+	return patch
+}
+
+// makeUPS XORs the differences between source and target into runs.
+func makeUPS(source, target []byte) []byte {
+	patch := append([]byte("UPS1"), varint(len(source))...)
+	patch = append(patch, varint(len(target))...)
+	at := func(data []byte, i int) byte {
+		if i < len(data) {
+			return data[i]
+		}
+		return 0
+	}
+	position := 0
+	for i := 0; i < len(target); i++ {
+		if at(source, i) == target[i] {
+			continue
+		}
+		patch = append(patch, varint(i-position)...)
+		for ; i < len(target) && at(source, i) != target[i]; i++ {
+			patch = append(patch, at(source, i)^target[i])
+		}
+		patch = append(patch, 0)
+		position = i + 1
+	}
+	return checksums(patch, source, target)
+}
+
+func TestIPS(t *testing.T) {
+	rom := []byte{0, 1, 2, 3, 4, 5}
+	patch := []byte("PATCH")
+	patch = append(patch, 0, 0, 1, 0, 2, 0xAA, 0xBB) // bytes at 1
+	patch = append(patch, 0, 0, 5, 0, 0, 0, 3, 0xCC) // run of 3 at 5
+	patch = append(patch, "EOF"...)
+	got, err := ApplyPatch(rom, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0, 0xAA, 0xBB, 3, 4, 0xCC, 0xCC, 0xCC}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got % X, want % X", got, want)
+	}
+	if rom[1] != 1 {
+		t.Error("patch modified the source")
+	}
+
+	got, err = ApplyPatch(rom, append(patch, 0, 0, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want[:4]) {
+		t.Errorf("truncated: got % X, want % X", got, want[:4])
+	}
+
+	if _, err := ApplyPatch(rom, patch[:len(patch)-4]); err == nil {
+		t.Error("truncated patch: no error")
+	}
+}
+
+func TestUPS(t *testing.T) {
+	source := []byte("the quick brown fox jumps")
+	target := []byte("the quack brown fix jumps over")
+	patch := makeUPS(source, target)
+	got, err := ApplyPatch(source, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, target) {
+		t.Errorf("got %q, want %q", got, target)
+	}
+
+	if _, err := ApplyPatch([]byte("the quick brown fox jumpz"), patch); err == nil {
+		t.Error("wrong source: no error")
+	}
+	patch[6] ^= 1
+	if _, err := ApplyPatch(source, patch); err == nil {
+		t.Error("corrupt patch: no error")
+	}
+}
+
+func TestBPS(t *testing.T) {
+	source := []byte("abcdefgh")
+	target := []byte("abcdXYfgXYfgXcd")
+	patch := append([]byte("BPS1"), varint(len(source))...)
+	patch = append(patch, varint(len(target))...)
+	patch = append(patch, varint(2)...)
+	patch = append(patch, "{}"...)           // metadata
+	patch = append(patch, varint(3<<2|0)...) // source read "abcd"
+	patch = append(patch, varint(1<<2|1)...) // target read "XY"
+	patch = append(patch, "XY"...)
+	patch = append(patch, varint(1<<2|2)...) // source copy "fg" from 5
+	patch = append(patch, varint(5<<1)...)
+	patch = append(patch, varint(4<<2|3)...) // target copy "XYfgX" from 4
+	patch = append(patch, varint(4<<1)...)
+	patch = append(patch, varint(1<<2|2)...) // source copy "cd" from 2
+	patch = append(patch, varint(5<<1|1)...)
+	patch = checksums(patch, source, target)
+	got, err := ApplyPatch(source, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, target) {
+		t.Errorf("got %q, want %q", got, target)
+	}
+
+	if _, err := ApplyPatch([]byte("abcdefgX"), patch); err == nil {
+		t.Error("wrong source: no error")
+	}
+	patch[len(patch)-5] ^= 1
+	if _, err := ApplyPatch(source, patch); err == nil {
+		t.Error("bad target checksum: no error")
+	}
+}
+
+func TestLoadNESFilePatched(t *testing.T) {
+	dir := t.TempDir()
+	rom := nesrom.Image([]byte{1, 1}, 0x4000, 0x2000)
+	path := filepath.Join(dir, "game.nes")
+	if err := os.WriteFile(path, rom, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the IPS patch sets the mapper and the first PRG byte, and the UPS
+	// patch made against its result changes the second
+	ips := append([]byte("PATCH"), 0, 0, 6, 0, 1, 0x10)
+	ips = append(ips, 0, 0, 16, 0, 1, 0x42)
+	ips = append(ips, "EOF"...)
+	patched, err := ApplyPatch(rom, ips)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := append([]byte(nil), patched...)
+	target[17] = 0x43
+	for name, data := range map[string][]byte{"game.ips": ips, "game.ups": makeUPS(patched, target)} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	patches := FindPatches(path)
+	want := []string{filepath.Join(dir, "game.ips"), filepath.Join(dir, "game.ups")}
+	if !reflect.DeepEqual(patches, want) {
+		t.Errorf("FindPatches = %v, want %v", patches, want)
+	}
+	if got := FindPatches(filepath.Join(dir, "game.nes.gz")); !reflect.DeepEqual(got, want) {
+		t.Errorf("FindPatches(game.nes.gz) = %v, want %v", got, want)
+	}
+
+	c, err := LoadNESFilePatched(path, patches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Mapper != 1 || c.PRG[0] != 0x42 || c.PRG[1] != 0x43 {
+		t.Errorf("loaded mapper %d, PRG % X", c.Mapper, c.PRG[:2])
+	}
+
+	// out of order the UPS checksum fails
+	if _, err := LoadNESFilePatched(path, []string{want[1], want[0]}); err == nil {
+		t.Error("patches out of order: no error")
+	}
+
+	c, err = LoadNESFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Mapper != 0 || c.PRG[0] != 0 {
+		t.Error("LoadNESFile applied the patches next to the ROM")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || !bytes.Equal(data, rom) {
+		t.Error("ROM on disk changed")
+	}
+}
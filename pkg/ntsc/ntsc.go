@@ -0,0 +1,199 @@
+// Package ntsc turns the PPU's color indices into the picture a television
+// decodes from the NES composite signal. Each dot is 8 samples of a square
+// wave at 12 samples per color subcarrier cycle; depending on the mode luma
+// and chroma are then separated from that signal with box filters, so
+// chroma leaking into luma gives the dot crawl, and wide chroma filters the
+// color fringing and artifact colors, of the real console.
+package ntsc
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/se-nonide/go6502/pkg/device6502"
+	"github.com/se-nonide/go6502/pkg/pallete"
+)
+
+const (
+	samplesPerDot = 8
+	lineSamples   = device6502.ScreenWidth * samplesPerDot
+	// each scanline is 341 dots, moving the phase by 341*8 mod 12
+	linePhase = 341 * samplesPerDot % 12
+
+	// output pixels per dot
+	outputScale = 2
+	Width       = device6502.ScreenWidth * outputScale
+	Height      = device6502.ScreenHeight
+)
+
+type Mode int
+
+const (
+	Composite Mode = iota // luma and chroma share one signal
+	SVideo                // luma and chroma on separate wires
+	RGB                   // colors straight from the palette
+)
+
+type Settings struct {
+	Mode Mode
+	// samples averaged for luma; below 12 the subcarrier leaks into it
+	LumaWidth int
+	// samples averaged for chroma; wider windows blur color further
+	ChromaWidth int
+	Decoder     pallete.Decoder
+}
+
+// Names of the presets, in the order the frontend cycles them.
+var Presets = []string{"composite", "svideo", "rgb"}
+
+func Preset(name string) (Settings, bool) {
+	switch name {
+	case "composite":
+		return Settings{Composite, 8, 24, pallete.DecoderYIQ}, true
+	case "svideo":
+		return Settings{SVideo, 4, 12, pallete.DecoderYIQ}, true
+	case "rgb":
+		return Settings{RGB, 0, 0, pallete.DecoderYIQ}, true
+	}
+	return Settings{}, false
+}
+
+// Filter renders frames with one set of Settings, reusing its buffers.
+type Filter struct {
+	Settings
+	Palette *pallete.Table // colors of the RGB mode
+
+	padding int
+	// running sums of luma and of chroma times the I and Q references
+	luma, i, q []float64
+	// what each color contributes to those sums at each phase
+	sample [512][12]contribution
+}
+
+type contribution struct {
+	luma, i, q float64
+}
+
+func New(settings Settings) *Filter {
+	padding := settings.LumaWidth
+	if settings.ChromaWidth > padding {
+		padding = settings.ChromaWidth
+	}
+	size := lineSamples + 2*padding + 1
+	f := &Filter{
+		Settings: settings,
+		Palette:  &pallete.Emphasized,
+		padding:  padding,
+		luma:     make([]float64, size),
+		i:        make([]float64, size),
+		q:        make([]float64, size),
+	}
+	for color := range f.sample {
+		var level float64
+		for phase := 0; phase < 12; phase++ {
+			level += pallete.Signal(uint16(color), phase) / 12
+		}
+		for phase := range f.sample[color] {
+			v := pallete.Signal(uint16(color), phase)
+			chroma := v
+			if settings.Mode == SVideo {
+				// luma travels apart, leaving only the subcarrier
+				chroma = v - level
+				v = level
+			}
+			cos, sin := pallete.Carrier(phase)
+			f.sample[color][phase] = contribution{v, chroma * cos, chroma * sin}
+		}
+	}
+	return f
+}
+
+// NewPreset returns a filter for one of Presets.
+func NewPreset(name string) (*Filter, error) {
+	settings, ok := Preset(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown NTSC filter %q, expected one of %v", name, Presets)
+	}
+	return New(settings), nil
+}
+
+// Render decodes frame into dst, allocating it when nil or of the wrong
+// size, and returns it. phase is the subcarrier phase at the frame's first
+// dot, as returned by Device.FramePhase.
+func (f *Filter) Render(frame *device6502.FrameBuffer, phase int, dst *image.RGBA) *image.RGBA {
+	if dst == nil || dst.Rect != image.Rect(0, 0, Width, Height) {
+		dst = image.NewRGBA(image.Rect(0, 0, Width, Height))
+	}
+	for y := 0; y < Height; y++ {
+		row := frame[y*device6502.ScreenWidth : (y+1)*device6502.ScreenWidth]
+		pixels := dst.Pix[y*dst.Stride:]
+		if f.Mode == RGB {
+			for x, index := range row {
+				c := f.Palette[index&0x1FF]
+				for k := 0; k < outputScale; k++ {
+					set(pixels, x*outputScale+k, c)
+				}
+			}
+			continue
+		}
+		f.modulate(row, (phase+y*linePhase)%12)
+		for x := 0; x < Width; x++ {
+			set(pixels, x, f.decode(x))
+		}
+	}
+	return dst
+}
+
+// modulate fills the running sums for one line starting at phase.
+func (f *Filter) modulate(row []uint16, phase int) {
+	var luma, i, q float64
+	for n := 0; n < len(f.luma)-1; n++ {
+		sample := n - f.padding
+		color := uint16(0x0F) // black outside the picture
+		if sample >= 0 && sample < lineSamples {
+			color = row[sample/samplesPerDot] & 0x1FF
+		}
+		s := &f.sample[color][(phase+sample%12+12)%12]
+		luma += s.luma
+		i += s.i
+		q += s.q
+		f.luma[n+1] = luma
+		f.i[n+1] = i
+		f.q[n+1] = q
+	}
+}
+
+// decode returns output pixel x from the sums of the current line.
+func (f *Filter) decode(x int) color.RGBA {
+	center := f.padding + x*samplesPerDot/outputScale + samplesPerDot/outputScale/2
+	y := average(f.luma, center, f.LumaWidth)
+	i := average(f.i, center, f.ChromaWidth)
+	q := average(f.q, center, f.ChromaWidth)
+	r, g, b := f.Decoder.RGB(y, i, q)
+	return color.RGBA{clamp(r), clamp(g), clamp(b), 0xFF}
+}
+
+// average returns the mean of width samples around center from their
+// running sums.
+func average(sums []float64, center, width int) float64 {
+	start := center - width/2
+	return (sums[start+width] - sums[start]) / float64(width)
+}
+
+func clamp(v float64) byte {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 1:
+		return 0xFF
+	}
+	return byte(v*255 + 0.5)
+}
+
+func set(pixels []byte, x int, c color.RGBA) {
+	pixels[x*4+0] = c.R
+	pixels[x*4+1] = c.G
+	pixels[x*4+2] = c.B
+	pixels[x*4+3] = c.A
+}
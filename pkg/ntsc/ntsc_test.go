@@ -0,0 +1,78 @@
+package ntsc
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/se-nonide/go6502/pkg/device6502"
+	"github.com/se-nonide/go6502/pkg/pallete"
+)
+
+func fill(frame *device6502.FrameBuffer, color func(x, y int) uint16) {
+	for y := 0; y < device6502.ScreenHeight; y++ {
+		for x := 0; x < device6502.ScreenWidth; x++ {
+			frame.Set(x, y, color(x, y))
+		}
+	}
+}
+
+func near(a, b byte) bool {
+	return a-b < 3 || b-a < 3
+}
+
+func TestFlatColors(t *testing.T) {
+	generated := pallete.Generator{}.Generate()
+	filter, _ := NewPreset("svideo")
+	frame := &device6502.FrameBuffer{}
+	for _, index := range []uint16{0x00, 0x16, 0x21, 0x2A, 0x30, 0x16 | 1<<6} {
+		fill(frame, func(x, y int) uint16 { return index })
+		image := filter.Render(frame, 0, nil)
+		got, want := image.RGBAAt(Width/2, Height/2), generated[index]
+		if !near(got.R, want.R) || !near(got.G, want.G) || !near(got.B, want.B) {
+			t.Errorf("color $%03X decoded as %v, want %v", index, got, want)
+		}
+	}
+}
+
+func TestDotCrawl(t *testing.T) {
+	frame := &device6502.FrameBuffer{}
+	fill(frame, func(x, y int) uint16 {
+		if x/2%2 == 0 {
+			return 0x30
+		}
+		return 0x0F
+	})
+	for _, test := range []struct {
+		name  string
+		crawl bool
+	}{{"composite", true}, {"svideo", false}, {"rgb", false}} {
+		filter, _ := NewPreset(test.name)
+		even := filter.Render(frame, 0, nil)
+		odd := filter.Render(frame, 4, nil)
+		if crawl := !bytes.Equal(even.Pix, odd.Pix); crawl != test.crawl {
+			t.Errorf("%s: picture changes with the phase: %v, want %v", test.name, crawl, test.crawl)
+		}
+		if even.Rect.Dx() != Width || even.Rect.Dy() != Height {
+			t.Errorf("%s: rendered %v", test.name, even.Rect)
+		}
+	}
+}
+
+func TestRenderPNG(t *testing.T) {
+	frame := &device6502.FrameBuffer{}
+	fill(frame, func(x, y int) uint16 { return uint16(x/16 + y/60*16) })
+	filter, _ := NewPreset("composite")
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, filter.Render(frame, 8, nil)); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := png.Decode(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Bounds() != image.Rect(0, 0, Width, Height) {
+		t.Errorf("PNG is %v", decoded.Bounds())
+	}
+}
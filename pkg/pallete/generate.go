@@ -0,0 +1,133 @@
+package pallete
+
+import (
+	"image/color"
+	"math"
+)
+
+// Composite levels of the 2C02 in volts, as measured on the NESdev wiki:
+// the low and high halves of the square wave for luma 0-3, then black and
+// white. Colors $xE and $xF output black; $x0 is all high and $xD all low.
+var (
+	signalLow  = [4]float64{0.350, 0.518, 0.962, 1.550}
+	signalHigh = [4]float64{1.094, 1.506, 1.962, 1.962}
+)
+
+const (
+	signalBlack = 0.518
+	signalWhite = 1.962
+	// emphasisLevel scales the signal during the phases an emphasis bit
+	// covers
+	emphasisLevel = 0.746
+)
+
+// Decoder turns demodulated luma and chroma into RGB.
+type Decoder int
+
+const (
+	DecoderYIQ Decoder = iota // FCC NTSC matrix
+	DecoderYUV                // PAL-style YUV matrix
+)
+
+// Generator builds a Table from the 2C02's composite signal, decoding each
+// color the way a television would.
+type Generator struct {
+	Decoder    Decoder
+	Hue        float64    // degrees added to every hue
+	Saturation float64    // chroma gain, 1 for none
+	Gamma      float64    // display gamma the output is corrected from, 0 for none
+	White      [3]float64 // per channel gain for the white point, zero for 1
+}
+
+// Generate computes all 512 colors.
+func (g Generator) Generate() *Table {
+	table := &Table{}
+	for i := range table {
+		table[i] = g.color(byte(i&0x3F), byte(i>>6))
+	}
+	return table
+}
+
+func (g Generator) color(index, emphasis byte) color.RGBA {
+	value := uint16(emphasis)<<6 | uint16(index)
+	hue := g.Hue * math.Pi / 180
+	var y, i, q float64
+	for phase := 0; phase < 12; phase++ {
+		v := Signal(value, phase)
+		cos, sin := Carrier(phase)
+		y += v
+		i += v * (cos*math.Cos(hue) - sin*math.Sin(hue))
+		q += v * (sin*math.Cos(hue) + cos*math.Sin(hue))
+	}
+	saturation := g.Saturation
+	if saturation == 0 {
+		saturation = 1
+	}
+	r, gr, b := g.Decoder.RGB(y/12, i*saturation/12, q*saturation/12)
+	return color.RGBA{g.channel(r, 0), g.channel(gr, 1), g.channel(b, 2), 0xFF}
+}
+
+// Signal returns the composite level the PPU outputs for a 9-bit color
+// index at a subcarrier phase from 0 to 11, scaled so black is 0 and white
+// is 1.
+func Signal(color uint16, phase int) float64 {
+	hue := int(color & 0x0F)
+	level := int(color>>4) & 3
+	emphasis := color >> 6
+	low, high := signalLow[level], signalHigh[level]
+	switch {
+	case hue == 0:
+		low = high
+	case hue == 0x0D:
+		high = low
+	case hue > 0x0D:
+		low, high = signalBlack, signalBlack
+	}
+	inPhase := func(hue int) bool {
+		return (hue+phase)%12 < 6
+	}
+	v := low
+	if inPhase(hue) {
+		v = high
+	}
+	if hue < 0x0E && ((emphasis&1 != 0 && inPhase(0x0C)) ||
+		(emphasis&2 != 0 && inPhase(0x04)) ||
+		(emphasis&4 != 0 && inPhase(0x08))) {
+		v *= emphasisLevel
+	}
+	return (v - signalBlack) / (signalWhite - signalBlack)
+}
+
+// Carrier returns the reference a decoder demodulates I and Q with at a
+// subcarrier phase from 0 to 11.
+func Carrier(phase int) (cos, sin float64) {
+	angle := math.Pi * float64(phase+4) / 6
+	return math.Cos(angle), math.Sin(angle)
+}
+
+// RGB converts luma and chroma to RGB, with 0 black and 1 white.
+func (d Decoder) RGB(y, i, q float64) (r, g, b float64) {
+	if d == DecoderYUV {
+		// the same chroma on the U (B-Y) and V (R-Y) axes, which sit 33
+		// degrees from I and Q
+		const rotation = 33 * math.Pi / 180
+		u := -i*math.Sin(rotation) + q*math.Cos(rotation)
+		v := i*math.Cos(rotation) + q*math.Sin(rotation)
+		return y + 1.139883*v, y - 0.394642*u - 0.580622*v, y + 2.032062*u
+	}
+	return y + 0.946882*i + 0.623557*q,
+		y - 0.274788*i - 0.635691*q,
+		y - 1.108545*i + 1.709007*q
+}
+
+func (g Generator) channel(value float64, index int) byte {
+	if g.White[index] != 0 {
+		value *= g.White[index]
+	}
+	value = math.Max(0, math.Min(1, value))
+	if g.Gamma != 0 {
+		// the CRT's gamma as seen through an sRGB display
+		value = math.Pow(value, g.Gamma/2.2)
+	}
+	return byte(value*255 + 0.5)
+}
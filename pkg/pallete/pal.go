@@ -0,0 +1,77 @@
+package pallete
+
+import (
+	"fmt"
+	"image/color"
+	"os"
+)
+
+// Sizes of the .pal files in use: 64 RGB triplets, or 512 covering every
+// emphasis combination.
+const (
+	PalSize         = 64 * 3
+	EmphasisPalSize = 512 * 3
+)
+
+// Decode parses a .pal file. Colors missing from 64-color files are
+// derived with Emphasize.
+func Decode(data []byte) (*Table, error) {
+	switch len(data) {
+	case PalSize:
+		var palette [64]color.RGBA
+		for i := range palette {
+			palette[i] = color.RGBA{data[i*3], data[i*3+1], data[i*3+2], 0xFF}
+		}
+		return NewTable(palette), nil
+	case EmphasisPalSize:
+		table := &Table{}
+		for i := range table {
+			table[i] = color.RGBA{data[i*3], data[i*3+1], data[i*3+2], 0xFF}
+		}
+		return table, nil
+	}
+	return nil, fmt.Errorf("invalid palette size %d, expected %d or %d bytes",
+		len(data), PalSize, EmphasisPalSize)
+}
+
+func LoadFile(path string) (*Table, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	table, err := Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return table, nil
+}
+
+// Encode returns the table as a .pal file, with the emphasized colors when
+// emphasis is set.
+func (table *Table) Encode(emphasis bool) []byte {
+	colors := table[:64]
+	if emphasis {
+		colors = table[:]
+	}
+	data := make([]byte, 0, len(colors)*3)
+	for _, c := range colors {
+		data = append(data, c.R, c.G, c.B)
+	}
+	return data
+}
+
+// SaveFile writes the full 512-color table to a .pal file.
+func (table *Table) SaveFile(path string) error {
+	return os.WriteFile(path, table.Encode(true), 0644)
+}
+
+// Load returns the named preset, or the .pal file at name otherwise.
+func Load(name string) (*Table, error) {
+	if table, ok := Preset(name); ok {
+		return table, nil
+	}
+	if _, err := os.Stat(name); err != nil {
+		return nil, fmt.Errorf("%q is neither a palette preset %v nor a readable file", name, Presets)
+	}
+	return LoadFile(name)
+}
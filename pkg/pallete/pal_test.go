@@ -0,0 +1,73 @@
+package pallete
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	data := Emphasized.Encode(false)
+	if len(data) != PalSize {
+		t.Fatalf("encoded %d bytes, want %d", len(data), PalSize)
+	}
+	table, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *table != Emphasized {
+		t.Error("64-color palette did not derive the default emphasis")
+	}
+
+	generated := Generator{}.Generate()
+	table, err = Decode(generated.Encode(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *table != *generated {
+		t.Error("512-color palette changed in a round trip")
+	}
+
+	if _, err := Decode(make([]byte, 100)); err == nil {
+		t.Error("expected an error for a 100 byte palette")
+	}
+}
+
+func TestSaveFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.pal")
+	table, _ := Preset("fceux")
+	if err := table.SaveFile(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded.Encode(true), table.Encode(true)) {
+		t.Error("saved palette differs")
+	}
+	if _, err := Load("no-such-preset"); err == nil {
+		t.Error("expected an error for an unknown preset")
+	}
+}
+
+func TestPresets(t *testing.T) {
+	for _, name := range Presets {
+		table, ok := Preset(name)
+		if !ok {
+			t.Fatalf("missing preset %q", name)
+		}
+		if table[0x0F] != table[0x1D] || table[0x0F].R+table[0x0F].G+table[0x0F].B != 0 {
+			t.Errorf("%s: $0F %v and $1D %v should both be black", name, table[0x0F], table[0x1D])
+		}
+		white, red := table[0x30], table[0x30|1<<6]
+		if red.G >= white.G || red.B >= white.B {
+			t.Errorf("%s: red emphasis did not dim green and blue: %v -> %v", name, white, red)
+		}
+	}
+	table, _ := Preset("default")
+	table[0] = table[1]
+	if Emphasized[0] == Emphasized[1] {
+		t.Error("Preset returned the shared default table")
+	}
+}
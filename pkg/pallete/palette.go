@@ -2,8 +2,20 @@ package pallete
 
 import "image/color"
 
+// Table maps the PPU's 9-bit color indices to RGB: bits 0-5 select the
+// color and bits 6-8 are the red, green and blue emphasis bits of PPUMASK.
+type Table [512]color.RGBA
+
+// Palette holds the 64 colors of the default palette with no emphasis.
 var Palette [64]color.RGBA
 
+// Emphasized is the default palette with emphasis applied.
+var Emphasized Table
+
+// emphasisAttenuation is how much each emphasis bit darkens the two
+// channels it does not emphasize.
+const emphasisAttenuation = 0.816328
+
 func init() {
 	colors := []uint32{
 		0x666666, 0x002A88, 0x1412A7, 0x3B00A4, 0x5C007E, 0x6E0040, 0x6C0600, 0x561D00,
@@ -15,10 +27,50 @@ func init() {
 		0xFFFEFF, 0xC0DFFF, 0xD3D2FF, 0xE8C8FF, 0xFBC2FF, 0xFEC4EA, 0xFECCC5, 0xF7D8A5,
 		0xE4E594, 0xCFEF96, 0xBDF4AB, 0xB3F3CC, 0xB5EBF2, 0xB8B8B8, 0x000000, 0x000000,
 	}
+	Palette = fromHex(colors)
+	Emphasized = *NewTable(Palette)
+}
+
+func fromHex(colors []uint32) [64]color.RGBA {
+	var palette [64]color.RGBA
 	for i, c := range colors {
 		r := byte(c >> 16)
 		g := byte(c >> 8)
 		b := byte(c)
-		Palette[i] = color.RGBA{r, g, b, 0xFF}
+		palette[i] = color.RGBA{r, g, b, 0xFF}
+	}
+	return palette
+}
+
+// NewTable extends a 64-color palette to all emphasis combinations.
+func NewTable(palette [64]color.RGBA) *Table {
+	table := &Table{}
+	for i := range table {
+		table[i] = Emphasize(palette[i%64], byte(i>>6))
+	}
+	return table
+}
+
+// Emphasize applies the PPUMASK emphasis bits (1 red, 2 green, 4 blue) to
+// c by attenuating the channels that are not emphasized.
+func Emphasize(c color.RGBA, emphasis byte) color.RGBA {
+	r, g, b := 1.0, 1.0, 1.0
+	if emphasis&1 != 0 {
+		g *= emphasisAttenuation
+		b *= emphasisAttenuation
+	}
+	if emphasis&2 != 0 {
+		r *= emphasisAttenuation
+		b *= emphasisAttenuation
+	}
+	if emphasis&4 != 0 {
+		r *= emphasisAttenuation
+		g *= emphasisAttenuation
+	}
+	return color.RGBA{
+		byte(float64(c.R) * r),
+		byte(float64(c.G) * g),
+		byte(float64(c.B) * b),
+		c.A,
 	}
 }
@@ -0,0 +1,43 @@
+package pallete
+
+// Names of the built-in palettes, in the order the frontend cycles them.
+var Presets = []string{"default", "2c02", "fceux", "nestopia-yuv", "pvm"}
+
+// fceux is FCEUX's default palette.
+var fceux = []uint32{
+	0x747474, 0x24188C, 0x0000A8, 0x44009C, 0x8C0074, 0xA80010, 0xA40000, 0x7C0800,
+	0x402C00, 0x004400, 0x005000, 0x003C14, 0x183C5C, 0x000000, 0x000000, 0x000000,
+	0xBCBCBC, 0x0070EC, 0x2038EC, 0x8000F0, 0xBC00BC, 0xE40058, 0xD82800, 0xC84C0C,
+	0x887000, 0x009400, 0x00A800, 0x009038, 0x008088, 0x000000, 0x000000, 0x000000,
+	0xFCFCFC, 0x3CBCFC, 0x5C94FC, 0xCC88FC, 0xF478FC, 0xFC74B4, 0xFC7460, 0xFC9838,
+	0xF0BC3C, 0x80D010, 0x4CDC48, 0x58F898, 0x00E8D8, 0x787878, 0x000000, 0x000000,
+	0xFCFCFC, 0xA8E4FC, 0xC4D4FC, 0xD4C8FC, 0xFCC4FC, 0xFCC4D8, 0xFCBCB0, 0xFCD8A8,
+	0xFCE4A0, 0xE0FCA0, 0xA8F0BC, 0xB0FCCC, 0x9CFCF0, 0xC4C4C4, 0x000000, 0x000000,
+}
+
+// Preset returns a copy of the named built-in palette. "2c02" is decoded
+// from the measured composite levels with the NTSC matrix, "nestopia-yuv"
+// decodes the same signal on the YUV axes like Nestopia's YUV mode, and
+// "pvm" approximates a Sony PVM: richer chroma, the CRT's gamma and a D93
+// white point.
+func Preset(name string) (*Table, bool) {
+	switch name {
+	case "default":
+		table := Emphasized
+		return &table, true
+	case "2c02":
+		return Generator{Decoder: DecoderYIQ}.Generate(), true
+	case "fceux":
+		return NewTable(fromHex(fceux)), true
+	case "nestopia-yuv":
+		return Generator{Decoder: DecoderYUV}.Generate(), true
+	case "pvm":
+		return Generator{
+			Decoder:    DecoderYIQ,
+			Saturation: 1.15,
+			Gamma:      2.5,
+			White:      [3]float64{0.93, 0.97, 1.08},
+		}.Generate(), true
+	}
+	return nil, false
+}
@@ -0,0 +1,269 @@
+// Package ppuview draws the PPU's memory for debugging: pattern tables,
+// nametables, sprites and palette RAM. Every view reads the live state
+// through the device, so CHR banking and mirroring are those of the moment.
+package ppuview
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+
+	"github.com/se-nonide/go6502/pkg/device6502"
+)
+
+// scrollColor outlines the visible window on the nametable view.
+var scrollColor = color.RGBA{0xFF, 0x00, 0x80, 0xFF}
+
+// viewer draws with the device's current palette RAM and colors.
+type viewer struct {
+	device  *device6502.Device
+	palette [32]byte
+}
+
+func newViewer(device *device6502.Device) *viewer {
+	return &viewer{device, device.PaletteRAM()}
+}
+
+// color returns the color of pixel value 0-3 under palette 0-7, where 4-7
+// are the sprite palettes.
+func (v *viewer) color(palette int, value byte) color.RGBA {
+	entry := v.palette[0]
+	if value != 0 {
+		entry = v.palette[palette*4+int(value)]
+	}
+	return v.device.Palette()[entry&0x3F]
+}
+
+// drawTile draws the 8x8 tile at address in the PPU space at (x, y),
+// skipping transparent pixels when opaque is false.
+func (v *viewer) drawTile(dst *image.RGBA, x, y int, address uint16, palette int, flipH, flipV, opaque bool) {
+	for row := 0; row < 8; row++ {
+		r := row
+		if flipV {
+			r = 7 - row
+		}
+		low := v.device.PeekPPU(address + uint16(r))
+		high := v.device.PeekPPU(address + uint16(r) + 8)
+		for column := 0; column < 8; column++ {
+			bit := uint(7 - column)
+			if flipH {
+				bit = uint(column)
+			}
+			value := (low>>bit)&1 | (high>>bit)&1<<1
+			if value == 0 && !opaque {
+				continue
+			}
+			dst.SetRGBA(x+column, y+row, v.color(palette, value))
+		}
+	}
+}
+
+// PatternTable draws the 256 tiles of pattern table 0 or 1 as a 128x128
+// image under palette 0-7.
+func PatternTable(device *device6502.Device, table, palette int) *image.RGBA {
+	v := newViewer(device)
+	dst := image.NewRGBA(image.Rect(0, 0, 128, 128))
+	for tile := 0; tile < 256; tile++ {
+		address := uint16(table&1)*0x1000 + uint16(tile)*16
+		v.drawTile(dst, tile%16*8, tile/16*8, address, palette&7, false, false, true)
+	}
+	return dst
+}
+
+// NameTables draws the four nametables as a 512x480 image with the
+// visible window outlined.
+func NameTables(device *device6502.Device) *image.RGBA {
+	v := newViewer(device)
+	dst := image.NewRGBA(image.Rect(0, 0, 512, 480))
+	patterns := device.BackgroundTable()
+	for table := 0; table < 4; table++ {
+		base := 0x2000 + uint16(table)*0x400
+		left, top := table%2*256, table/2*240
+		for row := 0; row < 30; row++ {
+			for column := 0; column < 32; column++ {
+				tile := device.PeekPPU(base + uint16(row*32+column))
+				attribute := device.PeekPPU(base + 0x3C0 + uint16(row/4*8+column/4))
+				shift := uint(row%4/2*4 + column%4/2*2)
+				palette := int(attribute>>shift) & 3
+				address := patterns + uint16(tile)*16
+				v.drawTile(dst, left+column*8, top+row*8, address, palette, false, false, true)
+			}
+		}
+	}
+	x, y := device.Scroll()
+	outline(dst, x, y, device6502.ScreenWidth, device6502.ScreenHeight)
+	return dst
+}
+
+// outline draws a w by h rectangle at (x, y), wrapping around the edges.
+func outline(dst *image.RGBA, x, y, w, h int) {
+	size := dst.Rect.Size()
+	set := func(px, py int) {
+		dst.SetRGBA((px%size.X+size.X)%size.X, (py%size.Y+size.Y)%size.Y, scrollColor)
+	}
+	for i := 0; i < w; i++ {
+		set(x+i, y)
+		set(x+i, y+h-1)
+	}
+	for i := 0; i < h; i++ {
+		set(x, y+i)
+		set(x+w-1, y+i)
+	}
+}
+
+// Sprite is one decoded OAM entry.
+type Sprite struct {
+	Index     int
+	X, Y      byte
+	Tile      byte
+	Palette   int  // 4-7
+	Behind    bool // drawn behind the background
+	FlipH     bool
+	FlipV     bool
+	Attribute byte
+}
+
+func (s Sprite) String() string {
+	flags := ""
+	if s.FlipH {
+		flags += " flipH"
+	}
+	if s.FlipV {
+		flags += " flipV"
+	}
+	if s.Behind {
+		flags += " behind"
+	}
+	return fmt.Sprintf("%2d: X=%3d Y=%3d tile=$%02X palette=%d%s", s.Index, s.X, s.Y, s.Tile, s.Palette, flags)
+}
+
+// Sprites decodes all 64 OAM entries.
+func Sprites(device *device6502.Device) []Sprite {
+	oam := device.OAM()
+	sprites := make([]Sprite, 64)
+	for i := range sprites {
+		a := oam[i*4+2]
+		sprites[i] = Sprite{
+			Index:     i,
+			Y:         oam[i*4+0],
+			Tile:      oam[i*4+1],
+			X:         oam[i*4+3],
+			Palette:   4 + int(a&3),
+			Behind:    a&0x20 != 0,
+			FlipH:     a&0x40 != 0,
+			FlipV:     a&0x80 != 0,
+			Attribute: a,
+		}
+	}
+	return sprites
+}
+
+// OAM draws the 64 sprites in an 8x8 grid of 8 by SpriteHeight cells, each
+// with its own palette and flips, over the backdrop color.
+func OAM(device *device6502.Device) *image.RGBA {
+	v := newViewer(device)
+	height := device.SpriteHeight()
+	dst := image.NewRGBA(image.Rect(0, 0, 64, 8*height))
+	backdrop := v.color(0, 0)
+	for i := range dst.Pix {
+		dst.Pix[i] = []byte{backdrop.R, backdrop.G, backdrop.B, backdrop.A}[i%4]
+	}
+	for _, s := range Sprites(device) {
+		x, y := s.Index%8*8, s.Index/8*height
+		if height == 8 {
+			address := device.SpriteTable() + uint16(s.Tile)*16
+			v.drawTile(dst, x, y, address, s.Palette, s.FlipH, s.FlipV, false)
+			continue
+		}
+		// 8x16 sprites: bit 0 picks the table, the top tile is even, and
+		// a vertical flip swaps the halves as well
+		address := uint16(s.Tile&1)*0x1000 + uint16(s.Tile&0xFE)*16
+		top, bottom := address, address+16
+		if s.FlipV {
+			top, bottom = bottom, top
+		}
+		v.drawTile(dst, x, y, top, s.Palette, s.FlipH, s.FlipV, false)
+		v.drawTile(dst, x, y+8, bottom, s.Palette, s.FlipH, s.FlipV, false)
+	}
+	return dst
+}
+
+// PaletteRAM draws the 32 palette entries as a 16x2 grid of 16 pixel
+// squares, background palettes on top.
+func PaletteRAM(device *device6502.Device) *image.RGBA {
+	const size = 16
+	ram := device.PaletteRAM()
+	dst := image.NewRGBA(image.Rect(0, 0, 16*size, 2*size))
+	for i, entry := range ram {
+		c := device.Palette()[entry&0x3F]
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				dst.SetRGBA(i%16*size+x, i/16*size+y, c)
+			}
+		}
+	}
+	return dst
+}
+
+// Overview draws every view on one 768x480 canvas for a live window: the
+// nametables on the left, and on the right both pattern tables side by
+// side, the sprites below them and the palette RAM at the bottom.
+func Overview(device *device6502.Device) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, 768, 480))
+	views := []struct {
+		x, y  int
+		image *image.RGBA
+	}{
+		{0, 0, NameTables(device)},
+		{512, 0, PatternTable(device, 0, 0)},
+		{640, 0, PatternTable(device, 1, 0)},
+		{512, 128, OAM(device)},
+		{512, 448, PaletteRAM(device)},
+	}
+	for _, view := range views {
+		at := image.Pt(view.x, view.y)
+		draw.Draw(dst, view.image.Rect.Add(at), view.image, image.Point{}, draw.Src)
+	}
+	return dst
+}
+
+// SavePNG writes an image to a PNG file.
+func SavePNG(path string, img image.Image) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Export saves every view as PNG files named prefix plus pattern0.png,
+// pattern1.png, nametables.png, oam.png and palette.png. Pattern tables
+// use the first background palette. It returns the files written.
+func Export(device *device6502.Device, prefix string) ([]string, error) {
+	views := []struct {
+		name  string
+		image *image.RGBA
+	}{
+		{"pattern0.png", PatternTable(device, 0, 0)},
+		{"pattern1.png", PatternTable(device, 1, 0)},
+		{"nametables.png", NameTables(device)},
+		{"oam.png", OAM(device)},
+		{"palette.png", PaletteRAM(device)},
+	}
+	var files []string
+	for _, view := range views {
+		path := prefix + view.name
+		if err := SavePNG(path, view.image); err != nil {
+			return files, err
+		}
+		files = append(files, path)
+	}
+	return files, nil
+}
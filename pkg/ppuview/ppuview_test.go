@@ -0,0 +1,122 @@
+package ppuview
+
+import (
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/se-nonide/go6502/internal/nesrom"
+	"github.com/se-nonide/go6502/internal/nesrom/nesdevice"
+	"github.com/se-nonide/go6502/pkg/device6502"
+)
+
+// newTestDevice runs a program that fills palette RAM, puts tile 1 at the
+// top-left of the first nametable, sets up sprite 0 and scrolls to (8, 16).
+// Tile 1 of the CHR ROM is solid color 1.
+func newTestDevice(t *testing.T) *device6502.Device {
+	var program []byte
+	store := func(address uint16, values ...byte) {
+		for _, value := range values {
+			program = append(program, 0xA9, value, 0x8D, byte(address), byte(address>>8))
+		}
+	}
+	palette := make([]byte, 32)
+	for i := range palette {
+		palette[i] = 0x0F
+	}
+	palette[1], palette[0x15] = 0x16, 0x2A
+	store(0x2006, 0x3F, 0x00)
+	store(0x2007, palette...)
+	store(0x2006, 0x20, 0x00)
+	store(0x2007, 1)
+	store(0x2003, 0)
+	store(0x2004, 10, 1, 0x41, 20) // flipped horizontally, palette 5
+	store(0x2005, 8, 16)
+	program = append(program, 0x4C, byte(len(program)), 0xC0)
+
+	chr := make([]byte, 24)
+	for i := 16; i < 24; i++ {
+		chr[i] = 0xFF
+	}
+	device, err := nesdevice.New(nesrom.NROM(program, chr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	device.StepFrame()
+	device.StepFrame()
+	return device
+}
+
+func checkPixel(t *testing.T, name string, img *image.RGBA, x, y int, want color.RGBA) {
+	t.Helper()
+	if got := img.RGBAAt(x, y); got != want {
+		t.Errorf("%s at (%d, %d) = %v, want %v", name, x, y, got, want)
+	}
+}
+
+func TestViews(t *testing.T) {
+	device := newTestDevice(t)
+	palette := device.Palette()
+
+	if x, y := device.Scroll(); x != 8 || y != 16 {
+		t.Errorf("Scroll() = (%d, %d), want (8, 16)", x, y)
+	}
+
+	patterns := PatternTable(device, 0, 0)
+	checkPixel(t, "pattern table", patterns, 0, 0, palette[0x0F])
+	checkPixel(t, "pattern table", patterns, 8, 0, palette[0x16])
+
+	tables := NameTables(device)
+	if size := tables.Rect.Size(); size != image.Pt(512, 480) {
+		t.Fatalf("nametables are %v", size)
+	}
+	checkPixel(t, "nametables", tables, 0, 0, palette[0x16])
+	checkPixel(t, "nametables", tables, 8, 0, palette[0x0F])
+	checkPixel(t, "nametables", tables, 8, 16, scrollColor)
+	checkPixel(t, "nametables", tables, 263, 255, scrollColor)
+
+	sprites := Sprites(device)
+	want := Sprite{Index: 0, X: 20, Y: 10, Tile: 1, Palette: 5, FlipH: true, Attribute: 0x41}
+	if len(sprites) != 64 || sprites[0] != want {
+		t.Errorf("sprite 0 = %+v, want %+v", sprites[0], want)
+	}
+	oam := OAM(device)
+	checkPixel(t, "OAM", oam, 0, 0, palette[0x2A])
+	checkPixel(t, "OAM", oam, 8, 0, palette[0x0F])
+
+	ram := PaletteRAM(device)
+	checkPixel(t, "palette RAM", ram, 16, 0, palette[0x16])
+	checkPixel(t, "palette RAM", ram, 5*16, 16, palette[0x2A])
+}
+
+func TestOverview(t *testing.T) {
+	device := newTestDevice(t)
+	palette := device.Palette()
+	overview := Overview(device)
+	if size := overview.Rect.Size(); size != image.Pt(768, 480) {
+		t.Fatalf("overview is %v", size)
+	}
+	checkPixel(t, "overview nametables", overview, 0, 0, palette[0x16])
+	checkPixel(t, "overview pattern table", overview, 512+8, 0, palette[0x16])
+	checkPixel(t, "overview OAM", overview, 512, 128, palette[0x2A])
+	checkPixel(t, "overview palette RAM", overview, 512+16, 448, palette[0x16])
+}
+
+func TestExport(t *testing.T) {
+	device := newTestDevice(t)
+	prefix := filepath.Join(t.TempDir(), "view-")
+	files, err := Export(device, prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 5 {
+		t.Fatalf("wrote %d files, want 5", len(files))
+	}
+	for _, file := range files {
+		if info, err := os.Stat(file); err != nil || info.Size() == 0 {
+			t.Errorf("%s: %v", file, err)
+		}
+	}
+}
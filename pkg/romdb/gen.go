@@ -0,0 +1,82 @@
+//go:build ignore
+// +build ignore
+
+// Gen rebuilds games.txt from the NES 2.0 header database, keeping the
+// comment at the top of the file and the games of the mappers the emulator
+// supports. It runs from the package directory:
+//
+//	go run gen.go path/to/nes20db.xml
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/se-nonide/go6502/pkg/romdb"
+)
+
+func main() {
+	mappers := flag.String("mappers", "0,1,2,3,4,7,16,40,225", "mappers to keep, or all")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		log.Fatal("usage: go run gen.go [-mappers list] nes20db.xml")
+	}
+	keep := map[int]bool{}
+	if *mappers != "all" {
+		for _, field := range strings.Split(*mappers, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil {
+				log.Fatalf("bad mapper %q", field)
+			}
+			keep[n] = true
+		}
+	}
+
+	all := romdb.New()
+	if err := all.ReadFile(flag.Arg(0)); err != nil {
+		log.Fatal(err)
+	}
+	db := romdb.New()
+	// Add puts each entry ahead of the earlier ones
+	entries := all.Entries()
+	for i := len(entries) - 1; i >= 0; i-- {
+		if len(keep) == 0 || keep[entries[i].Mapper] {
+			db.Add(entries[i])
+		}
+	}
+
+	header, err := readHeader("games.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	var out bytes.Buffer
+	out.Write(header)
+	out.WriteString("\n")
+	if err := db.Write(&out); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile("games.txt", out.Bytes(), 0644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("wrote %d of %d games", db.Len(), all.Len())
+}
+
+// readHeader returns the comment lines at the top of a database file.
+func readHeader(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var header bytes.Buffer
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() && strings.HasPrefix(scanner.Text(), "#") {
+		header.WriteString(scanner.Text() + "\n")
+	}
+	return header.Bytes(), scanner.Err()
+}
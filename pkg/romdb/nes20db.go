@@ -0,0 +1,107 @@
+package romdb
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// nes20dbGame is a <game> element of the NES 2.0 header database
+// (nes20db.xml). Its <rom> element hashes PRG-ROM followed by CHR-ROM, the
+// same bytes as Hash.
+type nes20dbGame struct {
+	Comment string `xml:",comment"`
+	ROM     struct {
+		CRC32 string `xml:"crc32,attr"`
+		SHA1  string `xml:"sha1,attr"`
+	} `xml:"rom"`
+	PCB struct {
+		Mapper    int    `xml:"mapper,attr"`
+		Submapper int    `xml:"submapper,attr"`
+		Mirroring string `xml:"mirroring,attr"`
+		Battery   int    `xml:"battery,attr"`
+	} `xml:"pcb"`
+	PRGRAM   nes20dbSize `xml:"prgram"`
+	PRGNVRAM nes20dbSize `xml:"prgnvram"`
+	CHRRAM   nes20dbSize `xml:"chrram"`
+	CHRNVRAM nes20dbSize `xml:"chrnvram"`
+	Console  struct {
+		Region int `xml:"region,attr"`
+	} `xml:"console"`
+	Expansion struct {
+		Type int `xml:"type,attr"`
+	} `xml:"expansion"`
+}
+
+type nes20dbSize struct {
+	Size int `xml:"size,attr"`
+}
+
+// ReadNES20DB adds the games of an NES 2.0 header database XML file. The
+// file names each game in a comment inside its <game> element.
+func (db *Database) ReadNES20DB(r io.Reader) error {
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "game" {
+			continue
+		}
+		var game nes20dbGame
+		if err := decoder.DecodeElement(&game, &start); err != nil {
+			return err
+		}
+		entry, err := game.entry()
+		if err != nil {
+			line, _ := decoder.InputPos()
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		db.Add(entry)
+	}
+}
+
+func (game *nes20dbGame) entry() (*Entry, error) {
+	crc, err := strconv.ParseUint(game.ROM.CRC32, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("bad CRC32 %q", game.ROM.CRC32)
+	}
+	if sum, err := hex.DecodeString(game.ROM.SHA1); err != nil || len(sum) != sha1.Size {
+		return nil, fmt.Errorf("bad SHA-1 %q", game.ROM.SHA1)
+	}
+	name := strings.TrimSpace(game.Comment)
+	if i := strings.LastIndexAny(name, `\/`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.TrimSuffix(name, ".nes")
+	entry := &Entry{
+		CRC32:     uint32(crc),
+		SHA1:      strings.ToLower(game.ROM.SHA1),
+		Name:      name,
+		Mapper:    game.PCB.Mapper,
+		Submapper: game.PCB.Submapper,
+		Battery:   game.PCB.Battery,
+		PRGRAM:    game.PRGRAM.Size,
+		PRGNVRAM:  game.PRGNVRAM.Size,
+		CHRRAM:    game.CHRRAM.Size,
+		CHRNVRAM:  game.CHRNVRAM.Size,
+		Timing:    game.Console.Region,
+		Input:     game.Expansion.Type,
+	}
+	if mirror, ok := parseMirror(strings.ToLower(game.PCB.Mirroring)); ok {
+		entry.Mirror = mirror
+	} else {
+		// mapper-controlled mirroring
+		entry.Mirror = -1
+	}
+	return entry, nil
+}
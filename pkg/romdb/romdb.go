@@ -0,0 +1,371 @@
+// Package romdb corrects cartridge headers from a database of known dumps,
+// looked up by the CRC32 and SHA-1 of their PRG and CHR ROM.
+package romdb
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/se-nonide/go6502/pkg/cartridge"
+)
+
+//go:embed games.txt
+var games string
+
+// mirrorFour is device6502.MirrorFour
+const mirrorFour = 4
+
+// Entry describes a known dump. Fields the database leaves out are -1 and
+// keep the header's value.
+type Entry struct {
+	CRC32     uint32
+	SHA1      string // lower-case hex, empty when not checked
+	Name      string
+	Mapper    int
+	Submapper int
+	Mirror    int // 0 horizontal, 1 vertical, 4 four-screen
+	Battery   int
+	PRGRAM    int // sizes in bytes
+	PRGNVRAM  int
+	CHRRAM    int
+	CHRNVRAM  int
+	Timing    int // a cartridge.Timing value
+	Input     int // NES 2.0 default expansion device
+}
+
+// Database maps ROM CRC32s to entries.
+type Database struct {
+	entries map[uint32][]*Entry
+}
+
+// Default is the database built into the emulator, which Correct uses.
+var Default = mustParse(games)
+
+func New() *Database {
+	return &Database{map[uint32][]*Entry{}}
+}
+
+func mustParse(text string) *Database {
+	db := New()
+	if err := db.Read(strings.NewReader(text)); err != nil {
+		log.Fatalf("built-in game database: %v", err)
+	}
+	return db
+}
+
+// Len returns the number of entries.
+func (db *Database) Len() int {
+	n := 0
+	for _, entries := range db.entries {
+		n += len(entries)
+	}
+	return n
+}
+
+// Add adds an entry, taking precedence over earlier ones for the same ROM.
+func (db *Database) Add(entry *Entry) {
+	db.entries[entry.CRC32] = append([]*Entry{entry}, db.entries[entry.CRC32]...)
+}
+
+// Entries returns every entry ordered by CRC32, those of a ROM in lookup
+// order.
+func (db *Database) Entries() []*Entry {
+	var all []*Entry
+	for _, entries := range db.entries {
+		all = append(all, entries...)
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].CRC32 < all[j].CRC32
+	})
+	return all
+}
+
+// ReadFile adds the entries of a database file: an NES 2.0 header database
+// when its name ends in .xml, or otherwise the format of games.txt.
+func (db *Database) ReadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	read := db.Read
+	if strings.EqualFold(filepath.Ext(path), ".xml") {
+		read = db.ReadNES20DB
+	}
+	if err := read(file); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// Read adds entries in the format described in games.txt.
+func (db *Database) Read(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		entry, err := parseEntry(text)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		db.Add(entry)
+	}
+	return scanner.Err()
+}
+
+// Write writes the entries in the format Read takes, in an order that reads
+// back to the same lookups.
+func (db *Database) Write(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	entries := db.Entries()
+	for start := 0; start < len(entries); {
+		end := start + 1
+		for end < len(entries) && entries[end].CRC32 == entries[start].CRC32 {
+			end++
+		}
+		// later lines take precedence
+		for i := end - 1; i >= start; i-- {
+			fmt.Fprintln(out, formatEntry(entries[i]))
+		}
+		start = end
+	}
+	return out.Flush()
+}
+
+func formatEntry(entry *Entry) string {
+	fields := []string{fmt.Sprintf("%08X", entry.CRC32), entry.SHA1}
+	if entry.SHA1 == "" {
+		fields[1] = "-"
+	}
+	value := func(n int, format func(int) string) {
+		if n < 0 {
+			fields = append(fields, "-")
+		} else {
+			fields = append(fields, format(n))
+		}
+	}
+	value(entry.Mapper, strconv.Itoa)
+	value(entry.Submapper, strconv.Itoa)
+	value(entry.Mirror, formatMirror)
+	value(entry.Battery, strconv.Itoa)
+	for _, size := range []int{entry.PRGRAM, entry.PRGNVRAM, entry.CHRRAM, entry.CHRNVRAM} {
+		value(size, formatSize)
+	}
+	value(entry.Timing, func(n int) string { return timings[n] })
+	value(entry.Input, strconv.Itoa)
+	name := entry.Name
+	if strings.TrimSpace(name) == "" {
+		name = fields[0]
+	}
+	return strings.Join(append(fields, name), " ")
+}
+
+func parseEntry(text string) (*Entry, error) {
+	const columns = 12
+	fields := strings.Fields(text)
+	if len(fields) <= columns {
+		return nil, fmt.Errorf("want %d columns and a name", columns)
+	}
+	entry := &Entry{Name: strings.Join(fields[columns:], " ")}
+	crc, err := strconv.ParseUint(fields[0], 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("bad CRC32 %q", fields[0])
+	}
+	entry.CRC32 = uint32(crc)
+	if fields[1] != "-" {
+		if sum, err := hex.DecodeString(fields[1]); err != nil || len(sum) != sha1.Size {
+			return nil, fmt.Errorf("bad SHA-1 %q", fields[1])
+		}
+		entry.SHA1 = strings.ToLower(fields[1])
+	}
+	values := []struct {
+		field *int
+		parse func(string) (int, bool)
+	}{
+		{&entry.Mapper, parseNumber},
+		{&entry.Submapper, parseNumber},
+		{&entry.Mirror, parseMirror},
+		{&entry.Battery, parseNumber},
+		{&entry.PRGRAM, parseSize},
+		{&entry.PRGNVRAM, parseSize},
+		{&entry.CHRRAM, parseSize},
+		{&entry.CHRNVRAM, parseSize},
+		{&entry.Timing, parseTiming},
+		{&entry.Input, parseNumber},
+	}
+	for i, value := range values {
+		field := fields[i+2]
+		if field == "-" {
+			*value.field = -1
+			continue
+		}
+		n, ok := value.parse(field)
+		if !ok {
+			return nil, fmt.Errorf("bad value %q in column %d", field, i+3)
+		}
+		*value.field = n
+	}
+	return entry, nil
+}
+
+func parseNumber(s string) (int, bool) {
+	n, err := strconv.ParseUint(s, 0, 16)
+	return int(n), err == nil
+}
+
+func parseSize(s string) (int, bool) {
+	scale := 1
+	if strings.HasSuffix(s, "k") {
+		s, scale = s[:len(s)-1], 1024
+	}
+	n, err := strconv.ParseUint(s, 10, 32)
+	return int(n) * scale, err == nil
+}
+
+func parseMirror(s string) (int, bool) {
+	switch s {
+	case "h":
+		return 0, true
+	case "v":
+		return 1, true
+	case "4":
+		return mirrorFour, true
+	}
+	return 0, false
+}
+
+func formatMirror(n int) string {
+	switch n {
+	case 0:
+		return "h"
+	case 1:
+		return "v"
+	}
+	return strconv.Itoa(n)
+}
+
+func formatSize(n int) string {
+	if n > 0 && n%1024 == 0 {
+		return strconv.Itoa(n/1024) + "k"
+	}
+	return strconv.Itoa(n)
+}
+
+var timings = []string{"ntsc", "pal", "multi", "dendy"}
+
+func parseTiming(s string) (int, bool) {
+	for i, name := range timings {
+		if s == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// ROM returns the bytes the database hashes: PRG-ROM followed by CHR-ROM,
+// leaving out CHR-RAM.
+func ROM(c *cartridge.Cartridge) []byte {
+	rom := append([]byte(nil), c.PRG...)
+	if c.CHRRAMSize+c.CHRNVRAMSize == 0 {
+		rom = append(rom, c.CHR...)
+	}
+	return rom
+}
+
+// Hash returns the CRC32 and hex SHA-1 of a cartridge's ROM.
+func Hash(c *cartridge.Cartridge) (uint32, string) {
+	rom := ROM(c)
+	sum := sha1.Sum(rom)
+	return crc32.ChecksumIEEE(rom), hex.EncodeToString(sum[:])
+}
+
+// Lookup finds the entry for a cartridge, or returns nil.
+func (db *Database) Lookup(c *cartridge.Cartridge) *Entry {
+	return db.LookupHash(Hash(c))
+}
+
+// LookupHash finds the entry for a ROM's CRC32 and hex SHA-1, or returns nil.
+func (db *Database) LookupHash(crc uint32, sum string) *Entry {
+	sum = strings.ToLower(sum)
+	for _, entry := range db.entries[crc] {
+		if entry.SHA1 == "" || entry.SHA1 == sum {
+			return entry
+		}
+	}
+	return nil
+}
+
+// Apply overrides the cartridge's header values with the entry's and
+// describes each one it changed.
+func (entry *Entry) Apply(c *cartridge.Cartridge) []string {
+	var changes []string
+	change := func(name string, from, to int) bool {
+		if to < 0 || from == to {
+			return false
+		}
+		changes = append(changes, fmt.Sprintf("%s %d -> %d", name, from, to))
+		return true
+	}
+	if change("mapper", int(c.Mapper), entry.Mapper) {
+		c.Mapper = uint16(entry.Mapper)
+	}
+	if change("submapper", int(c.Submapper), entry.Submapper) {
+		c.Submapper = byte(entry.Submapper)
+	}
+	if change("mirroring", int(c.Mirror), entry.Mirror) {
+		c.Mirror = byte(entry.Mirror)
+		c.VRAM = nil
+		if c.Mirror == mirrorFour {
+			c.VRAM = make([]byte, 0x800)
+		}
+	}
+	if change("battery", int(c.Battery), entry.Battery) {
+		c.Battery = byte(entry.Battery)
+	}
+	names := []string{"PRG-RAM", "PRG-NVRAM", "CHR-RAM", "CHR-NVRAM"}
+	sizes := []int{c.PRGRAMSize, c.PRGNVRAMSize, c.CHRRAMSize, c.CHRNVRAMSize}
+	resized := false
+	for i, size := range []int{entry.PRGRAM, entry.PRGNVRAM, entry.CHRRAM, entry.CHRNVRAM} {
+		if change(names[i], sizes[i], size) {
+			sizes[i] = size
+			resized = true
+		}
+	}
+	if resized {
+		c.SetRAMSizes(sizes[0], sizes[1], sizes[2], sizes[3])
+	}
+	if change("timing", int(c.Timing), entry.Timing) {
+		c.Timing = byte(entry.Timing)
+	}
+	if change("input", int(c.Expansion), entry.Input) {
+		c.Expansion = byte(entry.Input)
+	}
+	return changes
+}
+
+// Correct looks the cartridge up in the default database and applies the
+// entry found, logging what it changed.
+func Correct(c *cartridge.Cartridge) *Entry {
+	entry := Default.Lookup(c)
+	if entry == nil {
+		return nil
+	}
+	log.Printf("Game database: %s", entry.Name)
+	for _, change := range entry.Apply(c) {
+		log.Printf("Header corrected: %s", change)
+	}
+	return entry
+}
@@ -0,0 +1,194 @@
+package romdb
+
+import (
+	"bytes"
+	"hash/crc32"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/se-nonide/go6502/pkg/cartridge"
+)
+
+func newCartridge() *cartridge.Cartridge {
+	prg := make([]byte, 0x4000)
+	prg[0] = 0x42
+	return cartridge.NewCartridge(prg, make([]byte, 0x2000), 0, 0, 0)
+}
+
+func TestBuiltIn(t *testing.T) {
+	if err := New().Read(strings.NewReader(games)); err != nil {
+		t.Fatal(err)
+	}
+	entries := Default.Entries()
+	if len(entries) == 0 {
+		if os.Getenv("GO6502_REQUIRE_ROMS") != "" {
+			t.Fatal("games.txt has no entries, generate them with gen.go")
+		}
+		t.Skip("games.txt has no entries")
+	}
+	for _, entry := range entries {
+		if entry.SHA1 == "" {
+			continue
+		}
+		if found := Default.LookupHash(entry.CRC32, strings.ToUpper(entry.SHA1)); found == nil {
+			t.Errorf("%08X %s: %s not found", entry.CRC32, entry.SHA1, entry.Name)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	db := New()
+	err := db.Read(strings.NewReader(`
+1234ABCD - 4 1 v 1 - 8k - - pal 8 Some Game (E)
+1234abcd 0123456789abcdef0123456789abcdef01234567 - - 4 - 1000 - 32k 0 dendy - Other Dump
+00000001 - 0 0 h 0 0 0 8k 0 multi 1 First
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := db.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	read := New()
+	if err := read.Read(&out); err != nil {
+		t.Fatalf("%v in\n%s", err, out.String())
+	}
+	if !reflect.DeepEqual(read.Entries(), db.Entries()) {
+		t.Errorf("read back\n%s", out.String())
+	}
+	if entry := read.LookupHash(0x1234ABCD, "0123456789ABCDEF0123456789ABCDEF01234567"); entry == nil || entry.Name != "Other Dump" {
+		t.Errorf("LookupHash = %v", entry)
+	}
+}
+
+func TestRead(t *testing.T) {
+	db := New()
+	err := db.Read(strings.NewReader(`# comment
+
+1234ABCD - 4 1 v 1 - 8k - - pal 8 Some Game (E)
+1234abcd 0123456789abcdef0123456789abcdef01234567 - - 4 - - - - - - - Other Dump
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Len() != 2 {
+		t.Fatalf("read %d entries, want 2", db.Len())
+	}
+	want := &Entry{
+		CRC32: 0x1234ABCD, Name: "Some Game (E)", Mapper: 4, Submapper: 1, Mirror: 1,
+		Battery: 1, PRGRAM: -1, PRGNVRAM: 0x2000, CHRRAM: -1, CHRNVRAM: -1, Timing: 1, Input: 8,
+	}
+	if got := db.entries[0x1234ABCD][1]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+
+	for _, line := range []string{
+		"1234ABCD - 4 1 v 1 - 8k - - pal 8",
+		"XYZ - 4 1 v 1 - 8k - - pal 8 Game",
+		"1234ABCD 0123 4 1 v 1 - 8k - - pal 8 Game",
+		"1234ABCD - 4 1 x 1 - 8k - - pal 8 Game",
+		"1234ABCD - 4 1 v 1 - 8q - - pal 8 Game",
+		"1234ABCD - 4 1 v 1 - 8k - - secam 8 Game",
+	} {
+		if err := New().Read(strings.NewReader(line)); err == nil {
+			t.Errorf("%q: no error", line)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	c := newCartridge()
+	crc, sum := Hash(c)
+	db := New()
+	db.Add(&Entry{CRC32: crc, SHA1: strings.Repeat("0", 40), Name: "wrong SHA-1"})
+	if entry := db.Lookup(c); entry != nil {
+		t.Errorf("found %q despite the SHA-1", entry.Name)
+	}
+	db.Add(&Entry{CRC32: crc, SHA1: sum, Name: "match"})
+	if entry := db.Lookup(c); entry == nil || entry.Name != "match" {
+		t.Errorf("Lookup = %v", entry)
+	}
+
+	// CHR-RAM is not part of the ROM
+	c = cartridge.NewCartridge(c.PRG, nil, 0, 0, 0)
+	c.CHR[0] = 1
+	if got, _ := Hash(c); got != crc32.ChecksumIEEE(c.PRG) {
+		t.Errorf("hash of a CHR-RAM cartridge covers more than PRG")
+	}
+}
+
+func TestApply(t *testing.T) {
+	c := newCartridge()
+	entry := &Entry{
+		Mapper: 4, Submapper: -1, Mirror: 4, Battery: 1, PRGRAM: 0, PRGNVRAM: 0x8000,
+		CHRRAM: -1, CHRNVRAM: -1, Timing: cartridge.TimingPAL, Input: -1,
+	}
+	changes := entry.Apply(c)
+	want := []string{
+		"mapper 0 -> 4", "mirroring 0 -> 4", "battery 0 -> 1",
+		"PRG-RAM 8192 -> 0", "PRG-NVRAM 0 -> 32768", "timing 0 -> 1",
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes = %q\nwant %q", changes, want)
+	}
+	if c.Mapper != 4 || c.Battery != 1 || c.Timing != cartridge.TimingPAL {
+		t.Errorf("cartridge not corrected: %+v", c)
+	}
+	if len(c.VRAM) != 0x800 || len(c.SRAM) != 0x8000 || len(c.CHR) != 0x2000 {
+		t.Errorf("VRAM %d, SRAM %d, CHR %d bytes", len(c.VRAM), len(c.SRAM), len(c.CHR))
+	}
+	if changes := entry.Apply(c); len(changes) != 0 {
+		t.Errorf("second Apply changed %q", changes)
+	}
+}
+
+func TestReadNES20DB(t *testing.T) {
+	db := New()
+	err := db.ReadNES20DB(strings.NewReader(`<?xml version="1.0" encoding="UTF-8"?>
+<nes20db date="2024-01-01">
+	<game>
+		<!-- Games\Licensed\Some Game (Europe).nes -->
+		<prgrom size="131072" crc32="11111111" sha1="0000000000000000000000000000000000000000" sum16="0000"/>
+		<chrrom size="131072" crc32="22222222" sha1="0000000000000000000000000000000000000000" sum16="0000"/>
+		<rom size="262144" crc32="1234ABCD" sha1="0123456789ABCDEF0123456789ABCDEF01234567"/>
+		<prgnvram size="8192"/>
+		<pcb mapper="4" submapper="1" mirroring="V" battery="1"/>
+		<console type="0" region="1"/>
+		<expansion type="8"/>
+	</game>
+	<game>
+		<!-- Games\Licensed\Other Game (USA).nes -->
+		<rom size="262144" crc32="0000BEEF" sha1="0123456789ABCDEF0123456789ABCDEF01234567"/>
+		<pcb mapper="1" submapper="0" mirroring="H" battery="0"/>
+		<console type="0" region="0"/>
+		<expansion type="1"/>
+	</game>
+</nes20db>
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Len() != 2 {
+		t.Fatalf("read %d entries, want 2", db.Len())
+	}
+	want := &Entry{
+		CRC32: 0x1234ABCD, SHA1: "0123456789abcdef0123456789abcdef01234567", Name: "Some Game (Europe)",
+		Mapper: 4, Submapper: 1, Mirror: 1, Battery: 1, PRGNVRAM: 0x2000, Timing: 1, Input: 8,
+	}
+	if got := db.entries[0x1234ABCD][0]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+
+	for _, game := range []string{
+		`<game><rom crc32="XYZ" sha1="0123456789abcdef0123456789abcdef01234567"/></game>`,
+		`<game><rom crc32="1234ABCD" sha1="0123"/></game>`,
+		`<game><rom crc32="1234ABCD"`,
+	} {
+		if err := New().ReadNES20DB(strings.NewReader(game)); err == nil {
+			t.Errorf("%q: no error", game)
+		}
+	}
+}
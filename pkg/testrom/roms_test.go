@@ -0,0 +1,76 @@
+package testrom_test
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/se-nonide/go6502/pkg/testrom"
+	"github.com/se-nonide/go6502/pkg/testrom/testromtest"
+)
+
+//go:generate go run testdata/fetch.go
+
+// requireROMs makes missing ROMs from testdata/roms.txt fail the test
+// instead of skipping it, for CI that has run go generate.
+const requireROMs = "GO6502_REQUIRE_ROMS"
+
+// listedROMs reads the paths in testdata/roms.txt and the options given
+// after them.
+func listedROMs(t *testing.T) ([]string, map[string]testrom.Options) {
+	data, err := os.ReadFile(filepath.Join("testdata", "roms.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	options := map[string]testrom.Options{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		path := filepath.Join("testdata", filepath.FromSlash(fields[0]))
+		var rom testrom.Options
+		for _, field := range fields[1:] {
+			value := strings.TrimPrefix(field, "result=$")
+			address, err := strconv.ParseUint(value, 16, 16)
+			if value == field || err != nil {
+				t.Fatalf("roms.txt: bad option %q for %s", field, fields[0])
+			}
+			rom.ResultAddress = uint16(address)
+		}
+		paths = append(paths, path)
+		options[path] = rom
+	}
+	return paths, options
+}
+
+// TestROMs runs the ROMs listed in testdata/roms.txt, which go generate
+// downloads from nes-test-roms, and any other ROM placed under testdata to
+// track accuracy of the CPU, PPU, APU and mappers.
+func TestROMs(t *testing.T) {
+	paths, options := listedROMs(t)
+	filepath.Walk("testdata", func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) != ".nes" {
+			return nil
+		}
+		if _, listed := options[path]; !listed {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	for _, path := range paths {
+		path := path
+		t.Run(filepath.ToSlash(path), func(t *testing.T) {
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				if os.Getenv(requireROMs) != "" {
+					t.Fatalf("%s is missing, run go generate ./pkg/testrom", path)
+				}
+				t.Skipf("%s is missing, run go generate ./pkg/testrom to download it", path)
+			}
+			testromtest.Test(t, path, options[path])
+		})
+	}
+}
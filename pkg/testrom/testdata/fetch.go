@@ -0,0 +1,65 @@
+//go:build ignore
+// +build ignore
+
+// Fetch downloads the ROMs listed in roms.txt from the nes-test-roms
+// collection into testdata, skipping those already present. It runs from
+// the package directory through go generate ./pkg/testrom.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const baseURL = "https://raw.githubusercontent.com/christopherpow/nes-test-roms/master/"
+
+func main() {
+	file, err := os.Open("testdata/roms.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		path := filepath.Join("testdata", filepath.FromSlash(fields[0]))
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		if err := fetch(baseURL+fields[0], path); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("fetched", path)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func fetch(url, path string) error {
+	response, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, response.Status)
+	}
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,188 @@
+// Package testrom runs accuracy test ROMs headlessly and collects the
+// result they report through the $6000 protocol used by blargg's tests and
+// most of nes-test-roms: once $6001-$6003 hold DE B0 61, $6000 is the status
+// ($80 running, $81 reset wanted, anything below $80 the final result with 0
+// meaning passed) and $6004 starts a zero-terminated message.
+package testrom
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/se-nonide/go6502/pkg/device6502"
+)
+
+const (
+	statusAddress  = 0x6000
+	messageAddress = 0x6004
+	statusRunning  = 0x80
+	statusReset    = 0x81
+	maxMessage     = 0x1000
+)
+
+var signature = [3]byte{0xDE, 0xB0, 0x61}
+
+type Status int
+
+const (
+	Passed  Status = iota
+	Failed         // the ROM reported a non-zero result
+	Timeout        // no result before the time limit
+	Jammed         // the CPU halted or the device stopped with an error
+)
+
+func (s Status) String() string {
+	switch s {
+	case Passed:
+		return "PASS"
+	case Failed:
+		return "FAIL"
+	case Timeout:
+		return "TIMEOUT"
+	case Jammed:
+		return "JAM"
+	}
+	return fmt.Sprintf("Status(%d)", int(s))
+}
+
+type Options struct {
+	Timeout time.Duration // emulated time before giving up, 30s if zero
+	Fast    bool          // use the instruction-level CPU core
+
+	// ResultAddress is for ROMs older than the $6000 protocol, like
+	// blargg's 2005 PPU tests, that leave a result code in RAM: 1 passed,
+	// anything else the number of the failed test. Zero uses $6000.
+	ResultAddress uint16
+}
+
+type Result struct {
+	Path    string
+	Status  Status
+	Code    byte   // value the ROM left in $6000 or at ResultAddress
+	Message string // text at $6004, or the device error when jammed
+	Frames  int    // frames emulated
+}
+
+func (r *Result) String() string {
+	s := fmt.Sprintf("%s %s", r.Status, r.Path)
+	if r.Status == Failed {
+		s += fmt.Sprintf(" (code %d)", r.Code)
+	}
+	if r.Message != "" {
+		s += ": " + r.Message
+	}
+	return s
+}
+
+// Run loads the ROM at path and runs it until it reports a result.
+func Run(path string, options Options) (*Result, error) {
+	device, err := device6502.NewDevice(path)
+	if err != nil {
+		return nil, err
+	}
+	device.SetCycleAccurate(!options.Fast)
+	device.Reset()
+	var result *Result
+	if options.ResultAddress != 0 {
+		result = RunLegacy(device, options.ResultAddress, options.Timeout)
+	} else {
+		result = RunDevice(device, options.Timeout)
+	}
+	result.Path = path
+	return result, nil
+}
+
+// RunDevice steps an already reset device a frame at a time until the ROM
+// reports a result through $6000, the device stops or timeout elapses.
+func RunDevice(device *device6502.Device, timeout time.Duration) *Result {
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	framesPerSec := device.Region().FrameRate()
+	limit := int(timeout.Seconds() * framesPerSec)
+	result := &Result{}
+	resetAt := -1
+	for result.Frames = 0; result.Frames < limit; result.Frames++ {
+		device.StepFrame()
+		if err := device.Err(); err != nil {
+			result.Status = Jammed
+			result.Message = err.Error()
+			return result
+		}
+		if !signed(device) {
+			continue
+		}
+		switch status := device.Peek(statusAddress); {
+		case status == statusRunning:
+		case status == statusReset:
+			// the ROM wants the reset button held for at least 100ms
+			if resetAt < 0 {
+				resetAt = result.Frames + int(framesPerSec/10) + 1
+			} else if result.Frames >= resetAt {
+				device.Reset()
+				resetAt = -1
+			}
+		case status < statusRunning:
+			result.Code = status
+			result.Message = message(device)
+			if status != 0 {
+				result.Status = Failed
+			}
+			return result
+		}
+	}
+	result.Status = Timeout
+	if signed(device) {
+		result.Message = message(device)
+	}
+	return result
+}
+
+// RunLegacy steps an already reset device a frame at a time until the byte
+// at address holds a result code, the device stops or timeout elapses.
+func RunLegacy(device *device6502.Device, address uint16, timeout time.Duration) *Result {
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	limit := int(timeout.Seconds() * device.Region().FrameRate())
+	result := &Result{}
+	for result.Frames = 0; result.Frames < limit; result.Frames++ {
+		device.StepFrame()
+		if err := device.Err(); err != nil {
+			result.Status = Jammed
+			result.Message = err.Error()
+			return result
+		}
+		if code := device.Peek(address); code != 0 {
+			result.Code = code
+			if code != 1 {
+				result.Status = Failed
+			}
+			return result
+		}
+	}
+	result.Status = Timeout
+	return result
+}
+
+func signed(device *device6502.Device) bool {
+	for i, b := range signature {
+		if device.Peek(statusAddress+1+uint16(i)) != b {
+			return false
+		}
+	}
+	return true
+}
+
+func message(device *device6502.Device) string {
+	var text []byte
+	for i := uint16(0); i < maxMessage; i++ {
+		b := device.Peek(messageAddress + i)
+		if b == 0 {
+			break
+		}
+		text = append(text, b)
+	}
+	return strings.TrimSpace(string(text))
+}
@@ -0,0 +1,98 @@
+package testrom
+
+import (
+	"testing"
+	"time"
+
+	"github.com/se-nonide/go6502/internal/nesrom"
+)
+
+// asm collects hand-assembled 6502 code for a ROM based at $C000.
+type asm []byte
+
+func (a asm) store(address uint16, values ...byte) asm {
+	for i, v := range values {
+		at := address + uint16(i)
+		a = append(a, 0xA9, v, 0x8D, byte(at), byte(at>>8)) // LDA #v; STA at
+	}
+	return a
+}
+
+func (a asm) report(status byte, text string) asm {
+	a = a.store(statusAddress+1, signature[:]...)
+	a = a.store(messageAddress, append([]byte(text), 0)...)
+	return a.store(statusAddress, status)
+}
+
+func (a asm) trap() asm {
+	at := nesrom.Origin + uint16(len(a))
+	return append(a, 0x4C, byte(at), byte(at>>8)) // JMP *
+}
+
+// writeROM wraps code in a 16KB NROM image and returns its path.
+func writeROM(t *testing.T, code asm) string {
+	path, err := nesrom.Write(t.TempDir(), nesrom.NROM(code, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRun(t *testing.T) {
+	// boots once asking for a reset, then passes: LDA $6100; BNE second
+	resetting := asm{0xAD, 0x00, 0x61, 0xD0, 0x00}
+	resetting = resetting.store(0x6100, 1).report(statusReset, "").trap()
+	resetting[4] = byte(len(resetting) - 5)
+	resetting = resetting.report(0, "after reset").trap()
+
+	tests := []struct {
+		name    string
+		rom     asm
+		status  Status
+		code    byte
+		message string
+	}{
+		{"pass", asm{}.report(0, "\nPassed\n").trap(), Passed, 0, "Passed"},
+		{"fail", asm{}.report(3, "Failed #3").trap(), Failed, 3, "Failed #3"},
+		{"running", asm{}.report(statusRunning, "still going").trap(), Timeout, statusRunning, "still going"},
+		{"silent", asm{}.store(statusAddress, 0).trap(), Timeout, 0, ""},
+		{"reset", resetting, Passed, 0, "after reset"},
+		{"jam", asm{0x02}, Jammed, 0, "CPU jammed at $C000 (opcode $02)"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, fast := range []bool{false, true} {
+				result, err := Run(writeROM(t, test.rom), Options{time.Second, fast, 0})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if result.Status != test.status || result.Message != test.message ||
+					(test.status == Failed && result.Code != test.code) {
+					t.Errorf("fast %v: got %v", fast, result)
+				}
+			}
+		})
+	}
+}
+
+func TestRunLegacy(t *testing.T) {
+	tests := []struct {
+		name   string
+		rom    asm
+		status Status
+		code   byte
+	}{
+		{"pass", asm{}.store(0xF8, 1).trap(), Passed, 1},
+		{"fail", asm{}.store(0xF8, 4).trap(), Failed, 4},
+		{"silent", asm{}.trap(), Timeout, 0},
+	}
+	for _, test := range tests {
+		result, err := Run(writeROM(t, test.rom), Options{time.Second, false, 0xF8})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Status != test.status || result.Code != test.code {
+			t.Errorf("%s: got %v, code %d", test.name, result, result.Code)
+		}
+	}
+}
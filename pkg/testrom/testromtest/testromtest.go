@@ -0,0 +1,22 @@
+// Package testromtest runs accuracy test ROMs from Go tests, keeping the
+// testing dependency out of package testrom.
+package testromtest
+
+import (
+	"testing"
+
+	"github.com/se-nonide/go6502/pkg/testrom"
+)
+
+// Test runs the ROM at path as part of a Go test, failing t unless the ROM
+// reports success.
+func Test(t testing.TB, path string, options testrom.Options) {
+	t.Helper()
+	result, err := testrom.Run(path, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != testrom.Passed {
+		t.Error(result)
+	}
+}